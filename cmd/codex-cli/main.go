@@ -2,13 +2,18 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/evertonmj/codex/app"
+	"golang.org/x/term"
 )
 
 func main() {
@@ -17,13 +22,19 @@ func main() {
 	useHome := flag.Bool("home", false, "Create database in home directory (~/.codex/). Use with optional database name.")
 	dbName := flag.String("name", "", "Database name (used with --home flag). Format: NAME_TIMESTAMP_HASH.db")
 	ledgerMode := flag.Bool("ledger", false, "Enable append-only ledger mode.")
+	hybridMode := flag.Bool("hybrid", false, "Enable hybrid mode: a write-ahead log plus periodic checkpoints.")
+	codecName := flag.String("codec", "json", "Value codec the database was written with: json, gob, msgpack, or raw.")
+	lockTimeout := flag.Duration("lock-timeout", 0, "How long to wait for another process to release the database, e.g. 5s.")
+	usePassphrase := flag.Bool("passphrase", false, "Encrypt with a passphrase, read from CODEX_PASSPHRASE or prompted for without echo.")
+	kdfName := flag.String("kdf", "argon2id", "Key derivation for a new passphrase-encrypted database: argon2id or scrypt.")
+	readOnly := flag.Bool("readonly", false, "Open an existing database read-only, alongside a running writer. Implied by get, keys, has, query, history, and verify with --file.")
 
 	flag.Parse()
 
 	// Get command and arguments
 	args := flag.Args()
 	if len(args) < 1 {
-		fatalf("Usage: codex-cli [--file path | --home [--name dbname]] [--ledger] <command> [args]\nCommands: set, get, delete, keys, has, clear, interactive")
+		fatalf("Usage: codex-cli [--file path | --home [--name dbname]] [--ledger | --hybrid] [--codec name] [--passphrase [--kdf name]] [--readonly] [--lock-timeout d] <command> [args]\nCommands: set, get, delete, keys, has, clear, query, history, verify, compact, rekey, interactive")
 	}
 
 	// Read encryption key from environment variable for security
@@ -35,7 +46,47 @@ func main() {
 
 	opts := codex.Options{
 		LedgerMode:    *ledgerMode,
+		HybridMode:    *hybridMode,
 		EncryptionKey: keyBytes,
+		ReadOnly:      *readOnly,
+		LockTimeout:   *lockTimeout,
+	}
+	// Commands that only read an existing file do not need the writer lock
+	switch args[0] {
+	case "get", "keys", "has", "query", "history", "verify":
+		opts.ReadOnly = opts.ReadOnly || *filePath != ""
+	}
+	if *usePassphrase {
+		if keyBytes != nil {
+			fatalf("Error: CODEX_KEY and --passphrase cannot both be used")
+		}
+		// Only a new database asks for the passphrase twice
+		_, statErr := os.Stat(*filePath)
+		passphrase, err := readSecret("CODEX_PASSPHRASE", "Passphrase", *useHome || os.IsNotExist(statErr))
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		opts.Passphrase = passphrase
+		switch *kdfName {
+		case "argon2id":
+			opts.KDF.Algorithm = codex.KDFArgon2id
+		case "scrypt":
+			opts.KDF.Algorithm = codex.KDFScrypt
+		default:
+			fatalf("Error: unknown key derivation %q (use argon2id or scrypt)", *kdfName)
+		}
+	}
+	switch *codecName {
+	case "json":
+		opts.Codec = codex.JSONCodec
+	case "gob":
+		opts.Codec = codex.GobCodec
+	case "msgpack":
+		opts.Codec = codex.MessagePackCodec
+	case "raw":
+		opts.Codec = codex.RawCodec
+	default:
+		fatalf("Error: unknown codec %q (use json, gob, msgpack, or raw)", *codecName)
 	}
 
 	// Create or open the store
@@ -66,15 +117,15 @@ func main() {
 	cmdArgs := args[1:]
 
 	if command == "interactive" {
-		runInteractive(store)
+		runInteractive(store, *usePassphrase)
 	} else {
-		if err := executeCommand(store, command, cmdArgs); err != nil {
+		if err := executeCommand(store, *usePassphrase, command, cmdArgs); err != nil {
 			fatalf("%v", err)
 		}
 	}
 }
 
-func runInteractive(store *codex.Store) {
+func runInteractive(store *codex.Store, passphrase bool) {
 	fmt.Println("CodexDB Interactive Mode. Type 'exit' or 'quit' to leave.")
 	scanner := bufio.NewScanner(os.Stdin)
 	for {
@@ -96,13 +147,15 @@ func runInteractive(store *codex.Store) {
 			break
 		}
 
-		if err := executeCommand(store, command, args); err != nil {
+		if err := executeCommand(store, passphrase, command, args); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		}
 	}
 }
 
-func executeCommand(store *codex.Store, command string, args []string) error {
+// executeCommand runs command on store; passphrase reports whether the
+// store was opened with --passphrase.
+func executeCommand(store *codex.Store, passphrase bool, command string, args []string) error {
 	switch command {
 	case "set":
 		if len(args) < 2 {
@@ -169,12 +222,289 @@ func executeCommand(store *codex.Store, command string, args []string) error {
 		}
 		fmt.Println("OK")
 
+	case "compact":
+		if len(args) != 0 {
+			return fmt.Errorf("usage: compact")
+		}
+		if err := store.Compact(); err != nil {
+			return fmt.Errorf("compact failed: %v", err)
+		}
+		fmt.Println("OK")
+
+	case "rekey":
+		if len(args) != 0 {
+			return fmt.Errorf("usage: rekey")
+		}
+		if passphrase {
+			newPass, err := readSecret("CODEX_NEW_PASSPHRASE", "New passphrase", true)
+			if err != nil {
+				return err
+			}
+			if err := store.RotatePassphrase(newPass); err != nil {
+				return fmt.Errorf("rekey failed: %v", err)
+			}
+			fmt.Println("OK: use the new passphrase from now on")
+			break
+		}
+		newKey, err := readSecret("CODEX_NEW_KEY", "New key", true)
+		if err != nil {
+			return err
+		}
+		if err := store.RotateKey(newKey); err != nil {
+			return fmt.Errorf("rekey failed: %v", err)
+		}
+		fmt.Println("OK: use the new key from now on")
+
+	case "query":
+		return runQuery(store, args)
+
+	case "history":
+		return runHistory(store, args)
+
+	case "verify":
+		if len(args) != 0 {
+			return fmt.Errorf("usage: verify")
+		}
+		report, err := store.VerifyChain()
+		if errors.Is(err, codex.ErrUnchained) {
+			fmt.Printf("WARNING: %d entries, none chained, so none were verified; run compact to start a chain\n", report.Entries)
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("verify failed: %v", err)
+		}
+		fmt.Printf("OK: %d entries, %d Merkle roots, head %x\n", report.Entries, report.Roots, report.Head)
+		if report.Unchained > 0 {
+			fmt.Printf("%d entries written before the chain were not verified\n", report.Unchained)
+		}
+
 	default:
 		return fmt.Errorf("unknown command: %s", command)
 	}
 	return nil
 }
 
+// runHistory parses and runs the history command, which prints one line
+// per change to key: sequence number, time, change type, and value.
+//
+//	history <key> [since <time>] [until <time>] [limit <n>] [desc]
+//
+// Times are RFC 3339, e.g. 2025-01-02T15:04:05Z.
+func runHistory(store *codex.Store, args []string) error {
+	usage := fmt.Errorf("usage: history <key> [since <time>] [until <time>] [limit <n>] [desc]")
+	if len(args) < 1 {
+		return usage
+	}
+	key, args := args[0], args[1:]
+
+	var opts codex.HistoryOptions
+	for len(args) > 0 {
+		switch args[0] {
+		case "since", "until":
+			if len(args) < 2 {
+				return usage
+			}
+			t, err := time.Parse(time.RFC3339, args[1])
+			if err != nil {
+				return fmt.Errorf("invalid %s: %v", args[0], err)
+			}
+			if args[0] == "since" {
+				opts.Since = t
+			} else {
+				opts.Until = t
+			}
+			args = args[2:]
+		case "limit":
+			if len(args) < 2 {
+				return usage
+			}
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid limit: %v", err)
+			}
+			opts.Limit = n
+			args = args[2:]
+		case "desc":
+			opts.Reverse = true
+			args = args[1:]
+		default:
+			return usage
+		}
+	}
+
+	changes, err := store.History(key, opts)
+	if err != nil {
+		return fmt.Errorf("history failed: %v", err)
+	}
+	for _, c := range changes {
+		when := "-"
+		if !c.Time.IsZero() {
+			when = c.Time.Format(time.RFC3339Nano)
+		}
+		value := string(c.Value)
+		if c.Compacted {
+			value += "\t(compacted)"
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\n", c.Seq, when, c.Type, value)
+	}
+	return nil
+}
+
+// queryKeywords start the clauses of the query command.
+var queryKeywords = map[string]bool{
+	"where": true, "select": true, "order": true, "limit": true, "offset": true,
+	"count": true, "sum": true, "min": true, "max": true,
+}
+
+// runQuery parses and runs the query command:
+//
+//	query [prefix] [where <path> <op> <value>]... [select <path>[,<path>...]]
+//	      [order <path> [asc|desc]]... [limit <n>] [offset <n>]
+//	      [count | sum <path> | min <path> | max <path>]
+//
+// Values are parsed as JSON and fall back to plain strings, so
+// `where $.status = pending` works without quoting.
+func runQuery(store *codex.Store, args []string) error {
+	usage := fmt.Errorf("usage: query [prefix] [where <path> <op> <value>]... [select <paths>] [order <path> [asc|desc]] [limit <n>] [offset <n>] [count | sum|min|max <path>]")
+
+	prefix := ""
+	if len(args) > 0 && !queryKeywords[args[0]] {
+		prefix, args = args[0], args[1:]
+	}
+	q := store.Query(prefix)
+
+	aggregate, aggregatePath := "", ""
+	for len(args) > 0 {
+		switch args[0] {
+		case "where":
+			if len(args) >= 3 && args[2] == "exists" {
+				q.Where(args[1], "exists", nil)
+				args = args[3:]
+				continue
+			}
+			if len(args) < 4 {
+				return usage
+			}
+			var value interface{}
+			if err := json.Unmarshal([]byte(args[3]), &value); err != nil {
+				value = args[3]
+			}
+			q.Where(args[1], args[2], value)
+			args = args[4:]
+		case "select":
+			if len(args) < 2 {
+				return usage
+			}
+			q.Select(strings.Split(args[1], ",")...)
+			args = args[2:]
+		case "order":
+			if len(args) < 2 {
+				return usage
+			}
+			desc := false
+			rest := args[2:]
+			if len(rest) > 0 && (rest[0] == "asc" || rest[0] == "desc") {
+				desc = rest[0] == "desc"
+				rest = rest[1:]
+			}
+			q.OrderBy(args[1], desc)
+			args = rest
+		case "limit", "offset":
+			if len(args) < 2 {
+				return usage
+			}
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid %s: %v", args[0], err)
+			}
+			if args[0] == "limit" {
+				q.Limit(n)
+			} else {
+				q.Offset(n)
+			}
+			args = args[2:]
+		case "count":
+			aggregate = "count"
+			args = args[1:]
+		case "sum", "min", "max":
+			if len(args) < 2 {
+				return usage
+			}
+			aggregate, aggregatePath = args[0], args[1]
+			args = args[2:]
+		default:
+			return usage
+		}
+	}
+
+	var result interface{}
+	var err error
+	switch aggregate {
+	case "count":
+		result, err = q.Count()
+	case "sum":
+		result, err = q.Sum(aggregatePath)
+	case "min":
+		result, err = q.Min(aggregatePath)
+	case "max":
+		result, err = q.Max(aggregatePath)
+	default:
+		results, err := q.All()
+		if err != nil {
+			return fmt.Errorf("query failed: %v", err)
+		}
+		for _, r := range results {
+			fmt.Printf("%s\t%s\n", r.Key, r.Value)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+	jsonVal, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to format output: %v", err)
+	}
+	fmt.Println(string(jsonVal))
+	return nil
+}
+
+// readSecret returns the secret in the environment variable env, or
+// prompts for it on the terminal without echoing it, twice if confirm is
+// set.
+func readSecret(env, label string, confirm bool) ([]byte, error) {
+	if value := os.Getenv(env); value != "" {
+		return []byte(value), nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("no terminal to prompt for the %s on; set %s", strings.ToLower(label), env)
+	}
+
+	prompt := func(label string) ([]byte, error) {
+		fmt.Fprint(os.Stderr, label)
+		defer fmt.Fprintln(os.Stderr)
+		return term.ReadPassword(fd)
+	}
+	secret, err := prompt(label + ": ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", strings.ToLower(label), err)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("%s must not be empty", strings.ToLower(label))
+	}
+	if confirm {
+		again, err := prompt("Repeat " + strings.ToLower(label) + ": ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", strings.ToLower(label), err)
+		}
+		if !bytes.Equal(secret, again) {
+			return nil, fmt.Errorf("%ss do not match", strings.ToLower(label))
+		}
+	}
+	return secret, nil
+}
+
 func fatalf(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, format+"\n", args...)
 	os.Exit(1)
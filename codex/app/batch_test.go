@@ -2,12 +2,14 @@ package app
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 )
 
 func TestBatchOperations(t *testing.T) {
 	tmpFile := "test_batch.db"
 	defer os.Remove(tmpFile)
+	defer os.Remove(tmpFile + ".rlock")
 
 	store, err := New(tmpFile)
 	if err != nil {
@@ -81,6 +83,7 @@ func TestBatchOperations(t *testing.T) {
 func TestBatchPersistence(t *testing.T) {
 	tmpFile := "test_batch_persist.db"
 	defer os.Remove(tmpFile)
+	defer os.Remove(tmpFile + ".rlock")
 
 	// Create store and batch set
 	store, _ := New(tmpFile)
@@ -100,3 +103,31 @@ func TestBatchPersistence(t *testing.T) {
 		t.Errorf("Expected 3 persisted values, got %d", len(results))
 	}
 }
+
+func TestBatchCrashLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	opts := Options{LedgerMode: true}
+	store, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	store.Set("before", 1)
+	before := fileSize(t, path)
+	if err := store.NewBatch().Set("a", 1).Set("b", 2).Delete("before").Execute(); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	store.Close()
+
+	// A crash halfway through the batch write leaves a torn tail
+	if err := os.Truncate(path, (before+fileSize(t, path))/2); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if store.Has("a") || store.Has("b") || !store.Has("before") {
+		t.Errorf("expected none of the batch after the crash, got keys %v", store.Keys())
+	}
+}
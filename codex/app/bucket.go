@@ -0,0 +1,254 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/evertonmj/codex/codex/app/src/batch"
+	"github.com/evertonmj/codex/codex/app/src/keyindex"
+	"github.com/evertonmj/codex/codex/app/src/storage"
+)
+
+// keyspace holds the keys of the default keyspace or of one named bucket.
+type keyspace struct {
+	name     string                     // Bucket name ("" for the default keyspace)
+	data     map[string][]byte          // Raw JSON values by key
+	index    *keyindex.Index            // Sorted view of the keys in data
+	expiry   map[string]int64           // Expiry deadlines in Unix nanoseconds for keys with a TTL
+	versions map[string]uint64          // Persisted per-key versions, bumped on every write
+	floor    uint64                     // Highest version of a deleted key; new keys start above it
+	modRev   map[string]uint64          // Revision of the last change per key (including deletes)
+	clearRev uint64                     // Revision of the last Clear; applies to keys absent from modRev
+	indexes  map[string]*secondaryIndex // Secondary indexes by name (default keyspace only)
+}
+
+// newKeyspace builds a keyspace from persisted state (nil for an empty one).
+func newKeyspace(name string, st *storage.State) *keyspace {
+	ks := &keyspace{
+		name:     name,
+		data:     make(map[string][]byte),
+		expiry:   make(map[string]int64),
+		versions: make(map[string]uint64),
+		modRev:   make(map[string]uint64),
+		indexes:  make(map[string]*secondaryIndex),
+	}
+	if st != nil {
+		if st.Data != nil {
+			ks.data = st.Data
+		}
+		if st.Expiry != nil {
+			ks.expiry = st.Expiry
+		}
+		if st.Versions != nil {
+			ks.versions = st.Versions
+		}
+		ks.floor = st.Floor
+	}
+
+	keys := make([]string, 0, len(ks.data))
+	for k := range ks.data {
+		keys = append(keys, k)
+	}
+	ks.index = keyindex.New(keys...)
+	return ks
+}
+
+// state copies the keyspace into a storage.State for a snapshot write.
+func (ks *keyspace) state() *storage.State {
+	st := &storage.State{
+		Data:     make(map[string][]byte, len(ks.data)),
+		Expiry:   make(map[string]int64, len(ks.expiry)),
+		Versions: make(map[string]uint64, len(ks.versions)),
+		Floor:    ks.floor,
+	}
+	for k, v := range ks.data {
+		st.Data[k] = v
+	}
+	for k, v := range ks.expiry {
+		st.Expiry[k] = v
+	}
+	for k, v := range ks.versions {
+		st.Versions[k] = v
+	}
+	return st
+}
+
+// keyspaceLocked returns the keyspace for bucket ("" is the default one).
+// A missing bucket is created if create is set, otherwise nil is returned.
+// The caller must hold s.mu, for writing if create is set.
+func (s *Store) keyspaceLocked(bucket string, create bool) *keyspace {
+	if bucket == "" {
+		return s.root
+	}
+	ks, ok := s.buckets[bucket]
+	if !ok && create {
+		ks = newKeyspace(bucket, nil)
+		s.buckets[bucket] = ks
+	}
+	return ks
+}
+
+// Bucket is a handle to a named keyspace inside a store.
+//
+// Keys in different buckets never collide, and Clear on a bucket only
+// removes that bucket's keys. A bucket is created by its first write and
+// lives until it is dropped with Store.DropBucket. Handles are cheap and
+// safe for concurrent use.
+//
+//	users := store.Bucket("users")
+//	users.Set("42", user)
+//	users.Clear() // other buckets and the default keyspace are untouched
+//
+// TTLs, versions, and transactions apply to the default keyspace only.
+type Bucket struct {
+	store *Store
+	name  string
+}
+
+// Bucket returns a handle to the bucket called name.
+// The empty name refers to the store's default keyspace.
+func (s *Store) Bucket(name string) *Bucket {
+	return &Bucket{store: s, name: name}
+}
+
+// Buckets returns the names of all existing buckets in ascending order.
+func (s *Store) Buckets() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.buckets))
+	for name := range s.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DropBucket removes a bucket and all of its keys.
+// Returns ErrBucketNotFound if the bucket does not exist.
+func (s *Store) DropBucket(name string) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	if name == "" {
+		return fmt.Errorf("cannot drop the default keyspace")
+	}
+
+	s.persistMu.Lock()
+	s.mu.Lock()
+	ks, ok := s.buckets[name]
+	if !ok {
+		s.mu.Unlock()
+		s.persistMu.Unlock()
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, name)
+	}
+	ev := s.clearLocked(ks)
+	delete(s.buckets, name)
+	s.mu.Unlock()
+
+	return s.persistHeld(context.Background(), false, []storage.PersistRequest{{
+		Op:     storage.OpDropBucket,
+		Bucket: name,
+	}}, ev)
+}
+
+// Name returns the bucket's name.
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+// Set stores a value for the given key in the bucket.
+func (b *Bucket) Set(key string, value interface{}) error {
+	return b.store.set(context.Background(), b.name, key, value)
+}
+
+// Get retrieves a value for the given key from the bucket.
+// Returns ErrNotFound if the key does not exist.
+func (b *Bucket) Get(key string, value interface{}) error {
+	return b.store.get(b.name, key, value)
+}
+
+// SetBytes stores value under key in the bucket as opaque bytes,
+// bypassing the codec.
+func (b *Bucket) SetBytes(key string, value []byte) error {
+	return b.store.setBytes(context.Background(), b.name, key, append([]byte{}, value...))
+}
+
+// GetBytes returns a copy of the stored bytes for the given key in the bucket.
+// Returns ErrNotFound if the key does not exist.
+func (b *Bucket) GetBytes(key string) ([]byte, error) {
+	return b.store.getBytes(b.name, key)
+}
+
+// Delete removes a key from the bucket.
+func (b *Bucket) Delete(key string) error {
+	return b.store.delete(context.Background(), b.name, key)
+}
+
+// Has checks if a key exists in the bucket.
+func (b *Bucket) Has(key string) bool {
+	return b.store.has(b.name, key)
+}
+
+// Keys returns all keys in the bucket in ascending order.
+func (b *Bucket) Keys() []string {
+	return b.store.keys(b.name)
+}
+
+// Clear removes all keys from the bucket. The bucket itself remains.
+func (b *Bucket) Clear() error {
+	return b.store.clear(b.name)
+}
+
+// BatchSet sets multiple key-value pairs in the bucket atomically.
+func (b *Bucket) BatchSet(items map[string]interface{}) error {
+	return b.store.batchSet(context.Background(), b.name, items)
+}
+
+// BatchSetBytes stores multiple opaque byte values in the bucket atomically.
+func (b *Bucket) BatchSetBytes(items map[string][]byte) error {
+	return b.store.batchSetBytes(context.Background(), b.name, cloneItems(items))
+}
+
+// BatchGet retrieves multiple values from the bucket atomically.
+func (b *Bucket) BatchGet(keys []string) (map[string]interface{}, error) {
+	return b.store.batchGet(b.name, keys)
+}
+
+// BatchDelete deletes multiple keys from the bucket atomically.
+func (b *Bucket) BatchDelete(keys []string) error {
+	return b.store.batchDelete(b.name, keys)
+}
+
+// NewBatch creates a new batch whose operations apply to the bucket.
+func (b *Bucket) NewBatch() *Batch {
+	return &Batch{
+		store:      b.store,
+		bucket:     b.name,
+		operations: batch.New(),
+	}
+}
+
+// Scan returns an iterator over the bucket's keys starting with prefix.
+func (b *Bucket) Scan(prefix string) *Iterator {
+	return b.store.scan(b.name, prefix)
+}
+
+// Range returns an iterator over the bucket's keys in [start, end).
+// See Store.Range for the meaning of the arguments.
+func (b *Bucket) Range(start, end string, limit int, reverse bool) *Iterator {
+	return b.store.rangeKeys(b.name, start, end, limit, reverse)
+}
+
+// History returns the changes to key in the bucket recorded in the ledger.
+// See Store.History.
+func (b *Bucket) History(key string, opts HistoryOptions) ([]Change, error) {
+	return b.store.history(b.name, key, opts)
+}
+
+// Watch subscribes to changes of the bucket's keys starting with prefix.
+// Dropping the bucket is reported as an EventClear. See Store.Watch.
+func (b *Bucket) Watch(ctx context.Context, prefix string) <-chan Event {
+	return b.store.watch(ctx, b.name, prefix)
+}
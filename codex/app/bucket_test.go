@@ -0,0 +1,152 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuckets(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts Options
+	}{
+		{"snapshot mode", Options{}},
+		{"ledger mode", Options{LedgerMode: true}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storePath := filepath.Join(t.TempDir(), "test.db")
+
+			store, err := NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("NewWithOptions() failed: %v", err)
+			}
+
+			users := store.Bucket("users")
+			orders := store.Bucket("orders")
+
+			store.Set("id", "root")
+			users.Set("id", "alice")
+			orders.Set("id", "order-1")
+			users.BatchSet(map[string]interface{}{"a": 1, "b": 2})
+			orders.NewBatch().Set("x", 1).Set("y", 2).Delete("x").Execute()
+
+			var value string
+			if err := users.Get("id", &value); err != nil || value != "alice" {
+				t.Errorf("expected alice in users bucket, got %q (err=%v)", value, err)
+			}
+			if err := store.Get("id", &value); err != nil || value != "root" {
+				t.Errorf("expected root in default keyspace, got %q (err=%v)", value, err)
+			}
+			if keys := users.Keys(); !reflect.DeepEqual(keys, []string{"a", "b", "id"}) {
+				t.Errorf("unexpected users keys: %v", keys)
+			}
+			if keys := store.Keys(); !reflect.DeepEqual(keys, []string{"id"}) {
+				t.Errorf("bucket keys leaked into default keyspace: %v", keys)
+			}
+
+			// Clearing one bucket leaves the others alone
+			if err := orders.Clear(); err != nil {
+				t.Fatalf("Clear() failed: %v", err)
+			}
+			if len(orders.Keys()) != 0 || !users.Has("id") || !store.Has("id") {
+				t.Error("bucket Clear affected other keyspaces")
+			}
+			if err := store.Clear(); err != nil {
+				t.Fatalf("Clear() failed: %v", err)
+			}
+			if !users.Has("id") {
+				t.Error("store Clear wiped a bucket")
+			}
+			store.Close()
+
+			store, err = NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("reopen failed: %v", err)
+			}
+			defer store.Close()
+
+			if names := store.Buckets(); !reflect.DeepEqual(names, []string{"orders", "users"}) {
+				t.Errorf("expected cleared bucket to survive restart, got %v", names)
+			}
+			users = store.Bucket("users")
+			if keys := users.Keys(); !reflect.DeepEqual(keys, []string{"a", "b", "id"}) {
+				t.Errorf("unexpected users keys after restart: %v", keys)
+			}
+			if store.Has("id") {
+				t.Error("expected default keyspace to stay cleared")
+			}
+
+			if err := store.DropBucket("users"); err != nil {
+				t.Fatalf("DropBucket() failed: %v", err)
+			}
+			if err := store.DropBucket("users"); !errors.Is(err, ErrBucketNotFound) {
+				t.Errorf("expected ErrBucketNotFound, got %v", err)
+			}
+			if users.Has("id") {
+				t.Error("expected dropped bucket to be empty")
+			}
+			if names := store.Buckets(); !reflect.DeepEqual(names, []string{"orders"}) {
+				t.Errorf("unexpected buckets after drop: %v", names)
+			}
+		})
+	}
+}
+
+func TestBucketReadsDoNotCreate(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer store.Close()
+
+	missing := store.Bucket("missing")
+	var v string
+	if err := missing.Get("k", &v); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	missing.Delete("k")
+	missing.Scan("").Next()
+	if len(store.Buckets()) != 0 {
+		t.Errorf("reads created a bucket: %v", store.Buckets())
+	}
+}
+
+func TestBucketWatch(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	users := store.Bucket("users")
+	events := users.Watch(ctx, "")
+	root := store.Watch(ctx, "")
+
+	store.Set("k", 1)
+	users.Set("k", 2)
+	store.DropBucket("users")
+
+	ev := nextEvent(t, events)
+	if ev.Bucket != "users" || string(ev.NewValue) != "2" {
+		t.Errorf("unexpected bucket event: %+v", ev)
+	}
+	if ev := nextEvent(t, events); ev.Type != EventClear || ev.Bucket != "users" {
+		t.Errorf("expected clear event on drop, got %+v", ev)
+	}
+	if ev := nextEvent(t, root); ev.Bucket != "" || string(ev.NewValue) != "1" {
+		t.Errorf("unexpected default keyspace event: %+v", ev)
+	}
+	select {
+	case ev := <-root:
+		t.Errorf("default watcher saw bucket event: %+v", ev)
+	default:
+	}
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestBytes(t *testing.T) {
+	for _, opts := range []Options{{}, {LedgerMode: true}, {EncryptionKey: make([]byte, 32), Compression: SnappyCompression}} {
+		storePath := filepath.Join(t.TempDir(), "test.db")
+		store, err := NewWithOptions(storePath, opts)
+		if err != nil {
+			t.Fatalf("NewWithOptions() failed: %v", err)
+		}
+
+		image := bytes.Repeat([]byte{0x89, 'P', 'N', 'G', 0x00}, 1000)
+		if err := store.SetBytes("image", image); err != nil {
+			t.Fatalf("SetBytes() failed: %v", err)
+		}
+		image[0] = 0 // The store keeps its own copy
+
+		if err := store.BatchSetBytes(map[string][]byte{"a": {1}, "b": {}}); err != nil {
+			t.Fatalf("BatchSetBytes() failed: %v", err)
+		}
+		store.Set("json", map[string]int{"n": 1})
+		store.Bucket("files").SetBytes("f", []byte("contents"))
+		store.Close()
+
+		store, err = NewWithOptions(storePath, opts)
+		if err != nil {
+			t.Fatalf("reopen failed: %v", err)
+		}
+
+		got, err := store.GetBytes("image")
+		if err != nil || len(got) != len(image) || got[0] != 0x89 {
+			t.Errorf("unexpected image bytes (len %d, err=%v)", len(got), err)
+		}
+		got[1] = 0 // Callers get their own copy
+		if again, _ := store.GetBytes("image"); again[1] != 'P' {
+			t.Error("expected GetBytes to return a copy")
+		}
+		if got, err := store.GetBytes("b"); err != nil || len(got) != 0 {
+			t.Errorf("unexpected empty value %v (err=%v)", got, err)
+		}
+		if got, err := store.Bucket("files").GetBytes("f"); err != nil || string(got) != "contents" {
+			t.Errorf("unexpected bucket value %q (err=%v)", got, err)
+		}
+
+		// Codec values are unaffected, and their encoding is readable as bytes
+		var m map[string]int
+		if err := store.Get("json", &m); err != nil || m["n"] != 1 {
+			t.Errorf("unexpected JSON value %v (err=%v)", m, err)
+		}
+		if got, _ := store.GetBytes("json"); string(got) != `{"n":1}` {
+			t.Errorf("unexpected encoded JSON value %q", got)
+		}
+
+		if _, err := store.GetBytes("missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+		store.Close()
+	}
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/evertonmj/codex/codex/app/src/storage"
+)
+
+// In ledger mode, every entry records the hash of the entry before it, so
+// the ledger doubles as an audit trail: an entry cannot be changed,
+// removed, or reordered without breaking the chain. Options.MerkleInterval
+// also writes a Merkle root of the entries every so many entries.
+// Compactions rewrite the ledger and start a new chain.
+
+// ChainReport describes a verified hash chain: the number of entries and
+// Merkle roots, and the hash of the last entry (Head). Recording Head
+// elsewhere and comparing it later also detects entries removed from the
+// end, or a ledger rewritten from the first changed entry on.
+type ChainReport = storage.ChainReport
+
+// ChainError locates the first broken link of a ledger's hash chain: the
+// index and byte offset of the entry, and the sequence number of the last
+// operation before it.
+type ChainError = storage.ChainError
+
+// SignatureError locates a ledger entry that is unsigned, signed by an
+// untrusted key, or whose signature does not match it, when
+// Options.TrustedKeys is set.
+type SignatureError = storage.SignatureError
+
+// VerifyChain checks the hash chain of the ledger from its first entry to
+// its last. If a link is broken, it returns an error wrapping
+// ErrBrokenChain and a *ChainError, along with the report of the entries
+// before the break. With Options.TrustedKeys set, an entry not signed by a
+// trusted key returns an error wrapping ErrInvalidSignature and a
+// *SignatureError instead. A ledger with no chained entry returns
+// ErrUnchained with its report. It returns ErrNoHistory outside ledger
+// mode.
+func (s *Store) VerifyChain() (ChainReport, error) {
+	var report ChainReport
+	err := s.readHistory(func(h *storage.History) error {
+		var err error
+		report, err = h.VerifyChain()
+		return err
+	})
+	var broken *ChainError
+	if errors.As(err, &broken) {
+		return report, fmt.Errorf("%w: %w", ErrBrokenChain, broken)
+	}
+	var sigErr *SignatureError
+	if errors.As(err, &sigErr) {
+		return report, fmt.Errorf("%w: %w", ErrInvalidSignature, sigErr)
+	}
+	return report, err
+}
@@ -0,0 +1,157 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewWithOptions(path, Options{LedgerMode: true, MerkleInterval: 2})
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	store.Set("a", 1)
+	store.Set("b", 2)
+	store.Set("c", 3)
+	report, err := store.VerifyChain()
+	if err != nil {
+		t.Fatalf("VerifyChain() failed: %v", err)
+	}
+	if report.Entries != 4 || report.Roots != 1 || report.Head == nil {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	store.Close()
+
+	// Dropping the second entry still loads, but breaks the chain
+	data, _ := os.ReadFile(path)
+	header := 12 + int(binary.BigEndian.Uint32(data[8:])) // Magic, length, and JSON
+	first := header + 4 + int(binary.BigEndian.Uint32(data[header:]))
+	second := 4 + int(binary.BigEndian.Uint32(data[first:]))
+	os.WriteFile(path, append(data[:first:first], data[first+second:]...), 0600)
+
+	store, err = NewWithOptions(path, Options{LedgerMode: true, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	_, err = store.VerifyChain()
+	var broken *ChainError
+	if !errors.Is(err, ErrBrokenChain) || !errors.As(err, &broken) {
+		t.Fatalf("expected ErrBrokenChain, got %v", err)
+	}
+	if broken.Entry != 1 || broken.Offset != int64(first) || broken.Seq != 1 {
+		t.Errorf("unexpected broken link: %+v", broken)
+	}
+}
+
+func TestVerifyChainUnavailable(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+	if _, err := store.VerifyChain(); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("expected ErrNoHistory, got %v", err)
+	}
+}
+
+func TestSignedLedger(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	path := filepath.Join(t.TempDir(), "test.db")
+	opts := Options{LedgerMode: true, Signer: priv, TrustedKeys: []ed25519.PublicKey{pub}}
+
+	store, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	store.Set("a", 1)
+	store.BatchSet(map[string]interface{}{"b": 2, "c": 3})
+	store.Close()
+
+	// A reader that only trusts the writer's public key
+	store, err = NewWithOptions(path, Options{LedgerMode: true, ReadOnly: true, TrustedKeys: []ed25519.PublicKey{pub}})
+	if err != nil {
+		t.Fatalf("failed to open signed store: %v", err)
+	}
+	if len(store.Keys()) != 3 {
+		t.Errorf("expected 3 keys, got %v", store.Keys())
+	}
+	if _, err := store.VerifyChain(); err != nil {
+		t.Errorf("VerifyChain() failed: %v", err)
+	}
+	store.Close()
+
+	_, err = NewWithOptions(path, Options{LedgerMode: true, ReadOnly: true, TrustedKeys: []ed25519.PublicKey{otherPub}})
+	var sigErr *SignatureError
+	if !errors.Is(err, ErrInvalidSignature) || !errors.As(err, &sigErr) || !sigErr.Signer.Equal(pub) {
+		t.Errorf("expected ErrInvalidSignature naming the signer, got %v", err)
+	}
+
+	if _, err := NewWithOptions(filepath.Join(t.TempDir(), "snapshot.db"), Options{Signer: priv}); err == nil {
+		t.Error("expected signing to require ledger or hybrid mode")
+	}
+}
+
+func TestSignedLedgerVerifierCompaction(t *testing.T) {
+	for _, mode := range []Options{{LedgerMode: true}, {HybridMode: true}} {
+		t.Run(fmt.Sprintf("hybrid=%v", mode.HybridMode), func(t *testing.T) {
+			pub, priv, _ := ed25519.GenerateKey(nil)
+			path := filepath.Join(t.TempDir(), "test.db")
+
+			signer := mode
+			signer.Signer = priv
+			store, err := NewWithOptions(path, signer)
+			if err != nil {
+				t.Fatalf("failed to open store: %v", err)
+			}
+			store.Set("a", 1)
+			store.Set("a", 2)
+			store.Close()
+
+			// A verifier cannot sign the rewritten entries, so it must not rewrite them
+			verifier := mode
+			verifier.TrustedKeys = []ed25519.PublicKey{pub}
+			store, err = NewWithOptions(path, verifier)
+			if err != nil {
+				t.Fatalf("failed to open verifying store: %v", err)
+			}
+			if err := store.Compact(); !errors.Is(err, ErrSignerRequired) {
+				t.Errorf("expected ErrSignerRequired, got %v", err)
+			}
+			store.Close()
+
+			store, err = NewWithOptions(path, verifier)
+			if err != nil {
+				t.Fatalf("expected the ledger to stay verifiable, got %v", err)
+			}
+			var a int
+			if err := store.Get("a", &a); err != nil || a != 2 {
+				t.Errorf("expected a=2, got %d (err=%v)", a, err)
+			}
+			store.Close()
+
+			// With the Signer, the compacted ledger is re-signed and still trusted
+			signer.TrustedKeys = verifier.TrustedKeys
+			store, err = NewWithOptions(path, signer)
+			if err != nil {
+				t.Fatalf("failed to open store: %v", err)
+			}
+			if err := store.Compact(); err != nil {
+				t.Fatalf("Compact() failed: %v", err)
+			}
+			store.Close()
+			store, err = NewWithOptions(path, verifier)
+			if err != nil {
+				t.Fatalf("expected the compacted ledger to verify, got %v", err)
+			}
+			store.Close()
+		})
+	}
+}
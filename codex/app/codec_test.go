@@ -0,0 +1,135 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type codecProfile struct {
+	Name  string
+	Score int64
+	Tags  []string
+}
+
+func TestCodecs(t *testing.T) {
+	for _, c := range []Codec{JSONCodec, GobCodec, MessagePackCodec} {
+		for _, ledger := range []bool{false, true} {
+			name := c.ID() + "/snapshot"
+			if ledger {
+				name = c.ID() + "/ledger"
+			}
+			t.Run(name, func(t *testing.T) {
+				storePath := filepath.Join(t.TempDir(), "test.db")
+				opts := Options{Codec: c, LedgerMode: ledger}
+
+				store, err := NewWithOptions(storePath, opts)
+				if err != nil {
+					t.Fatalf("NewWithOptions() failed: %v", err)
+				}
+				alice := codecProfile{"alice", 1 << 40, []string{"admin"}}
+				store.Set("p:1", alice)
+				store.BatchSet(map[string]interface{}{"p:2": codecProfile{Name: "bob"}})
+				store.NewBatch().Set("p:3", codecProfile{Name: "carol"}).Delete("p:2").Execute()
+				store.Update(func(tx *Tx) error { return tx.Set("p:4", codecProfile{Name: "dan"}) })
+				store.Close()
+
+				store, err = NewWithOptions(storePath, opts)
+				if err != nil {
+					t.Fatalf("reopen failed: %v", err)
+				}
+				defer store.Close()
+
+				var got codecProfile
+				if err := store.Get("p:1", &got); err != nil || !reflect.DeepEqual(got, alice) {
+					t.Errorf("unexpected value %+v (err=%v)", got, err)
+				}
+				if keys := store.Keys(); !reflect.DeepEqual(keys, []string{"p:1", "p:3", "p:4"}) {
+					t.Errorf("unexpected keys after reopen: %v", keys)
+				}
+				it := store.Scan("p:3")
+				if !it.Next() || it.Value(&got) != nil || got.Name != "carol" {
+					t.Errorf("unexpected scanned value %+v", got)
+				}
+			})
+		}
+	}
+}
+
+func TestCodecMismatch(t *testing.T) {
+	for _, ledger := range []bool{false, true} {
+		storePath := filepath.Join(t.TempDir(), "test.db")
+
+		store, err := NewWithOptions(storePath, Options{Codec: GobCodec, LedgerMode: ledger})
+		if err != nil {
+			t.Fatalf("NewWithOptions() failed: %v", err)
+		}
+		store.Set("key", "value")
+		store.Close()
+
+		if _, err := NewWithOptions(storePath, Options{LedgerMode: ledger}); !errors.Is(err, ErrCodecMismatch) {
+			t.Errorf("ledger=%v: expected ErrCodecMismatch opening gob data as JSON, got %v", ledger, err)
+		}
+
+		// Data written before codecs existed is JSON
+		jsonPath := filepath.Join(t.TempDir(), "json.db")
+		store, _ = NewWithOptions(jsonPath, Options{LedgerMode: ledger})
+		store.Set("key", "value")
+		store.Close()
+		if _, err := NewWithOptions(jsonPath, Options{Codec: MessagePackCodec, LedgerMode: ledger}); !errors.Is(err, ErrCodecMismatch) {
+			t.Errorf("ledger=%v: expected ErrCodecMismatch opening JSON data as MessagePack, got %v", ledger, err)
+		}
+	}
+}
+
+func TestMessagePackQueries(t *testing.T) {
+	store, err := NewWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{Codec: MessagePackCodec})
+	if err != nil {
+		t.Fatalf("NewWithOptions() failed: %v", err)
+	}
+	defer store.Close()
+
+	store.Set("user:1", indexedUser{"alice@example.com", 30})
+	store.Set("user:2", indexedUser{"bob@example.com", 17})
+	store.CreateIndex("by_age", "user:", "$.age")
+
+	it, err := store.LookupRange("by_age", 18, nil, 0, false)
+	if keys := lookupKeys(t, it, err); !reflect.DeepEqual(keys, []string{"user:1"}) {
+		t.Errorf("unexpected range result: %v", keys)
+	}
+
+	results, err := store.Query("user:").Where("$.email", "=", "bob@example.com").All()
+	if err != nil || len(results) != 1 {
+		t.Fatalf("unexpected query results %v (err=%v)", results, err)
+	}
+	if string(results[0].Value) != `{"age":17,"email":"bob@example.com"}` {
+		t.Errorf("expected JSON result value, got %s", results[0].Value)
+	}
+
+	values, err := store.BatchGet([]string{"user:1"})
+	if err != nil || !reflect.DeepEqual(values["user:1"], map[string]interface{}{"email": "alice@example.com", "age": float64(30)}) {
+		t.Errorf("unexpected BatchGet result %v (err=%v)", values, err)
+	}
+}
+
+func TestRawCodec(t *testing.T) {
+	store, err := NewWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{Codec: RawCodec, LedgerMode: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions() failed: %v", err)
+	}
+	defer store.Close()
+
+	blob := []byte{0x00, 0xff, 0x10}
+	if err := store.Set("blob", blob); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := store.Set("struct", codecProfile{}); err == nil {
+		t.Error("expected raw codec to reject structs")
+	}
+	var got []byte
+	if err := store.Get("blob", &got); err != nil || !bytes.Equal(got, blob) {
+		t.Errorf("unexpected value %v (err=%v)", got, err)
+	}
+}
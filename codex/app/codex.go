@@ -1,5 +1,5 @@
 // Package codex provides a simple, fast, and persistent file-based key-value database
-// with support for encryption, compression, atomic operations, and two storage modes.
+// with support for encryption, compression, atomic operations, and three storage modes.
 //
 // CodexDB offers:
 //   - Simple API: Set, Get, Delete, Has, Keys, Clear
@@ -7,7 +7,7 @@
 //   - Compression algorithms: Gzip, Zstd, Snappy
 //   - Atomic file operations (crash-safe writes)
 //   - Batch operations for performance (10-50x faster)
-//   - Dual storage modes: Snapshot (fast) or Ledger (audit trail)
+//   - Storage modes: Snapshot (fast), Ledger (audit trail), or Hybrid (checkpoint + log)
 //   - Automatic rotating backups
 //   - Thread-safe concurrent access
 //   - Data integrity with SHA256 checksums
@@ -36,16 +36,21 @@
 package app
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/ed25519"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/evertonmj/codex/codex/app/src/backup"
 	"github.com/evertonmj/codex/codex/app/src/batch"
+	"github.com/evertonmj/codex/codex/app/src/codec"
 	"github.com/evertonmj/codex/codex/app/src/compression"
+	"github.com/evertonmj/codex/codex/app/src/encryption"
+	"github.com/evertonmj/codex/codex/app/src/keyindex"
 	"github.com/evertonmj/codex/codex/app/src/path"
 	"github.com/evertonmj/codex/codex/app/src/storage"
 )
@@ -57,6 +62,7 @@ var (
 	ErrNotFound = errors.New("key not found")
 
 	// ErrLocked is returned when the database file is locked by another process.
+	// When the holder is known, the error also wraps a *LockedError naming it.
 	ErrLocked = errors.New("database is locked by another process")
 
 	// ErrInvalidKey is returned when an encryption key has an invalid size.
@@ -64,6 +70,83 @@ var (
 
 	// ErrCorrupted is returned when data integrity verification fails.
 	ErrCorrupted = errors.New("data integrity check failed: database may be corrupted")
+
+	// ErrConflict is returned when a transaction read a key that another writer
+	// changed before the transaction could commit. The transaction can be retried.
+	ErrConflict = errors.New("transaction conflict: keys read were modified concurrently")
+
+	// ErrVersionMismatch is returned by conditional writes when the key's
+	// current version differs from the expected one.
+	ErrVersionMismatch = errors.New("version mismatch")
+
+	// ErrTxReadOnly is returned when writing inside a read-only transaction.
+	ErrTxReadOnly = errors.New("transaction is read-only")
+
+	// ErrTxClosed is returned when a transaction is used after it has finished.
+	ErrTxClosed = errors.New("transaction is closed")
+
+	// ErrBucketNotFound is returned when dropping a bucket that does not exist.
+	ErrBucketNotFound = errors.New("bucket not found")
+
+	// ErrIndexNotFound is returned when using a secondary index that does not exist.
+	ErrIndexNotFound = errors.New("index not found")
+
+	// ErrIndexExists is returned when creating a secondary index whose name is taken.
+	ErrIndexExists = errors.New("index already exists")
+
+	// ErrCodecMismatch is returned when opening a database with a different
+	// value codec than the one it was written with.
+	ErrCodecMismatch = errors.New("database was written with a different codec")
+
+	// ErrWriteAborted is returned by context-aware writes whose context ended
+	// before the write started. Nothing was changed in memory or on disk.
+	ErrWriteAborted = errors.New("write aborted: nothing was written")
+
+	// ErrWriteInFlight is returned by context-aware writes whose context ended
+	// while the write was being persisted. The change is already visible in
+	// memory and the disk write finishes in the background; it may still fail.
+	ErrWriteInFlight = errors.New("write not yet on disk: still in progress")
+
+	// ErrReadOnly is returned by every write to a store opened with
+	// Options.ReadOnly.
+	ErrReadOnly = errors.New("store is read-only")
+
+	// ErrNoHistory is returned by time-travel reads on a store that does not
+	// keep its history, that is, one not in ledger mode.
+	ErrNoHistory = errors.New("history is only kept in ledger mode")
+
+	// ErrHistoryCompacted is returned by time-travel reads of a point
+	// before the ledger's last compaction.
+	ErrHistoryCompacted = errors.New("history before this point was compacted")
+
+	// ErrBrokenChain is returned by VerifyChain when the ledger's entries
+	// no longer form an unbroken hash chain. The error also wraps a
+	// *ChainError locating the first broken link.
+	ErrBrokenChain = errors.New("ledger hash chain is broken: entries were changed, removed, or reordered")
+
+	// ErrInvalidSignature is returned when a ledger entry is not signed by
+	// one of Options.TrustedKeys. The error also wraps a *SignatureError
+	// locating the entry.
+	ErrInvalidSignature = errors.New("ledger entry is not signed by a trusted key")
+
+	// ErrUnchained is returned by VerifyChain, along with its report, for a
+	// ledger written before entries were chained: none of its entries is
+	// linked, so none was verified. Compact starts a new chain.
+	ErrUnchained = storage.ErrUnchained
+
+	// ErrSignerRequired is returned by Compact and RotateKey on a store
+	// with TrustedKeys but no Signer, which cannot sign the entries they
+	// rewrite. The ledger is left unchanged.
+	ErrSignerRequired = storage.ErrSignerRequired
+
+	// ErrWrongKey is returned when opening an encrypted database with a key
+	// other than the one it was written with.
+	ErrWrongKey = storage.ErrWrongKey
+
+	// ErrOptionsMismatch is returned when the database file was written with
+	// options that cannot read it, such as another storage mode, or
+	// encryption when no key was given. The error names the difference.
+	ErrOptionsMismatch = storage.ErrOptionsMismatch
 )
 
 // CompressionType defines the compression algorithm to use.
@@ -80,23 +163,106 @@ const (
 	SnappyCompression = compression.Snappy
 )
 
+// KDFParams tunes the derivation of the encryption key from
+// Options.Passphrase. Zero fields take the defaults: Argon2id with 3 passes
+// over 64 MiB in 4 threads, or scrypt with N=32768, r=8, p=1.
+type KDFParams = encryption.KDFParams
+
+const (
+	// KDFArgon2id derives keys with Argon2id (the default).
+	KDFArgon2id = encryption.Argon2id
+	// KDFScrypt derives keys with scrypt.
+	KDFScrypt = encryption.Scrypt
+)
+
+// LockedError describes the process holding a database's writer lock:
+// its PID, hostname, and when it took the lock. Holder.Stale reports a
+// holder on this host that is no longer running.
+type LockedError = storage.LockedError
+
+// Codec encodes and decodes stored values. Implementations must return a
+// stable ID, which is recorded in the database file.
+type Codec = codec.Codec
+
+var (
+	// JSONCodec encodes values with encoding/json (the default).
+	JSONCodec = codec.JSON
+	// GobCodec encodes values with encoding/gob. Its values cannot be decoded
+	// into interface{}, so BatchGet, indexes, and queries do not work with it.
+	GobCodec = codec.Gob
+	// MessagePackCodec encodes values as MessagePack, using the same field
+	// names and rules as encoding/json.
+	MessagePackCodec = codec.MessagePack
+	// RawCodec stores []byte and string values unchanged.
+	RawCodec = codec.Raw
+)
+
 // Options holds configuration for the store.
 type Options struct {
 	EncryptionKey    []byte
 	LedgerMode       bool
+	HybridMode       bool // Append to a write-ahead log and write periodic checkpoints
 	NumBackups       int
 	Compression      CompressionType // Compression algorithm (default: NoCompression)
 	CompressionLevel int             // Compression level (1-9 for Gzip/Zstd, ignored for Snappy)
+	ReaperInterval   time.Duration   // How often expired keys are reclaimed (default: 1s)
+	WatchBuffer      int             // Events buffered per watcher (default: 256)
+	WatchOverflow    OverflowPolicy  // What to do when a watcher's buffer is full
+	Codec            Codec           // Value encoding (default: JSONCodec); fixed once data is written
+	LockTimeout      time.Duration   // How long to wait for another writer to release the database (default: fail at once)
+	Sync             SyncPolicy      // When writes become durable (default: SyncAlways)
+	AutoCompact      AutoCompact     // When ledger mode compacts in the background (default: never)
+	CheckpointSize   int64           // Log bytes after which hybrid mode writes a checkpoint (default: 4 MiB)
+	MerkleInterval   int             // Ledger entries between Merkle roots of the hash chain (default: none)
+
+	// Signer signs every ledger entry written in ledger or hybrid mode.
+	// With TrustedKeys set, opening the store fails with ErrInvalidSignature
+	// unless every entry is signed by one of them or by Signer, and
+	// compacting or rotating the key requires a Signer.
+	Signer      ed25519.PrivateKey
+	TrustedKeys []ed25519.PublicKey
+
+	// Passphrase encrypts the database, instead of EncryptionKey, with a
+	// key derived from it by KDF (default: Argon2id). The salt and KDF
+	// parameters are stored in the file, so changing KDF only affects new
+	// databases.
+	Passphrase []byte
+	KDF        KDFParams
+
+	// ReadOnly opens an existing database without taking the writer lock,
+	// so it can be opened alongside one writer and any number of other
+	// readers. The store holds the data as of opening and every write
+	// returns ErrReadOnly.
+	ReadOnly bool
+}
+
+// appendOnly reports whether writes are appended to a log, in ledger or
+// hybrid mode, rather than rewriting a snapshot.
+func (o Options) appendOnly() bool {
+	return o.LedgerMode || o.HybridMode
 }
 
 // Store represents a key-value store.
 type Store struct {
-	path      string
-	data      map[string][]byte
-	mu        sync.RWMutex
-	persistMu sync.Mutex // Protects file persist operations to prevent concurrent writes
-	storer    storage.Storer
-	options   Options
+	path          string
+	root          *keyspace            // Default keyspace
+	buckets       map[string]*keyspace // Named buckets by name
+	rev           uint64               // In-memory revision, incremented on every key change
+	mu            sync.RWMutex
+	persistMu     persistLock   // Serializes writes; taken before changing memory, so the file sees them in the same order
+	dirty         bool          // Writes are not yet durable under a relaxed SyncPolicy; guarded by persistMu
+	compactMu     sync.Mutex    // Serializes compactions
+	compactCh     chan struct{} // Wakes the background compactor (nil without AutoCompact)
+	compactedSize int64         // Ledger size after the last compaction; guarded by persistMu
+	storer        storage.Storer
+	options       Options
+	codec         Codec      // Value encoding
+	watchMu       sync.Mutex // Protects watchers; held while publishing events
+	watchers      map[*watcher]struct{}
+	stop          chan struct{}  // Closed by Close to stop background goroutines
+	wg            sync.WaitGroup // Tracks background goroutines (reaper, watchers)
+	closeOnce     sync.Once
+	asOf          int64 // Clock of a historical view in Unix nanoseconds (0 = the current time)
 }
 
 // New creates a new key-value store at the specified path with default options.
@@ -131,9 +297,52 @@ func NewWithOptions(path string, opts Options) (*Store, error) {
 		}
 	}
 
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	if opts.Passphrase != nil {
+		if opts.EncryptionKey != nil {
+			return nil, fmt.Errorf("EncryptionKey and Passphrase cannot both be set")
+		}
+		if len(opts.Passphrase) == 0 {
+			return nil, fmt.Errorf("Passphrase must not be empty")
+		}
+	}
+
+	if opts.LedgerMode && opts.HybridMode {
+		return nil, fmt.Errorf("LedgerMode and HybridMode cannot both be set")
+	}
+
+	if opts.Signer != nil || opts.TrustedKeys != nil {
+		if !opts.appendOnly() {
+			return nil, fmt.Errorf("Signer and TrustedKeys require LedgerMode or HybridMode")
+		}
+		if opts.Signer != nil && len(opts.Signer) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("invalid Signer: must be a %d-byte Ed25519 private key", ed25519.PrivateKeySize)
+		}
+		for _, key := range opts.TrustedKeys {
+			if len(key) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("invalid trusted key: must be a %d-byte Ed25519 public key", ed25519.PublicKeySize)
+			}
+		}
+	}
+
+	if opts.Codec == nil {
+		opts.Codec = JSONCodec
+	}
+
+	if opts.ReadOnly {
+		// Read-only stores never create the database; in hybrid mode, the
+		// log exists before the first checkpoint does
+		existing := path
+		if opts.HybridMode {
+			existing = storage.WALPath(path)
+		}
+		if _, err := os.Stat(existing); err != nil {
+			return nil, fmt.Errorf("failed to open read-only store: %w", err)
+		}
+	} else {
+		dir := filepath.Dir(path)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create store directory: %w", err)
+		}
 	}
 
 	storageOpts := storage.Options{
@@ -141,131 +350,345 @@ func NewWithOptions(path string, opts Options) (*Store, error) {
 		EncryptionKey:    opts.EncryptionKey,
 		Compression:      opts.Compression,
 		CompressionLevel: opts.CompressionLevel,
+		Codec:            opts.Codec.ID(),
+		ReadOnly:         opts.ReadOnly,
+		LockTimeout:      opts.LockTimeout,
+		DeferSync:        opts.Sync.deferred(),
+		MerkleInterval:   opts.MerkleInterval,
+		Signer:           opts.Signer,
+		TrustedKeys:      opts.TrustedKeys,
+		Passphrase:       opts.Passphrase,
+		KDF:              opts.KDF,
+	}
+
+	// The index file is encrypted with the key derived from the passphrase
+	if opts.Passphrase != nil {
+		paths := []string{path}
+		if opts.HybridMode {
+			paths = append(paths, storage.WALPath(path))
+		}
+		var err error
+		if storageOpts, err = storage.DeriveKey(storageOpts, paths...); err != nil {
+			return nil, err
+		}
+		opts.EncryptionKey = storageOpts.EncryptionKey
 	}
 
 	var storer storage.Storer
 	var err error
-	if opts.LedgerMode {
+	switch {
+	case opts.LedgerMode:
 		storer, err = storage.NewLedger(storageOpts)
-	} else {
+	case opts.HybridMode:
+		storer, err = storage.NewHybrid(storageOpts)
+	default:
 		storer, err = storage.NewSnapshot(storageOpts)
 	}
 	if err != nil {
 		// Wrap filelock errors with our sentinel error
 		if errors.Is(err, storage.ErrLocked) {
+			var locked *LockedError
+			if errors.As(err, &locked) && locked.Holder != nil {
+				return nil, fmt.Errorf("%w: %w", ErrLocked, locked)
+			}
 			return nil, ErrLocked
 		}
 		return nil, err
 	}
 
 	store := &Store{
-		path:    path,
-		storer:  storer,
-		options: opts,
+		path:      path,
+		storer:    storer,
+		options:   opts,
+		codec:     opts.Codec,
+		persistMu: make(persistLock, 1),
+		buckets:   make(map[string]*keyspace),
+		watchers:  make(map[*watcher]struct{}),
+		stop:      make(chan struct{}),
 	}
 
-	data, err := store.storer.Load()
+	state, err := store.storer.LoadState()
 	if err != nil && !os.IsNotExist(err) {
+		storer.Close()
+		var sigErr *SignatureError
+		if errors.As(err, &sigErr) {
+			return nil, fmt.Errorf("failed to load data: %w: %w", ErrInvalidSignature, sigErr)
+		}
 		return nil, fmt.Errorf("failed to load data: %w", err)
 	}
+	if state != nil && state.Codec != "" && state.Codec != opts.Codec.ID() {
+		storer.Close()
+		return nil, fmt.Errorf("%w: file uses %q, store configured with %q", ErrCodecMismatch, state.Codec, opts.Codec.ID())
+	}
 
-	if data == nil {
-		store.data = make(map[string][]byte)
-	} else {
-		store.data = data
+	if err := store.loadState(state); err != nil {
+		storer.Close()
+		return nil, err
+	}
+
+	// Expired keys stay invisible to read-only stores; only writers reap them
+	if !opts.ReadOnly {
+		interval := opts.ReaperInterval
+		if interval <= 0 {
+			interval = time.Second
+		}
+		store.wg.Add(1)
+		go store.runReaper(interval)
+
+		if opts.Sync.interval > 0 {
+			store.wg.Add(1)
+			go store.runSyncer(opts.Sync.interval)
+		}
+
+		if (opts.LedgerMode && opts.AutoCompact.enabled()) || opts.HybridMode {
+			store.compactCh = make(chan struct{}, 1)
+			store.wg.Add(1)
+			go store.runCompactor()
+			// A ledger or log may already be due when opened
+			store.persistMu.Lock()
+			store.requestCompaction()
+			store.persistMu.Unlock()
+		}
 	}
 
 	return store, nil
 }
 
+// loadState fills a new store with state, which may be nil.
+func (s *Store) loadState(state *storage.State) error {
+	s.root = newKeyspace("", state)
+	if state != nil {
+		for name, b := range state.Buckets {
+			s.buckets[name] = newKeyspace(name, b)
+		}
+		if err := s.loadIndexes(state.Indexes); err != nil {
+			return fmt.Errorf("failed to load indexes: %w", err)
+		}
+	}
+	return nil
+}
+
 // Set stores a value for the given key.
 func (s *Store) Set(key string, value interface{}) error {
+	return s.set(context.Background(), "", key, value)
+}
+
+// SetContext is Set with a context bounding the wait for other writers and
+// for the disk write. If ctx ends first, the error wraps ctx.Err() and
+// either ErrWriteAborted (nothing was written) or ErrWriteInFlight (the
+// value is set in memory and its disk write is still in progress).
+func (s *Store) SetContext(ctx context.Context, key string, value interface{}) error {
+	return s.set(ctx, "", key, value)
+}
+
+// set stores a value for key in bucket.
+func (s *Store) set(ctx context.Context, bucket, key string, value interface{}) error {
 	// Marshal outside lock (fast operation)
-	data, err := json.Marshal(value)
+	data, err := s.codec.Marshal(value)
 	if err != nil {
 		return fmt.Errorf("failed to marshal value: %w", err)
 	}
+	return s.setBytes(ctx, bucket, key, data)
+}
+
+// SetBytes stores value under key as opaque bytes, bypassing the codec.
+// Read it back with GetBytes; Get would try to decode it with the codec.
+func (s *Store) SetBytes(key string, value []byte) error {
+	return s.setBytes(context.Background(), "", key, append([]byte{}, value...))
+}
+
+// setBytes stores already encoded data for key in bucket. The store takes
+// ownership of data.
+func (s *Store) setBytes(ctx context.Context, bucket, key string, data []byte) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	// Take the persist lock first, so an abandoned wait changes nothing
+	if err := s.persistMu.LockContext(ctx); err != nil {
+		return writeAborted(err)
+	}
 
 	// Update in-memory data while holding lock (fast in-memory operation)
 	s.mu.Lock()
-	s.data[key] = data
+	ev := s.putLocked(s.keyspaceLocked(bucket, true), key, data)
 	s.mu.Unlock()
 
 	// Persist without lock (slow I/O operation)
-	// The persist() method will read s.data with a read lock if needed
-	return s.persist(storage.PersistRequest{
-		Op:    storage.OpSet,
-		Key:   key,
-		Value: data,
-	})
+	return s.persistHeld(ctx, false, []storage.PersistRequest{{
+		Op:      storage.OpSet,
+		Bucket:  bucket,
+		Key:     key,
+		Value:   data,
+		Version: ev.Version,
+	}}, ev)
 }
 
 // Get retrieves a value for the given key.
 // Returns ErrNotFound if the key does not exist.
 func (s *Store) Get(key string, value interface{}) error {
+	return s.get("", key, value)
+}
+
+// GetContext is Get that first checks whether ctx has ended. Reads never
+// wait for disk I/O, so ctx is not consulted afterwards.
+func (s *Store) GetContext(ctx context.Context, key string, value interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.get("", key, value)
+}
+
+// get retrieves a value for key from bucket.
+func (s *Store) get(bucket, key string, value interface{}) error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	data, exists := s.data[key]
+	data, exists := s.keyspaceLocked(bucket, false).live(key, s.now())
 	if !exists {
 		return fmt.Errorf("%w: %s", ErrNotFound, key)
 	}
-	return json.Unmarshal(data, value)
+	return s.codec.Unmarshal(data, value)
+}
+
+// GetBytes returns a copy of the stored bytes for the given key, as written
+// by SetBytes (or the codec's encoding of a value written by Set).
+// Returns ErrNotFound if the key does not exist.
+func (s *Store) GetBytes(key string) ([]byte, error) {
+	return s.getBytes("", key)
+}
+
+// getBytes returns a copy of the stored bytes for key in bucket.
+func (s *Store) getBytes(bucket, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, exists := s.keyspaceLocked(bucket, false).live(key, s.now())
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return append([]byte{}, data...), nil
 }
 
 // Delete removes a key from the store.
 func (s *Store) Delete(key string) error {
+	return s.delete(context.Background(), "", key)
+}
+
+// DeleteContext is Delete with a context, reporting an ended context like
+// SetContext.
+func (s *Store) DeleteContext(ctx context.Context, key string) error {
+	return s.delete(ctx, "", key)
+}
+
+// delete removes key from bucket.
+func (s *Store) delete(ctx context.Context, bucket, key string) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	if err := s.persistMu.LockContext(ctx); err != nil {
+		return writeAborted(err)
+	}
+
 	// Delete from in-memory data while holding lock (fast in-memory operation)
 	s.mu.Lock()
-	delete(s.data, key)
+	ks := s.keyspaceLocked(bucket, false)
+	if ks == nil {
+		// Nothing to delete; don't create the bucket
+		s.mu.Unlock()
+		s.persistMu.Unlock()
+		return nil
+	}
+	events := s.deleteLocked(ks, key, EventDelete)
 	s.mu.Unlock()
 
 	// Persist without lock (slow I/O operation)
-	// The persist() method will read s.data with a read lock if needed
-	return s.persist(storage.PersistRequest{
-		Op:  storage.OpDelete,
-		Key: key,
-	})
+	return s.persistHeld(ctx, false, []storage.PersistRequest{{
+		Op:     storage.OpDelete,
+		Bucket: bucket,
+		Key:    key,
+	}}, events...)
 }
 
-// Clear removes all keys from the store.
+// Clear removes all keys from the store's default keyspace.
+// Buckets are not affected; use Bucket.Clear or DropBucket for those.
 func (s *Store) Clear() error {
+	return s.clear("")
+}
+
+// clear removes all keys from bucket.
+func (s *Store) clear(bucket string) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	s.persistMu.Lock()
+
 	// Clear in-memory data while holding lock (fast in-memory operation)
 	s.mu.Lock()
-	s.data = make(map[string][]byte)
+	ev := s.clearLocked(s.keyspaceLocked(bucket, true))
 	s.mu.Unlock()
 
 	// Persist without lock (slow I/O operation)
-	// The persist() method will read s.data with a read lock if needed
-	return s.persist(storage.PersistRequest{
-		Op: storage.OpClear,
-	})
+	return s.persistHeld(context.Background(), false, []storage.PersistRequest{{
+		Op:     storage.OpClear,
+		Bucket: bucket,
+	}}, ev)
 }
 
 // Has checks if a key exists in the store.
 func (s *Store) Has(key string) bool {
+	return s.has("", key)
+}
+
+// has checks if key exists in bucket.
+func (s *Store) has(bucket, key string) bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	_, exists := s.data[key]
+	_, exists := s.keyspaceLocked(bucket, false).live(key, s.now())
 	return exists
 }
 
-// Keys returns all keys in the store.
+// Keys returns all keys in the store in ascending order.
 func (s *Store) Keys() []string {
+	return s.keys("")
+}
+
+// keys returns all live keys of bucket in ascending order.
+func (s *Store) keys(bucket string) []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	keys := make([]string, 0, len(s.data))
-	for k := range s.data {
-		keys = append(keys, k)
+	ks := s.keyspaceLocked(bucket, false)
+	if ks == nil {
+		return []string{}
 	}
-	return keys
+	return ks.liveKeys(ks.index.Keys(), s.now())
 }
 
-// Close closes the store.
+// Close stops the background reaper, ends all watches, makes pending
+// writes durable, saves secondary indexes, and closes the store.
 func (s *Store) Close() error {
+	s.closeOnce.Do(func() { close(s.stop) })
+	s.wg.Wait()
+
+	// Wait for writes still in progress after their context ended
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+
+	flushErr := s.flushHeld()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.storer.Close()
+
+	var indexErr error
+	if !s.options.ReadOnly {
+		indexErr = s.saveIndexes()
+	}
+	if err := s.storer.Close(); err != nil {
+		return err
+	}
+	if flushErr != nil {
+		return flushErr
+	}
+	return indexErr
 }
 
 // Path returns the file path of the database.
@@ -275,38 +698,91 @@ func (s *Store) Path() string {
 
 // BatchSet sets multiple key-value pairs atomically
 func (s *Store) BatchSet(items map[string]interface{}) error {
-	// Prepare batch operations
-	b := batch.New()
-	for key, value := range items {
-		b.Set(key, value)
-	}
+	return s.batchSet(context.Background(), "", items)
+}
 
-	// Marshall all values and update in-memory data while holding lock
-	s.mu.Lock()
+// BatchSetContext is BatchSet with a context, reporting an ended context
+// like SetContext.
+func (s *Store) BatchSetContext(ctx context.Context, items map[string]interface{}) error {
+	return s.batchSet(ctx, "", items)
+}
+
+// batchSet sets multiple key-value pairs in bucket atomically.
+func (s *Store) batchSet(ctx context.Context, bucket string, items map[string]interface{}) error {
+	// Marshal all values before touching in-memory data, so a bad value
+	// leaves the store unchanged
+	encoded := make(map[string][]byte, len(items))
 	for key, value := range items {
-		data, err := json.Marshal(value)
+		data, err := s.codec.Marshal(value)
 		if err != nil {
-			s.mu.Unlock()
 			return fmt.Errorf("failed to marshal value for key %s: %w", key, err)
 		}
-		s.data[key] = data
+		encoded[key] = data
+	}
+	return s.batchSetBytes(ctx, bucket, encoded)
+}
+
+// BatchSetBytes stores multiple opaque byte values atomically, bypassing
+// the codec. Read them back with GetBytes.
+func (s *Store) BatchSetBytes(items map[string][]byte) error {
+	return s.batchSetBytes(context.Background(), "", cloneItems(items))
+}
+
+// cloneItems copies items and their values, so callers may reuse them.
+func cloneItems(items map[string][]byte) map[string][]byte {
+	cloned := make(map[string][]byte, len(items))
+	for key, value := range items {
+		cloned[key] = append([]byte{}, value...)
+	}
+	return cloned
+}
+
+// batchSetBytes stores already encoded values in bucket atomically. The
+// store takes ownership of the values.
+func (s *Store) batchSetBytes(ctx context.Context, bucket string, encoded map[string][]byte) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	b := batch.New()
+	for key, data := range encoded {
+		b.Set(key, data)
+	}
+
+	if err := s.persistMu.LockContext(ctx); err != nil {
+		return writeAborted(err)
+	}
+
+	// Update in-memory data while holding lock
+	events := make([]Event, 0, len(encoded))
+	s.mu.Lock()
+	ks := s.keyspaceLocked(bucket, true)
+	for key, data := range encoded {
+		events = append(events, s.putLocked(ks, key, data))
 	}
+	reqs := batchRequestsLocked(ks, b)
 	s.mu.Unlock()
 
 	// Persist batch WITHOUT holding the lock (slow I/O operation)
-	return s.persistBatch(b)
+	return s.persistHeld(ctx, true, reqs, events...)
 }
 
 // BatchGet retrieves multiple values atomically
 func (s *Store) BatchGet(keys []string) (map[string]interface{}, error) {
+	return s.batchGet("", keys)
+}
+
+// batchGet retrieves multiple values from bucket atomically.
+func (s *Store) batchGet(bucket string, keys []string) (map[string]interface{}, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	ks := s.keyspaceLocked(bucket, false)
+	now := s.now()
 	result := make(map[string]interface{})
 	for _, key := range keys {
-		if data, exists := s.data[key]; exists {
+		if data, exists := ks.live(key, now); exists {
 			var value interface{}
-			if err := json.Unmarshal(data, &value); err != nil {
+			if err := s.codec.Unmarshal(data, &value); err != nil {
 				return nil, fmt.Errorf("failed to unmarshal value for key %s: %w", key, err)
 			}
 			result[key] = value
@@ -318,21 +794,39 @@ func (s *Store) BatchGet(keys []string) (map[string]interface{}, error) {
 
 // BatchDelete deletes multiple keys atomically
 func (s *Store) BatchDelete(keys []string) error {
+	return s.batchDelete("", keys)
+}
+
+// batchDelete deletes multiple keys from bucket atomically.
+func (s *Store) batchDelete(bucket string, keys []string) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
 	// Prepare batch operations
 	b := batch.New()
 	for _, key := range keys {
 		b.Delete(key)
 	}
 
+	s.persistMu.Lock()
+
 	// Delete from in-memory data while holding lock
+	var events []Event
 	s.mu.Lock()
+	ks := s.keyspaceLocked(bucket, false)
+	if ks == nil {
+		s.mu.Unlock()
+		s.persistMu.Unlock()
+		return nil
+	}
 	for _, key := range keys {
-		delete(s.data, key)
+		events = append(events, s.deleteLocked(ks, key, EventDelete)...)
 	}
+	reqs := batchRequestsLocked(ks, b)
 	s.mu.Unlock()
 
 	// Persist batch WITHOUT holding the lock (slow I/O operation)
-	return s.persistBatch(b)
+	return s.persistHeld(context.Background(), true, reqs, events...)
 }
 
 // NewBatch creates a new batch for building operations
@@ -346,6 +840,7 @@ func (s *Store) NewBatch() *Batch {
 // Batch represents a batch of operations
 type Batch struct {
 	store      *Store
+	bucket     string // Bucket the operations apply to ("" = default keyspace)
 	operations *batch.Batch
 }
 
@@ -363,6 +858,16 @@ func (b *Batch) Delete(key string) *Batch {
 
 // Execute executes all operations in the batch atomically
 func (b *Batch) Execute() error {
+	return b.ExecuteContext(context.Background())
+}
+
+// ExecuteContext is Execute with a context, reporting an ended context
+// like Store.SetContext.
+func (b *Batch) ExecuteContext(ctx context.Context) error {
+	if err := b.store.checkWritable(); err != nil {
+		return err
+	}
+
 	// Validate batch (outside lock)
 	if err := b.operations.Validate(); err != nil {
 		return fmt.Errorf("invalid batch: %w", err)
@@ -371,28 +876,43 @@ func (b *Batch) Execute() error {
 	// Optimize operations (outside lock)
 	b.operations.OptimizeOperations()
 
-	// Apply all operations to in-memory data while holding lock (fast in-memory operation)
-	b.store.mu.Lock()
-
+	// Marshal all values first so a bad value leaves the store unchanged
+	encoded := batch.New()
 	for _, op := range b.operations.Operations() {
 		switch op.Type {
 		case batch.OpSet:
-			data, err := json.Marshal(op.Value)
+			data, err := b.store.codec.Marshal(op.Value)
 			if err != nil {
-				b.store.mu.Unlock()
 				return fmt.Errorf("failed to marshal value for key %s: %w", op.Key, err)
 			}
-			b.store.data[op.Key] = data
+			encoded.Set(op.Key, data)
 		case batch.OpDelete:
-			delete(b.store.data, op.Key)
+			encoded.Delete(op.Key)
 		}
 	}
 
+	if err := b.store.persistMu.LockContext(ctx); err != nil {
+		return writeAborted(err)
+	}
+
+	// Apply all operations to in-memory data while holding lock (fast in-memory operation)
+	var events []Event
+	b.store.mu.Lock()
+
+	ks := b.store.keyspaceLocked(b.bucket, true)
+	for _, op := range encoded.Operations() {
+		switch op.Type {
+		case batch.OpSet:
+			events = append(events, b.store.putLocked(ks, op.Key, op.Value.([]byte)))
+		case batch.OpDelete:
+			events = append(events, b.store.deleteLocked(ks, op.Key, EventDelete)...)
+		}
+	}
+	reqs := batchRequestsLocked(ks, encoded)
 	b.store.mu.Unlock()
 
 	// Persist batch without lock (slow I/O operation)
-	// The persistBatch() method will read s.data with a read lock if needed
-	return b.store.persistBatch(b.operations)
+	return b.store.persistHeld(ctx, true, reqs, events...)
 }
 
 // Size returns the number of operations in the batch
@@ -400,92 +920,225 @@ func (b *Batch) Size() int {
 	return b.operations.Size()
 }
 
-// persist handles the persistence logic.
-func (s *Store) persist(req storage.PersistRequest) error {
-	// For snapshot mode, handle backups first (before acquiring persistMu)
-	// Backups have their own synchronization and should not block other writers
-	if !s.options.LedgerMode {
-		// Data should already be in req.Data from caller
-		// If not provided (backward compat), copy current data while holding lock
-		if req.Data == nil {
-			s.mu.RLock()
-			// Copy data to ensure consistency (storage layer may access it slowly)
-			req.Data = make(map[string][]byte, len(s.data))
-			for k, v := range s.data {
-				req.Data[k] = v
-			}
-			s.mu.RUnlock()
-		}
+// putLocked stores data under key in ks, clearing any TTL, and keeps the
+// key index in sync. It returns the change event, which carries the key's
+// new version. The caller must hold s.mu for writing.
+func (s *Store) putLocked(ks *keyspace, key string, data []byte) Event {
+	old, exists := ks.data[key]
+	if !exists {
+		ks.index.Insert(key)
+	} else if _, live := ks.live(key, time.Now().UnixNano()); !live {
+		old = nil
+	}
+	ks.data[key] = data
+	delete(ks.expiry, key)
+	if version, ok := ks.versions[key]; ok {
+		ks.versions[key] = version + 1
+	} else {
+		ks.versions[key] = ks.floor + 1
+	}
+	ks.reindex(key, data, s.codec)
+	s.touchLocked(ks, key)
+	return Event{Type: EventSet, Bucket: ks.name, Key: key, OldValue: old, NewValue: data, Version: ks.versions[key]}
+}
 
-		// Create backups (has its own mutex, runs independently)
-		if s.options.NumBackups > 0 {
-			if err := backup.Create(s.path, s.options.NumBackups); err != nil {
-				return err
-			}
-		}
+// deleteLocked removes key from ks and keeps the key index in sync. The
+// key's version raises the keyspace's floor, so a re-created key starts
+// above it. It returns the change event of type typ, or nothing if the key
+// did not exist. The caller must hold s.mu for writing.
+func (s *Store) deleteLocked(ks *keyspace, key string, typ EventType) []Event {
+	old, exists := ks.data[key]
+	if !exists {
+		return nil
 	}
+	delete(ks.data, key)
+	delete(ks.expiry, key)
+	ks.retire(key)
+	ks.index.Remove(key)
+	ks.reindex(key, nil, s.codec)
+	s.touchLocked(ks, key)
+	return []Event{{Type: typ, Bucket: ks.name, Key: key, OldValue: old}}
+}
 
-	// Protect main file I/O operations with persistMu
-	// This allows multiple goroutines to update s.data concurrently,
-	// but serializes the main data file write since storage layer requires exclusive access
-	s.persistMu.Lock()
-	defer s.persistMu.Unlock()
+// clearLocked removes every key from ks, raising its floor like
+// deleteLocked. The caller must hold s.mu for writing.
+func (s *Store) clearLocked(ks *keyspace) Event {
+	for key := range ks.versions {
+		ks.retire(key)
+	}
+	ks.data = make(map[string][]byte)
+	ks.expiry = make(map[string]int64)
+	ks.index = keyindex.New()
+	for _, idx := range ks.indexes {
+		idx.reset()
+	}
+	s.rev++
+	ks.clearRev = s.rev
+	ks.modRev = make(map[string]uint64)
+	return Event{Type: EventClear, Bucket: ks.name}
+}
 
-	return s.storer.Persist(req)
+// retire drops the version of a deleted key, raising the floor to it.
+// The caller must hold s.mu for writing.
+func (ks *keyspace) retire(key string) {
+	ks.floor = max(ks.floor, ks.versions[key])
+	delete(ks.versions, key)
 }
 
-// persistBatch handles batch persistence logic
-func (s *Store) persistBatch(b *batch.Batch) error {
-	// Create storage requests (outside persistMu)
-	var reqs []storage.PersistRequest
+// touchLocked records a change to key in ks at a new revision.
+// The caller must hold s.mu for writing.
+func (s *Store) touchLocked(ks *keyspace, key string) {
+	s.rev++
+	ks.modRev[key] = s.rev
+}
 
-	for _, op := range b.Operations() {
-		var persistOp storage.PersistOp
-		if op.Type == batch.OpSet {
-			persistOp = storage.OpSet
-		} else {
-			persistOp = storage.OpDelete
-		}
+// modRevOf returns the revision at which key last changed.
+// The caller must hold s.mu.
+func (ks *keyspace) modRevOf(key string) uint64 {
+	if r, ok := ks.modRev[key]; ok {
+		return r
+	}
+	return ks.clearRev
+}
+
+// snapshotLocked copies the in-memory state into req for a snapshot write.
+// The caller must hold s.mu for reading.
+func (s *Store) snapshotLocked(req *storage.PersistRequest) {
+	root := s.root.state()
+	req.Data, req.Expiry, req.Versions, req.Floor = root.Data, root.Expiry, root.Versions, root.Floor
+	req.Buckets = make(map[string]*storage.State, len(s.buckets))
+	for name, ks := range s.buckets {
+		req.Buckets[name] = ks.state()
+	}
+	req.Indexes = make(map[string]storage.IndexDef, len(s.root.indexes))
+	for name, idx := range s.root.indexes {
+		req.Indexes[name] = idx.def
+	}
+}
 
+// checkWritable returns ErrReadOnly if the store was opened read-only.
+func (s *Store) checkWritable() error {
+	if s.options.ReadOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// persistLock serializes file writes. Unlike sync.Mutex, waiting for it
+// can be abandoned when a context ends.
+type persistLock chan struct{}
+
+// Lock acquires the lock.
+func (l persistLock) Lock() {
+	l <- struct{}{}
+}
+
+// LockContext acquires the lock, or returns ctx.Err() if ctx ends first.
+func (l persistLock) LockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Unlock releases the lock.
+func (l persistLock) Unlock() {
+	<-l
+}
+
+// writeAborted wraps the error of a context that ended before a write started.
+func writeAborted(err error) error {
+	return fmt.Errorf("%w: %w", ErrWriteAborted, err)
+}
+
+// batchRequestsLocked converts the operations of b, once applied to ks, to
+// storage requests. Set requests carry the key's new version, so each key
+// must appear once in b. Values of set operations must already be encoded
+// as []byte. The caller must hold s.mu.
+func batchRequestsLocked(ks *keyspace, b *batch.Batch) []storage.PersistRequest {
+	var reqs []storage.PersistRequest
+	for _, op := range b.Operations() {
 		req := storage.PersistRequest{
-			Op:  persistOp,
-			Key: op.Key,
+			Op:     storage.OpDelete,
+			Bucket: ks.name,
+			Key:    op.Key,
 		}
-
 		if op.Type == batch.OpSet {
-			data, err := json.Marshal(op.Value)
-			if err != nil {
-				return fmt.Errorf("failed to marshal batch value for key %s: %w", op.Key, err)
-			}
-			req.Value = data
+			req.Op = storage.OpSet
+			req.Value = op.Value.([]byte)
+			req.Version = ks.versions[op.Key]
 		}
-
 		reqs = append(reqs, req)
 	}
+	return reqs
+}
 
-	// For snapshot mode, handle data and backups before acquiring persistMu
-	if !s.options.LedgerMode {
-		if len(reqs) > 0 {
-			// Hold read lock and copy data to prevent race conditions
-			s.mu.RLock()
-			reqs[len(reqs)-1].Data = make(map[string][]byte, len(s.data))
-			for k, v := range s.data {
-				reqs[len(reqs)-1].Data[k] = v
-			}
-			s.mu.RUnlock()
+// persistHeld writes reqs, as one batch or as a single request, and
+// publishes the change events once they are on disk. The caller must hold
+// persistMu; persistHeld releases it when the write finishes. If ctx ends
+// first, it returns ErrWriteInFlight and the write completes in the background.
+func (s *Store) persistHeld(ctx context.Context, isBatch bool, reqs []storage.PersistRequest, events ...Event) error {
+	write := func() error {
+		defer s.persistMu.Unlock()
+
+		// Under a relaxed sync policy, the snapshot is rewritten on the next
+		// flush, and watchers hear of the write then
+		if s.options.Sync.deferred() && !s.options.appendOnly() {
+			s.dirty = true
+			s.hold(events)
+			return nil
 		}
 
-		// Create backup (has its own mutex, runs independently)
-		if s.options.NumBackups > 0 {
-			if err := backup.Create(s.path, s.options.NumBackups); err != nil {
-				return err
+		// For snapshot mode, copy the current data and create backups first
+		if !s.options.appendOnly() {
+			if len(reqs) > 0 && (isBatch || reqs[0].Data == nil) {
+				s.mu.RLock()
+				s.snapshotLocked(&reqs[len(reqs)-1])
+				s.mu.RUnlock()
+			}
+			if s.options.NumBackups > 0 {
+				if err := backup.Create(s.path, s.options.NumBackups); err != nil {
+					return err
+				}
 			}
 		}
+
+		var err error
+		if isBatch {
+			err = s.storer.PersistBatch(reqs)
+		} else {
+			err = s.storer.Persist(reqs[0])
+		}
+		if err != nil {
+			return err
+		}
+		s.dirty = s.options.Sync.deferred()
+		s.requestCompaction()
+		s.publish(events)
+		return nil
 	}
 
-	// Protect main file I/O operations with persistMu
-	s.persistMu.Lock()
-	defer s.persistMu.Unlock()
+	if ctx.Done() == nil {
+		return write()
+	}
 
-	return s.storer.PersistBatch(reqs)
+	done := make(chan error, 1)
+	go func() { done <- write() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// Prefer the result if the write finished at the same moment
+		select {
+		case err := <-done:
+			return err
+		default:
+			return fmt.Errorf("%w: %w", ErrWriteInFlight, ctx.Err())
+		}
+	}
 }
@@ -1,6 +1,8 @@
 package app
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
@@ -550,6 +552,61 @@ func TestWithEncryption(t *testing.T) {
 	}
 }
 
+func TestWithPassphrase(t *testing.T) {
+	kdf := KDFParams{Algorithm: KDFScrypt, N: 1024}
+	modes := map[string]Options{
+		"snapshot": {},
+		"ledger":   {LedgerMode: true},
+		"hybrid":   {HybridMode: true},
+	}
+	for name, opts := range modes {
+		t.Run(name, func(t *testing.T) {
+			storePath := filepath.Join(t.TempDir(), "test.db")
+			opts.Passphrase, opts.KDF = []byte("correct horse battery staple"), kdf
+
+			store, err := NewWithOptions(storePath, opts)
+			if err != nil {
+				t.Fatalf("NewWithOptions() failed: %v", err)
+			}
+			store.Set("secret", "sensitive_data")
+			store.Set("user", map[string]string{"name": "sensitive_name"})
+			store.CreateIndex("by_name", "user", "name")
+			store.Close()
+
+			raw, _ := os.ReadFile(storePath)
+			if bytes.Contains(raw, []byte("sensitive_data")) {
+				t.Error("expected the data to be encrypted")
+			}
+			raw, _ = os.ReadFile(storePath + ".idx")
+			if bytes.Contains(raw, []byte("sensitive_name")) {
+				t.Error("expected the index file to be encrypted")
+			}
+
+			store2, err := NewWithOptions(storePath, opts)
+			if err != nil {
+				t.Fatalf("NewWithOptions() failed to reopen: %v", err)
+			}
+			defer store2.Close()
+			var result string
+			if err := store2.Get("secret", &result); err != nil || result != "sensitive_data" {
+				t.Errorf("expected 'sensitive_data', got '%s' (err=%v)", result, err)
+			}
+			if store2.readIndexFile() == nil {
+				t.Error("expected the saved index entries to be read back")
+			}
+
+			opts.Passphrase, opts.ReadOnly = []byte("wrong"), true
+			if _, err := NewWithOptions(storePath, opts); !errors.Is(err, ErrWrongKey) {
+				t.Errorf("expected ErrWrongKey for a wrong passphrase, got %v", err)
+			}
+		})
+	}
+
+	if _, err := NewWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{Passphrase: []byte("p"), EncryptionKey: make([]byte, 32)}); err == nil {
+		t.Error("expected an error with both EncryptionKey and Passphrase")
+	}
+}
+
 func TestInvalidJSONHandling(t *testing.T) {
 	tmpDir := t.TempDir()
 	storePath := filepath.Join(tmpDir, "test.db")
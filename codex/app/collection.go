@@ -0,0 +1,236 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Collection is a typed view of the values stored under a key prefix.
+//
+// Every value is stored at prefix+id, where id is derived from the value by
+// a key function or by a struct field tagged `codex:"key"`:
+//
+//	type User struct {
+//	    ID    string `json:"id" codex:"key"`
+//	    Email string `json:"email"`
+//	}
+//
+//	users, err := codex.NewCollection[User](store, "user:")
+//	users.Put(User{ID: "42", Email: "a@example.com"}) // stored at "user:42"
+//	u, err := users.Get("42")
+//
+// Collections hold no state of their own; any number of them may share a
+// store, and they see writes made through the plain Store API.
+type Collection[T any] struct {
+	store  *Store
+	prefix string
+	keyFn  func(T) string
+}
+
+// NewCollection returns a collection whose ids are read from the struct
+// field of T tagged `codex:"key"`. The field must be a string or an integer.
+// T may be a struct or a pointer to a struct.
+func NewCollection[T any](store *Store, prefix string) (*Collection[T], error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	ptr := typ.Kind() == reflect.Pointer
+	if ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("collection type %s is not a struct; use NewCollectionFunc", typ)
+	}
+
+	field := -1
+	for i := 0; i < typ.NumField(); i++ {
+		if tag, ok := typ.Field(i).Tag.Lookup("codex"); ok && strings.Split(tag, ",")[0] == "key" {
+			field = i
+			break
+		}
+	}
+	if field < 0 {
+		return nil, fmt.Errorf("collection type %s has no field tagged `codex:\"key\"`", typ)
+	}
+
+	switch typ.Field(field).Type.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return nil, fmt.Errorf("key field %s.%s must be a string or an integer", typ, typ.Field(field).Name)
+	}
+
+	keyFn := func(v T) string {
+		rv := reflect.ValueOf(&v).Elem()
+		if ptr {
+			if rv.IsNil() {
+				return ""
+			}
+			rv = rv.Elem()
+		}
+		f := rv.Field(field)
+		switch f.Kind() {
+		case reflect.String:
+			return f.String()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return strconv.FormatInt(f.Int(), 10)
+		default:
+			return strconv.FormatUint(f.Uint(), 10)
+		}
+	}
+	return &Collection[T]{store: store, prefix: prefix, keyFn: keyFn}, nil
+}
+
+// NewCollectionFunc returns a collection whose ids are computed by keyFn.
+func NewCollectionFunc[T any](store *Store, prefix string, keyFn func(T) string) *Collection[T] {
+	return &Collection[T]{store: store, prefix: prefix, keyFn: keyFn}
+}
+
+// Prefix returns the key prefix of the collection.
+func (c *Collection[T]) Prefix() string {
+	return c.prefix
+}
+
+// Key returns the store key of the value with the given id.
+func (c *Collection[T]) Key(id string) string {
+	return c.prefix + id
+}
+
+// ID returns the id of v as computed by the collection's key function.
+func (c *Collection[T]) ID(v T) string {
+	return c.keyFn(v)
+}
+
+// Put stores v under its id.
+func (c *Collection[T]) Put(v T) error {
+	id := c.keyFn(v)
+	if id == "" {
+		return fmt.Errorf("cannot store value with an empty id")
+	}
+	return c.store.Set(c.prefix+id, v)
+}
+
+// PutWithTTL stores v under its id, expiring after ttl.
+func (c *Collection[T]) PutWithTTL(v T, ttl time.Duration) error {
+	id := c.keyFn(v)
+	if id == "" {
+		return fmt.Errorf("cannot store value with an empty id")
+	}
+	return c.store.SetWithTTL(c.prefix+id, v, ttl)
+}
+
+// PutMany stores all values atomically in a single batch.
+func (c *Collection[T]) PutMany(values []T) error {
+	items := make(map[string]interface{}, len(values))
+	for _, v := range values {
+		id := c.keyFn(v)
+		if id == "" {
+			return fmt.Errorf("cannot store value with an empty id")
+		}
+		items[c.prefix+id] = v
+	}
+	return c.store.BatchSet(items)
+}
+
+// Get returns the value with the given id.
+// Returns ErrNotFound if it does not exist.
+func (c *Collection[T]) Get(id string) (T, error) {
+	var v T
+	err := c.store.Get(c.prefix+id, &v)
+	return v, err
+}
+
+// Has reports whether a value with the given id exists.
+func (c *Collection[T]) Has(id string) bool {
+	return c.store.Has(c.prefix + id)
+}
+
+// Delete removes the value with the given id.
+func (c *Collection[T]) Delete(id string) error {
+	return c.store.Delete(c.prefix + id)
+}
+
+// GetMany returns the values with the given ids, read atomically and keyed
+// by id. Ids that do not exist are omitted.
+func (c *Collection[T]) GetMany(ids []string) (map[string]T, error) {
+	s := c.store
+	now := s.now()
+
+	raw := make(map[string][]byte, len(ids))
+	s.mu.RLock()
+	for _, id := range ids {
+		if data, exists := s.root.live(c.prefix+id, now); exists {
+			raw[id] = data
+		}
+	}
+	s.mu.RUnlock()
+
+	result := make(map[string]T, len(raw))
+	for id, data := range raw {
+		var v T
+		if err := s.codec.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal value for key %s: %w", c.prefix+id, err)
+		}
+		result[id] = v
+	}
+	return result, nil
+}
+
+// All returns every value in the collection, in id order.
+func (c *Collection[T]) All() ([]T, error) {
+	it := c.Scan("")
+	values := make([]T, 0, it.Len())
+	for it.Next() {
+		v, err := it.Value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// Count returns the number of values in the collection.
+func (c *Collection[T]) Count() int {
+	return c.store.Scan(c.prefix).Len()
+}
+
+// Scan returns an iterator over the values whose id starts with idPrefix,
+// in id order.
+func (c *Collection[T]) Scan(idPrefix string) *CollectionIterator[T] {
+	return &CollectionIterator[T]{it: c.store.Scan(c.prefix + idPrefix), prefix: c.prefix}
+}
+
+// CollectionIterator walks the values of a collection. Like Iterator, it
+// works on a point-in-time copy taken when it was created.
+type CollectionIterator[T any] struct {
+	it     *Iterator
+	prefix string
+}
+
+// Next advances the iterator and reports whether another value is available.
+func (ci *CollectionIterator[T]) Next() bool {
+	return ci.it.Next()
+}
+
+// ID returns the id of the value at the current position.
+func (ci *CollectionIterator[T]) ID() string {
+	return strings.TrimPrefix(ci.it.Key(), ci.prefix)
+}
+
+// Value decodes the value at the current position.
+func (ci *CollectionIterator[T]) Value() (T, error) {
+	var v T
+	err := ci.it.Value(&v)
+	if err != nil {
+		err = fmt.Errorf("failed to unmarshal value for key %s: %w", ci.it.Key(), err)
+	}
+	return v, err
+}
+
+// Len returns the total number of values the iterator yields.
+func (ci *CollectionIterator[T]) Len() int {
+	return ci.it.Len()
+}
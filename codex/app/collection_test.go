@@ -0,0 +1,132 @@
+package app
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type collectionUser struct {
+	ID    string `json:"id" codex:"key"`
+	Email string `json:"email"`
+}
+
+type collectionItem struct {
+	SKU   int `json:"sku" codex:"key"`
+	Stock int `json:"stock"`
+}
+
+func TestCollection(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer store.Close()
+
+	users, err := NewCollection[collectionUser](store, "user:")
+	if err != nil {
+		t.Fatalf("NewCollection() failed: %v", err)
+	}
+
+	if err := users.Put(collectionUser{ID: "1", Email: "alice@example.com"}); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := users.PutMany([]collectionUser{{"2", "bob@example.com"}, {"3", "carol@example.com"}}); err != nil {
+		t.Fatalf("PutMany() failed: %v", err)
+	}
+	if err := users.Put(collectionUser{Email: "nobody@example.com"}); err == nil {
+		t.Error("expected error for empty id")
+	}
+	store.Set("other:1", "not a user")
+
+	// Values are stored under prefix+id and visible to the plain API
+	var raw collectionUser
+	if err := store.Get("user:2", &raw); err != nil || raw.Email != "bob@example.com" {
+		t.Errorf("expected value at user:2, got %+v (err=%v)", raw, err)
+	}
+
+	u, err := users.Get("1")
+	if err != nil || u.Email != "alice@example.com" {
+		t.Errorf("unexpected Get() result: %+v (err=%v)", u, err)
+	}
+	if _, err := users.Get("9"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	many, err := users.GetMany([]string{"1", "3", "9"})
+	if err != nil {
+		t.Fatalf("GetMany() failed: %v", err)
+	}
+	expected := map[string]collectionUser{
+		"1": {"1", "alice@example.com"},
+		"3": {"3", "carol@example.com"},
+	}
+	if !reflect.DeepEqual(many, expected) {
+		t.Errorf("unexpected GetMany() result: %v", many)
+	}
+
+	if err := users.Delete("2"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	all, err := users.All()
+	if err != nil {
+		t.Fatalf("All() failed: %v", err)
+	}
+	if len(all) != 2 || all[0].ID != "1" || all[1].ID != "3" || users.Count() != 2 {
+		t.Errorf("unexpected All() result: %v", all)
+	}
+
+	it := users.Scan("3")
+	var ids []string
+	for it.Next() {
+		v, err := it.Value()
+		if err != nil || v.ID != it.ID() {
+			t.Errorf("unexpected Scan() value %+v for id %s (err=%v)", v, it.ID(), err)
+		}
+		ids = append(ids, it.ID())
+	}
+	if !reflect.DeepEqual(ids, []string{"3"}) {
+		t.Errorf("unexpected Scan() ids: %v", ids)
+	}
+
+	// A value of the wrong shape is reported with its key
+	store.Set("user:4", "not a user")
+	if _, err := users.All(); err == nil || !strings.Contains(err.Error(), "user:4") {
+		t.Errorf("expected decode error naming user:4, got %v", err)
+	}
+}
+
+func TestCollectionKeys(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer store.Close()
+
+	items, err := NewCollection[*collectionItem](store, "item:")
+	if err != nil {
+		t.Fatalf("NewCollection() failed: %v", err)
+	}
+	items.Put(&collectionItem{SKU: 42, Stock: 7})
+	if item, err := items.Get("42"); err != nil || item.Stock != 7 {
+		t.Errorf("unexpected Get() result: %+v (err=%v)", item, err)
+	}
+	if err := items.Put(nil); err == nil {
+		t.Error("expected error for nil value")
+	}
+
+	if _, err := NewCollection[string](store, "s:"); err == nil {
+		t.Error("expected error for non-struct type")
+	}
+	if _, err := NewCollection[indexedUser](store, "u:"); err == nil {
+		t.Error("expected error for struct without key tag")
+	}
+
+	names := NewCollectionFunc(store, "name:", func(s string) string { return strings.ToLower(s) })
+	names.Put("Alice")
+	if v, err := names.Get("alice"); err != nil || v != "Alice" {
+		t.Errorf("unexpected Get() result: %q (err=%v)", v, err)
+	}
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"github.com/evertonmj/codex/codex/app/src/storage"
+)
+
+const (
+	// defaultCompactMinEntries is the default AutoCompact.MinEntries.
+	defaultCompactMinEntries = 1000
+
+	// defaultCheckpointSize is the default Options.CheckpointSize.
+	defaultCheckpointSize = 4 << 20
+)
+
+// AutoCompact sets when a ledger store compacts itself in the background.
+// The zero value never compacts automatically.
+type AutoCompact struct {
+	// DeadRatio compacts once the ledger holds more than DeadRatio
+	// overwritten or deleted entries per live key. For example, 2 compacts
+	// once the ledger is three times as long as a compacted one.
+	DeadRatio float64
+
+	// MinEntries is the number of ledger entries below which DeadRatio is
+	// not checked (default: 1000).
+	MinEntries int64
+
+	// MaxSize compacts once the ledger file grows past MaxSize bytes. When
+	// the live data alone is close to MaxSize, the ledger is compacted
+	// again only once it doubles in size since the last compaction.
+	MaxSize int64
+}
+
+// enabled reports whether any threshold is set.
+func (a AutoCompact) enabled() bool {
+	return a.DeadRatio > 0 || a.MaxSize > 0
+}
+
+// Compact rewrites the ledger as one set entry per live key, dropping
+// overwritten, deleted, and expired values, and atomically replaces the
+// file. Each keyspace's version floor is recorded so versions keep
+// increasing across deletes. Writers are blocked only while the rewrite
+// starts and while the entries written during it are carried over.
+// In hybrid mode, Compact writes a checkpoint and starts a new log the same
+// way. In snapshot mode, the file is always compact and Compact does nothing.
+// Rewritten entries are signed with Options.Signer; with TrustedKeys set
+// and no Signer, Compact returns ErrSignerRequired.
+func (s *Store) Compact() error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	switch storer := s.storer.(type) {
+	case *storage.Ledger:
+		return s.compactLedger(storer)
+	case *storage.Hybrid:
+		return s.checkpoint(storer)
+	}
+	return nil
+}
+
+// persistedState returns a copy of the state as it is on disk. The caller
+// must hold persistMu.
+func (s *Store) persistedState() *storage.State {
+	var req storage.PersistRequest
+	s.mu.RLock()
+	s.snapshotLocked(&req)
+	s.mu.RUnlock()
+	return &storage.State{
+		Data:     req.Data,
+		Expiry:   req.Expiry,
+		Versions: req.Versions,
+		Floor:    req.Floor,
+		Buckets:  req.Buckets,
+		Indexes:  req.Indexes,
+	}
+}
+
+// compactLedger runs a compaction of ledger.
+func (s *Store) compactLedger(ledger *storage.Ledger) error {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	s.persistMu.Lock()
+	c, err := ledger.StartCompaction(s.persistedState())
+	s.persistMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// The bulk of the work runs while writers continue
+	if err := c.Write(); err != nil {
+		c.Abort()
+		return err
+	}
+
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+	if err := c.Finish(); err != nil {
+		return err
+	}
+	// The compacted file was synced in full
+	s.dirty = false
+	s.compactedSize = ledger.Stats().Size
+	return nil
+}
+
+// checkpoint writes a checkpoint of hybrid.
+func (s *Store) checkpoint(hybrid *storage.Hybrid) error {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	s.persistMu.Lock()
+	c, err := hybrid.StartCheckpoint(s.persistedState())
+	s.persistMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := c.Write(); err != nil {
+		c.Abort()
+		return err
+	}
+
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+	if err := c.Finish(); err != nil {
+		return err
+	}
+	// The checkpoint and the new log were synced in full
+	s.dirty = false
+	return nil
+}
+
+// requestCompaction wakes the background compactor if the ledger has
+// crossed an AutoCompact threshold, or the hybrid log has grown enough for
+// a checkpoint. The caller must hold persistMu.
+func (s *Store) requestCompaction() {
+	if s.compactCh == nil {
+		return
+	}
+
+	var due bool
+	switch storer := s.storer.(type) {
+	case *storage.Ledger:
+		due = s.compactionDue(storer.Stats())
+	case *storage.Hybrid:
+		// Checkpoints are written no faster than the log grows, so their
+		// cost stays proportional to the writes
+		stats := storer.Stats()
+		size := s.options.CheckpointSize
+		if size <= 0 {
+			size = defaultCheckpointSize
+		}
+		due = stats.LogSize > max(size, stats.CheckpointSize)
+	}
+
+	if due {
+		select {
+		case s.compactCh <- struct{}{}:
+		default: // A compaction is already pending
+		}
+	}
+}
+
+// compactionDue reports whether a ledger of the given size has crossed an
+// AutoCompact threshold.
+func (s *Store) compactionDue(stats storage.LedgerStats) bool {
+	auto := s.options.AutoCompact
+	if auto.MaxSize > 0 && stats.Size > max(auto.MaxSize, 2*s.compactedSize) {
+		return true
+	}
+	minEntries := auto.MinEntries
+	if minEntries <= 0 {
+		minEntries = defaultCompactMinEntries
+	}
+	if auto.DeadRatio <= 0 || stats.Entries < minEntries {
+		return false
+	}
+
+	// A compaction keeps an entry per live key
+	s.mu.RLock()
+	kept := int64(len(s.root.data))
+	for _, ks := range s.buckets {
+		kept += int64(len(ks.data))
+	}
+	s.mu.RUnlock()
+	return float64(stats.Entries-kept) > auto.DeadRatio*float64(kept)
+}
+
+// runCompactor compacts the ledger, or checkpoints the hybrid log, when
+// requested until the store is closed.
+func (s *Store) runCompactor() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.compactCh:
+			// Errors are retried on the next request; the ledger is unchanged meanwhile
+			_ = s.Compact()
+		}
+	}
+}
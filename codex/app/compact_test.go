@@ -0,0 +1,205 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evertonmj/codex/codex/app/src/storage"
+)
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat %s: %v", path, err)
+	}
+	return info.Size()
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	opts := Options{LedgerMode: true}
+	store, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	for i := 0; i < 200; i++ {
+		store.Set("counter", i)
+	}
+	store.Set("gone", 1)
+	store.Delete("gone")
+	store.SetWithTTL("session", "abc", time.Hour)
+	store.Bucket("users").Set("u1", map[string]string{"name": "alice"})
+	store.CreateIndex("by_name", "", "name")
+	version, _ := store.Version("counter")
+
+	before := fileSize(t, path)
+	if err := store.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if after := fileSize(t, path); after*10 > before {
+		t.Errorf("expected compaction to shrink the ledger from %d bytes, got %d", before, after)
+	}
+
+	// The store keeps working on the compacted ledger
+	store.Set("after", true)
+	store.Close()
+
+	store, err = NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	var n int
+	if err := store.Get("counter", &n); err != nil || n != 199 {
+		t.Errorf("expected counter 199, got %d (err=%v)", n, err)
+	}
+	if v, _ := store.Version("counter"); v != version {
+		t.Errorf("expected version %d to survive, got %d", version, v)
+	}
+	if ttl, _ := store.TTL("session"); ttl <= 0 {
+		t.Errorf("expected session to keep its TTL, got %v", ttl)
+	}
+	if store.Has("gone") || !store.Has("after") || !store.Bucket("users").Has("u1") {
+		t.Error("unexpected keys after reopening the compacted ledger")
+	}
+	if len(store.Indexes()) != 1 {
+		t.Errorf("expected the index to survive, got %v", store.Indexes())
+	}
+}
+
+func TestCompactModes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := New(path)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	store.Set("a", 1)
+	if err := store.Compact(); err != nil {
+		t.Errorf("expected Compact to do nothing in snapshot mode, got %v", err)
+	}
+	store.Close()
+
+	r, err := NewWithOptions(path, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open reader: %v", err)
+	}
+	defer r.Close()
+	if err := r.Compact(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+}
+
+func TestCompactConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	opts := Options{LedgerMode: true, Sync: SyncManual}
+	store, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 300; i++ {
+				store.Set(fmt.Sprintf("w%d", w), i)
+				if i%50 == 0 {
+					store.Delete(fmt.Sprintf("w%d", w))
+				}
+			}
+		}(w)
+	}
+	for i := 0; i < 5; i++ {
+		if err := store.Compact(); err != nil {
+			t.Errorf("Compact failed: %v", err)
+		}
+	}
+	wg.Wait()
+	store.Close()
+
+	store, err = NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	for w := 0; w < 4; w++ {
+		var n int
+		if err := store.Get(fmt.Sprintf("w%d", w), &n); err != nil || n != 299 {
+			t.Errorf("expected w%d to be 299, got %d (err=%v)", w, n, err)
+		}
+	}
+}
+
+func TestCompactionDueCountsLiveKeys(t *testing.T) {
+	store, err := NewWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{LedgerMode: true})
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+	store.options.AutoCompact = AutoCompact{DeadRatio: 2, MinEntries: 1}
+
+	store.Set("keep", 1)
+	for i := 0; i < 100; i++ {
+		store.Set(fmt.Sprintf("temp%d", i), i)
+		store.Delete(fmt.Sprintf("temp%d", i))
+	}
+
+	// 200 of the 201 entries are dead, whatever versions the deleted keys had
+	if !store.compactionDue(storage.LedgerStats{Entries: 201}) {
+		t.Error("expected deleted keys to count as dead")
+	}
+	if store.compactionDue(storage.LedgerStats{Entries: 3}) {
+		t.Error("expected no compaction below the ratio")
+	}
+}
+
+func TestAutoCompact(t *testing.T) {
+	thresholds := map[string]AutoCompact{
+		"ratio": {DeadRatio: 1, MinEntries: 50},
+		"size":  {MaxSize: 4096},
+	}
+	write := func(t *testing.T, path string, auto AutoCompact) *Store {
+		store, err := NewWithOptions(path, Options{LedgerMode: true, AutoCompact: auto})
+		if err != nil {
+			t.Fatalf("failed to open store: %v", err)
+		}
+		for i := 0; i < 300; i++ {
+			store.Set("key", i)
+		}
+		return store
+	}
+
+	// Compacted, the ledger holds at most 50 of the 300 entries
+	uncompacted := filepath.Join(t.TempDir(), "full.db")
+	write(t, uncompacted, AutoCompact{}).Close()
+	limit := fileSize(t, uncompacted) / 4
+
+	for name, auto := range thresholds {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			store := write(t, path, auto)
+			defer store.Close()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for fileSize(t, path) > limit {
+				if time.Now().After(deadline) {
+					t.Fatalf("ledger was not compacted, size %d", fileSize(t, path))
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			var n int
+			if err := store.Get("key", &n); err != nil || n != 299 {
+				t.Errorf("expected key 299, got %d (err=%v)", n, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evertonmj/codex/codex/app/src/storage"
+)
+
+// blockingStorer delays every persist until release is closed.
+type blockingStorer struct {
+	storage.Storer
+	release chan struct{}
+}
+
+func (b *blockingStorer) Persist(req storage.PersistRequest) error {
+	<-b.release
+	return b.Storer.Persist(req)
+}
+
+func (b *blockingStorer) PersistBatch(reqs []storage.PersistRequest) error {
+	<-b.release
+	return b.Storer.PersistBatch(reqs)
+}
+
+func TestContextWrites(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	if err := store.SetContext(ctx, "a", 1); err != nil {
+		t.Fatalf("SetContext() failed: %v", err)
+	}
+	if err := store.BatchSetContext(ctx, map[string]interface{}{"b": 2, "c": 3}); err != nil {
+		t.Fatalf("BatchSetContext() failed: %v", err)
+	}
+	if err := store.NewBatch().Set("d", 4).Delete("c").ExecuteContext(ctx); err != nil {
+		t.Fatalf("ExecuteContext() failed: %v", err)
+	}
+	if err := store.DeleteContext(ctx, "b"); err != nil {
+		t.Fatalf("DeleteContext() failed: %v", err)
+	}
+	var n int
+	if err := store.GetContext(ctx, "d", &n); err != nil || n != 4 {
+		t.Errorf("unexpected value %d (err=%v)", n, err)
+	}
+	if keys := store.Keys(); len(keys) != 2 {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := store.GetContext(canceled, "d", &n); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	writes := map[string]func() error{
+		"SetContext":      func() error { return store.SetContext(canceled, "x", 1) },
+		"DeleteContext":   func() error { return store.DeleteContext(canceled, "a") },
+		"BatchSetContext": func() error { return store.BatchSetContext(canceled, map[string]interface{}{"x": 1}) },
+		"ExecuteContext":  func() error { return store.NewBatch().Set("x", 1).ExecuteContext(canceled) },
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, ErrWriteAborted) || !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: expected ErrWriteAborted wrapping context.Canceled, got %v", name, err)
+		}
+	}
+	if store.Has("x") || !store.Has("a") {
+		t.Error("expected aborted writes to change nothing")
+	}
+}
+
+func TestContextWaitForWriter(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer store.Close()
+
+	// Another writer holds the persist lock
+	store.persistMu.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = store.SetContext(ctx, "key", "value")
+	store.persistMu.Unlock()
+
+	if !errors.Is(err, ErrWriteAborted) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected ErrWriteAborted wrapping DeadlineExceeded, got %v", err)
+	}
+	if store.Has("key") {
+		t.Error("expected aborted write to leave memory unchanged")
+	}
+}
+
+func TestWritesWaitForPersistLock(t *testing.T) {
+	testCases := []struct {
+		name    string
+		write   func(s *Store) error
+		changed func(s *Store) bool
+	}{
+		{"Clear", func(s *Store) error { return s.Clear() }, func(s *Store) bool { return !s.Has("a") }},
+		{"BatchDelete", func(s *Store) error { return s.BatchDelete([]string{"a"}) }, func(s *Store) bool { return !s.Has("a") }},
+		{"SetWithTTL", func(s *Store) error { return s.SetWithTTL("b", 1, time.Hour) }, func(s *Store) bool { return s.Has("b") }},
+		{"Expire", func(s *Store) error { return s.Expire("a", time.Hour) }, func(s *Store) bool {
+			ttl, _ := s.TTL("a")
+			return ttl != NoExpiry
+		}},
+		{"SetIfVersion", func(s *Store) error {
+			_, err := s.SetIfVersion("a", 2, 1)
+			return err
+		}, func(s *Store) bool {
+			v, _ := s.Version("a")
+			return v != 1
+		}},
+		{"DeleteIfVersion", func(s *Store) error { return s.DeleteIfVersion("a", 1) }, func(s *Store) bool { return !s.Has("a") }},
+		{"DropBucket", func(s *Store) error { return s.DropBucket("bucket") }, func(s *Store) bool { return len(s.Buckets()) == 0 }},
+		{"CreateIndex", func(s *Store) error { return s.CreateIndex("idx", "", "$.n") }, func(s *Store) bool { return len(s.Indexes()) != 0 }},
+		{"Update", func(s *Store) error {
+			return s.Update(func(tx *Tx) error { return tx.Set("b", 1) })
+		}, func(s *Store) bool { return s.Has("b") }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store, err := NewWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{LedgerMode: true})
+			if err != nil {
+				t.Fatalf("NewWithOptions() failed: %v", err)
+			}
+			defer store.Close()
+			store.Set("a", 1)
+			store.Bucket("bucket").Set("k", 1)
+
+			// Another writer holds the persist lock, so memory must not change
+			store.persistMu.Lock()
+			done := make(chan error, 1)
+			go func() { done <- tc.write(store) }()
+			time.Sleep(20 * time.Millisecond)
+			if tc.changed(store) {
+				t.Error("expected memory to be unchanged before the persist lock is taken")
+			}
+			store.persistMu.Unlock()
+
+			if err := <-done; err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+			if !tc.changed(store) {
+				t.Error("expected the write to be applied")
+			}
+		})
+	}
+}
+
+func TestContextSlowDisk(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+	store, err := New(storePath)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	slow := &blockingStorer{Storer: store.storer, release: make(chan struct{})}
+	store.storer = slow
+
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	events := store.Watch(watchCtx, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = store.SetContext(ctx, "key", "value")
+	if !errors.Is(err, ErrWriteInFlight) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected ErrWriteInFlight wrapping DeadlineExceeded, got %v", err)
+	}
+	if !store.Has("key") {
+		t.Error("expected in-flight write to be visible in memory")
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("expected no event before the write reaches disk, got %v", ev)
+	default:
+	}
+
+	// Close waits for the write to finish
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(slow.release)
+	}()
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	store, err = New(storePath)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer store.Close()
+	var v string
+	if err := store.Get("key", &v); err != nil || v != "value" {
+		t.Errorf("expected in-flight write to reach disk, got %q (err=%v)", v, err)
+	}
+}
@@ -0,0 +1,206 @@
+package app
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/evertonmj/codex/codex/app/src/storage"
+)
+
+// In ledger mode, every write is numbered and timestamped, so the store can
+// be read as it was at any earlier point. Sequence numbers start at 1 and
+// increase by one per key operation; the operations of a batch get
+// consecutive numbers and the same timestamp. A compaction discards the
+// history before it: reads of earlier points return ErrHistoryCompacted.
+
+// Seq returns the sequence number of the last write, or 0 if the store is
+// empty or not in ledger mode.
+func (s *Store) Seq() uint64 {
+	ledger, ok := s.storer.(*storage.Ledger)
+	if !ok {
+		return 0
+	}
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+	return ledger.Seq()
+}
+
+// AsOf returns a read-only view of the store right after write seq, or
+// after the whole batch if seq is part of one. The view supports every
+// read, with TTLs checked against the time of that write; writes return
+// ErrReadOnly. It returns ErrNoHistory outside ledger mode.
+func (s *Store) AsOf(seq uint64) (*Store, error) {
+	return s.view(func(r storage.Record) bool { return r.Seq <= seq }, time.Time{})
+}
+
+// GetAt retrieves the value key had at time t and unmarshals it into
+// value. It returns ErrNotFound if the key did not exist or had expired at
+// t, and ErrNoHistory outside ledger mode.
+func (s *Store) GetAt(key string, t time.Time, value interface{}) error {
+	view, err := s.view(func(r storage.Record) bool { return !r.Time.After(t) }, t)
+	if err != nil {
+		return err
+	}
+	defer view.Close()
+	return view.Get(key, value)
+}
+
+// HistoryOptions selects the changes returned by History.
+type HistoryOptions struct {
+	Since   time.Time // Only changes at or after Since (zero = from the start)
+	Until   time.Time // Only changes before Until (zero = up to now)
+	Limit   int       // At most Limit changes (0 = all)
+	Reverse bool      // Newest first, so Limit keeps the latest changes
+}
+
+// Change is a write recorded in the ledger that affected a key.
+type Change struct {
+	Seq     uint64
+	Time    time.Time // Zero for ledgers written before timestamps were recorded
+	Type    EventType // EventSet, EventDelete, or EventClear (the key's bucket was cleared or dropped)
+	Value   []byte    // Value after the change, encoded with the store's codec (nil unless EventSet)
+	Version uint64    // Version of the key after the change (0 if it no longer exists)
+
+	// Compacted is set for the value a compaction kept: the key as of Seq
+	// rather than the write that set it, which was discarded.
+	Compacted bool
+}
+
+// History returns the changes to key in the default keyspace recorded in
+// the ledger, oldest first unless opts.Reverse is set; use Bucket.History
+// for buckets. Deletes include the reaper's removal of expired keys. It
+// returns ErrNoHistory outside ledger mode.
+func (s *Store) History(key string, opts HistoryOptions) ([]Change, error) {
+	return s.history("", key, opts)
+}
+
+// history returns the changes to key in bucket.
+func (s *Store) history(bucket, key string, opts HistoryOptions) ([]Change, error) {
+	var changes []Change
+	var version uint64
+	exists := false
+	record := func(c Change) {
+		if !opts.Since.IsZero() && c.Time.Before(opts.Since) {
+			return
+		}
+		changes = append(changes, c)
+	}
+	err := s.readHistory(func(h *storage.History) error {
+		return h.Records(func(r storage.Record) bool {
+			if !opts.Until.IsZero() && !r.Time.Before(opts.Until) {
+				return false
+			}
+			if r.Bucket != bucket {
+				return true
+			}
+			switch {
+			case r.Op == storage.OpSet && r.Key == key:
+				// Writes of older ledgers have no explicit version and bump it by one
+				version++
+				if r.Version != 0 {
+					version = r.Version
+				}
+				exists = true
+				record(Change{Seq: r.Seq, Time: r.Time, Type: EventSet, Value: r.Value, Version: version, Compacted: r.Compacted})
+			case r.Op == storage.OpDelete && r.Key == key:
+				if exists {
+					exists = false
+					record(Change{Seq: r.Seq, Time: r.Time, Type: EventDelete})
+				}
+			case r.Op == storage.OpClear && exists:
+				exists = false
+				record(Change{Seq: r.Seq, Time: r.Time, Type: EventClear})
+			case r.Op == storage.OpDropBucket && exists:
+				version, exists = 0, false
+				record(Change{Seq: r.Seq, Time: r.Time, Type: EventClear})
+			}
+			return true
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Reverse {
+		slices.Reverse(changes)
+	}
+	if opts.Limit > 0 && len(changes) > opts.Limit {
+		changes = changes[:opts.Limit]
+	}
+	return changes, nil
+}
+
+// readHistory calls fn with the ledger's history, keeping compactions from
+// replacing the file meanwhile. It returns ErrNoHistory outside ledger mode.
+func (s *Store) readHistory(fn func(*storage.History) error) error {
+	ledger, ok := s.storer.(*storage.Ledger)
+	if !ok {
+		return ErrNoHistory
+	}
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+	s.persistMu.Lock()
+	history := ledger.History()
+	s.persistMu.Unlock()
+	return fn(history)
+}
+
+// view replays the ledger while include returns true and returns the
+// result as a read-only store. TTLs are checked against at, or the time of
+// the last write replayed if at is zero.
+func (s *Store) view(include func(storage.Record) bool, at time.Time) (*Store, error) {
+	var last time.Time
+	var state *storage.State
+	err := s.readHistory(func(h *storage.History) error {
+		var err error
+		state, err = h.State(func(r storage.Record) bool {
+			if !include(r) {
+				return false
+			}
+			last = r.Time
+			return true
+		})
+		return err
+	})
+	if errors.Is(err, storage.ErrCompacted) {
+		return nil, ErrHistoryCompacted
+	}
+	if err != nil {
+		return nil, err
+	}
+	if at.IsZero() {
+		at = last
+	}
+	var asOf int64 // Ledgers written before timestamps were recorded use the current time
+	if !at.IsZero() {
+		asOf = at.UnixNano()
+	}
+
+	opts := s.options
+	opts.ReadOnly = true
+	view := &Store{
+		path:      s.path,
+		storer:    viewStorer{},
+		options:   opts,
+		codec:     s.codec,
+		persistMu: make(persistLock, 1),
+		buckets:   make(map[string]*keyspace),
+		watchers:  make(map[*watcher]struct{}),
+		stop:      make(chan struct{}),
+		asOf:      asOf,
+	}
+	if err := view.loadState(state); err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+// viewStorer backs historical views, which are never written to.
+type viewStorer struct{}
+
+func (viewStorer) LoadState() (*storage.State, error)          { return nil, ErrReadOnly }
+func (viewStorer) Persist(storage.PersistRequest) error        { return ErrReadOnly }
+func (viewStorer) PersistBatch([]storage.PersistRequest) error { return ErrReadOnly }
+func (viewStorer) Sync() error                                 { return nil }
+func (viewStorer) Close() error                                { return nil }
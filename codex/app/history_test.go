@@ -0,0 +1,220 @@
+package app
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAsOf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewWithOptions(path, Options{LedgerMode: true})
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	store.Set("config", "v1")
+	store.BatchSet(map[string]interface{}{"config": "v2", "other": 1})
+	store.SetWithTTL("session", "abc", 50*time.Millisecond)
+	store.Delete("config")
+	if store.Seq() != 5 {
+		t.Fatalf("expected seq 5, got %d", store.Seq())
+	}
+
+	// A view within a batch includes all of it
+	expected := map[uint64]string{1: "v1", 2: "v2", 3: "v2", 4: "v2"}
+	for seq, want := range expected {
+		view, err := store.AsOf(seq)
+		if err != nil {
+			t.Fatalf("AsOf(%d) failed: %v", seq, err)
+		}
+		var got string
+		if err := view.Get("config", &got); err != nil || got != want {
+			t.Errorf("AsOf(%d): expected %q, got %q (err=%v)", seq, want, got, err)
+		}
+		view.Close()
+	}
+
+	view, err := store.AsOf(5)
+	if err != nil {
+		t.Fatalf("AsOf(5) failed: %v", err)
+	}
+	defer view.Close()
+	if view.Has("config") || len(view.Keys()) != 2 {
+		t.Errorf("unexpected keys at seq 5: %v", view.Keys())
+	}
+	if err := view.Set("config", "v3"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from a view, got %v", err)
+	}
+
+	// TTLs are checked as of the view, not now
+	time.Sleep(100 * time.Millisecond)
+	if store.Has("session") || !view.Has("session") {
+		t.Error("expected the session to be live in the view only")
+	}
+}
+
+func TestGetAt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewWithOptions(path, Options{LedgerMode: true})
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	before := time.Now()
+	store.Set("config", "v1")
+	time.Sleep(10 * time.Millisecond)
+	mid := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	store.Set("config", "v2")
+	store.Close()
+
+	// History survives reopening
+	store, err = NewWithOptions(path, Options{LedgerMode: true})
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	var got string
+	if err := store.GetAt("config", mid, &got); err != nil || got != "v1" {
+		t.Errorf("expected v1 at %v, got %q (err=%v)", mid, got, err)
+	}
+	if err := store.GetAt("config", time.Now(), &got); err != nil || got != "v2" {
+		t.Errorf("expected v2 now, got %q (err=%v)", got, err)
+	}
+	if err := store.GetAt("config", before.Add(-time.Second), &got); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound before the first write, got %v", err)
+	}
+}
+
+func TestHistoryUnavailable(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	store.Set("a", 1)
+	if _, err := store.AsOf(1); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("expected ErrNoHistory in snapshot mode, got %v", err)
+	}
+	store.Close()
+
+	store, err = NewWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{LedgerMode: true})
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+	store.Set("a", 1)
+	store.Set("a", 2)
+	if err := store.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	store.Set("a", 3)
+
+	if _, err := store.AsOf(1); !errors.Is(err, ErrHistoryCompacted) {
+		t.Errorf("expected ErrHistoryCompacted, got %v", err)
+	}
+	var n int
+	view, err := store.AsOf(2)
+	if err != nil {
+		t.Fatalf("AsOf at the compaction point failed: %v", err)
+	}
+	defer view.Close()
+	if err := view.Get("a", &n); err != nil || n != 2 {
+		t.Errorf("expected 2 at the compaction point, got %d (err=%v)", n, err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewWithOptions(path, Options{LedgerMode: true})
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	store.Set("config", "v1")
+	store.Set("other", 1)
+	store.BatchSet(map[string]interface{}{"config": "v2"})
+	mid := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	store.Delete("config")
+	store.Set("config", "v3")
+	store.Clear()
+	store.Bucket("b").Set("config", "in bucket")
+
+	changes, err := store.History("config", HistoryOptions{})
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	expected := []struct {
+		typ     EventType
+		value   string
+		version uint64
+	}{
+		{EventSet, `"v1"`, 1},
+		{EventSet, `"v2"`, 2},
+		{EventDelete, "", 0},
+		{EventSet, `"v3"`, 3},
+		{EventClear, "", 0},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %+v", len(expected), changes)
+	}
+	for i, want := range expected {
+		c := changes[i]
+		if c.Type != want.typ || string(c.Value) != want.value || c.Version != want.version || c.Time.IsZero() {
+			t.Errorf("change %d: expected %+v, got %+v", i, want, c)
+		}
+		if i > 0 && c.Seq <= changes[i-1].Seq {
+			t.Errorf("change %d is out of order", i)
+		}
+	}
+
+	// Time windows, limits, and order
+	changes, _ = store.History("config", HistoryOptions{Until: mid})
+	if len(changes) != 2 {
+		t.Errorf("expected 2 changes before %v, got %d", mid, len(changes))
+	}
+	changes, _ = store.History("config", HistoryOptions{Since: mid, Limit: 2})
+	if len(changes) != 2 || changes[0].Type != EventDelete {
+		t.Errorf("expected the delete and the next set, got %+v", changes)
+	}
+	changes, _ = store.History("config", HistoryOptions{Reverse: true, Limit: 1})
+	if len(changes) != 1 || changes[0].Type != EventClear {
+		t.Errorf("expected the latest change only, got %+v", changes)
+	}
+
+	changes, err = store.Bucket("b").History("config", HistoryOptions{})
+	if err != nil || len(changes) != 1 || string(changes[0].Value) != `"in bucket"` {
+		t.Errorf("unexpected bucket history: %+v (err=%v)", changes, err)
+	}
+}
+
+func TestHistoryCompacted(t *testing.T) {
+	store, err := NewWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{LedgerMode: true})
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+	store.Set("a", 1)
+	store.Set("a", 2)
+	store.Compact()
+	store.Set("a", 3)
+
+	changes, err := store.History("a", HistoryOptions{})
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(changes) != 2 || !changes[0].Compacted || changes[0].Version != 2 || changes[1].Compacted || changes[1].Version != 3 {
+		t.Errorf("unexpected history after compaction: %+v", changes)
+	}
+
+	snapshot, _ := New(filepath.Join(t.TempDir(), "test.db"))
+	defer snapshot.Close()
+	if _, err := snapshot.History("a", HistoryOptions{}); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("expected ErrNoHistory, got %v", err)
+	}
+}
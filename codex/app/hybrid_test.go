@@ -0,0 +1,101 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evertonmj/codex/codex/app/src/storage"
+)
+
+func TestHybridMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	opts := Options{HybridMode: true, CheckpointSize: 2048}
+	store, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	for i := 0; i < 300; i++ {
+		store.Set(fmt.Sprintf("key%d", i%10), i)
+	}
+	store.Bucket("users").Set("u1", "alice")
+	store.SetWithTTL("session", "abc", time.Hour)
+
+	// The background checkpoints keep the log short
+	deadline := time.Now().Add(5 * time.Second)
+	for fileSize(t, storage.WALPath(path)) > 4096 {
+		if time.Now().After(deadline) {
+			t.Fatalf("log was not checkpointed, size %d", fileSize(t, storage.WALPath(path)))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected a checkpoint file: %v", err)
+	}
+
+	r, err := NewWithOptions(path, Options{HybridMode: true, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open reader: %v", err)
+	}
+	if !r.Has("key9") || !r.Bucket("users").Has("u1") {
+		t.Error("expected the reader to see the checkpoint and log")
+	}
+	r.Close()
+	store.Close()
+
+	store, err = NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	var n int
+	if err := store.Get("key9", &n); err != nil || n != 299 {
+		t.Errorf("expected key9 to be 299, got %d (err=%v)", n, err)
+	}
+	if v, _ := store.Version("key9"); v != 30 {
+		t.Errorf("expected key9 at version 30, got %d", v)
+	}
+	if ttl, _ := store.TTL("session"); ttl <= 0 {
+		t.Errorf("expected session to keep its TTL, got %v", ttl)
+	}
+}
+
+func TestHybridCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	opts := Options{HybridMode: true, Sync: SyncManual}
+	store, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	store.BatchSet(map[string]interface{}{"a": 1, "b": 2})
+	store.Delete("a")
+	if err := store.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	store.Set("c", 3)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	store, err = NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if store.Has("a") || !store.Has("b") || !store.Has("c") {
+		t.Errorf("unexpected keys after reload: %v", store.Keys())
+	}
+}
+
+func TestHybridModeExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	if _, err := NewWithOptions(path, Options{LedgerMode: true, HybridMode: true}); err == nil {
+		t.Error("expected LedgerMode and HybridMode together to fail")
+	}
+	if _, err := NewWithOptions(path, Options{HybridMode: true, ReadOnly: true}); err == nil {
+		t.Error("expected a read-only store without a log to fail")
+	}
+}
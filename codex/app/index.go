@@ -0,0 +1,442 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/evertonmj/codex/codex/app/src/atomic"
+	"github.com/evertonmj/codex/codex/app/src/codec"
+	"github.com/evertonmj/codex/codex/app/src/encryption"
+	"github.com/evertonmj/codex/codex/app/src/integrity"
+	"github.com/evertonmj/codex/codex/app/src/jsonpath"
+	"github.com/evertonmj/codex/codex/app/src/keyindex"
+	"github.com/evertonmj/codex/codex/app/src/storage"
+)
+
+// Secondary indexes map the value of one JSON field to the keys whose values
+// contain it. Index definitions are persisted in the database file itself;
+// index entries are kept in memory, updated on every write, and saved to a
+// sidecar file (<path>.idx) on Close. The sidecar records the storer's
+// stamp, which identifies the data on disk without hashing it. On open, the
+// sidecar is used only if its stamp matches the data that was loaded;
+// otherwise the index is rebuilt from the values.
+//
+// Indexed values are ordered by type first (null, booleans, numbers,
+// strings) and then by value, so range lookups over numbers are numeric.
+// Objects and arrays are not indexed. Values are read through the store's
+// codec, so only codecs that decode into interface{} (JSON, MessagePack)
+// produce index entries.
+
+// indexSep separates the encoded value from the key in an index entry.
+// Encoded values never contain it, so an entry splits unambiguously.
+const indexSep = "\x00\x01"
+
+// indexFileFormat versions the sidecar layout and the value encoding.
+const indexFileFormat = 1
+
+// secondaryIndex maps an indexed JSON field value to the keys holding it.
+type secondaryIndex struct {
+	def     storage.IndexDef
+	path    jsonpath.Path
+	entries *keyindex.Index   // Sorted encodedValue+indexSep+key entries
+	byKey   map[string]string // Current entry of every indexed key
+}
+
+// newSecondaryIndex returns an empty index for def.
+func newSecondaryIndex(def storage.IndexDef) (*secondaryIndex, error) {
+	path, err := jsonpath.Parse(def.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &secondaryIndex{
+		def:     def,
+		path:    path,
+		entries: keyindex.New(),
+		byKey:   make(map[string]string),
+	}, nil
+}
+
+// add indexes key under the field value found in doc, if any.
+func (idx *secondaryIndex) add(key string, doc interface{}) {
+	v, ok := idx.path.Eval(doc)
+	if !ok {
+		return
+	}
+	enc, ok := encodeIndexValue(v)
+	if !ok {
+		return
+	}
+	entry := enc + indexSep + key
+	idx.entries.Insert(entry)
+	idx.byKey[key] = entry
+}
+
+// remove drops key from the index.
+func (idx *secondaryIndex) remove(key string) {
+	if entry, ok := idx.byKey[key]; ok {
+		idx.entries.Remove(entry)
+		delete(idx.byKey, key)
+	}
+}
+
+// reset drops every entry.
+func (idx *secondaryIndex) reset() {
+	idx.entries = keyindex.New()
+	idx.byKey = make(map[string]string)
+}
+
+// build indexes every matching key of ks from scratch, decoding values
+// with c.
+func (idx *secondaryIndex) build(ks *keyspace, c Codec) {
+	idx.reset()
+	for _, key := range ks.index.Prefix(idx.def.Prefix) {
+		if doc, err := codec.Generic(c, ks.data[key]); err == nil {
+			idx.add(key, doc)
+		}
+	}
+}
+
+// entryKeys returns the keys of index entries, preserving order.
+func entryKeys(entries []string) []string {
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e[strings.Index(e, indexSep)+len(indexSep):]
+	}
+	return keys
+}
+
+// reindex updates every index of ks for the new value of key (nil if the
+// key was deleted), decoding it with c. The caller must hold s.mu for writing.
+func (ks *keyspace) reindex(key string, data []byte, c Codec) {
+	var doc interface{}
+	decoded := false
+	for _, idx := range ks.indexes {
+		idx.remove(key)
+		if data == nil || !strings.HasPrefix(key, idx.def.Prefix) {
+			continue
+		}
+		if !decoded {
+			var err error
+			if doc, err = codec.Generic(c, data); err != nil {
+				data = nil // Not indexable; keep removing stale entries
+				continue
+			}
+			decoded = true
+		}
+		idx.add(key, doc)
+	}
+}
+
+// encodeIndexValue encodes a decoded JSON scalar so that byte-wise order of
+// encodings matches value order. It reports false for objects and arrays.
+func encodeIndexValue(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case nil:
+		return "\x01", true
+	case bool:
+		if x {
+			return "\x02t", true
+		}
+		return "\x02f", true
+	case float64:
+		if x == 0 {
+			x = 0 // Fold -0 into +0
+		}
+		bits := math.Float64bits(x)
+		if bits>>63 == 1 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		return fmt.Sprintf("\x03%016x", bits), true
+	case string:
+		// Escape NUL so the separator cannot appear inside a value; the
+		// escape sorts after the separator, keeping prefixes first
+		return "\x04" + strings.ReplaceAll(x, "\x00", "\x00\x02"), true
+	default:
+		return "", false
+	}
+}
+
+// normalizeIndexValue converts a Go value to the form values take once
+// decoded from JSON, and encodes it for an index lookup.
+func normalizeIndexValue(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal lookup value: %w", err)
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "", fmt.Errorf("failed to normalize lookup value: %w", err)
+	}
+	enc, ok := encodeIndexValue(v)
+	if !ok {
+		return "", fmt.Errorf("cannot look up %T: only scalar values are indexed", value)
+	}
+	return enc, nil
+}
+
+// CreateIndex defines a secondary index called name over the JSON field at
+// jsonPath (for example "$.email" or "address.city") of every value whose
+// key starts with keyPrefix. Existing values are indexed immediately, and
+// the index is kept up to date by every subsequent write.
+// Returns ErrIndexExists if an index with that name already exists.
+func (s *Store) CreateIndex(name, keyPrefix, jsonPath string) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	if name == "" {
+		return fmt.Errorf("index name must not be empty")
+	}
+	def := storage.IndexDef{Name: name, Prefix: keyPrefix, Path: jsonPath}
+	idx, err := newSecondaryIndex(def)
+	if err != nil {
+		return err
+	}
+
+	s.persistMu.Lock()
+	s.mu.Lock()
+	if _, exists := s.root.indexes[name]; exists {
+		s.mu.Unlock()
+		s.persistMu.Unlock()
+		return fmt.Errorf("%w: %s", ErrIndexExists, name)
+	}
+	idx.build(s.root, s.codec)
+	s.root.indexes[name] = idx
+	s.mu.Unlock()
+
+	return s.persistHeld(context.Background(), false, []storage.PersistRequest{{
+		Op:    storage.OpCreateIndex,
+		Index: &def,
+	}})
+}
+
+// DropIndex removes a secondary index.
+// Returns ErrIndexNotFound if the index does not exist.
+func (s *Store) DropIndex(name string) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	s.persistMu.Lock()
+	s.mu.Lock()
+	idx, exists := s.root.indexes[name]
+	if !exists {
+		s.mu.Unlock()
+		s.persistMu.Unlock()
+		return fmt.Errorf("%w: %s", ErrIndexNotFound, name)
+	}
+	delete(s.root.indexes, name)
+	s.mu.Unlock()
+
+	return s.persistHeld(context.Background(), false, []storage.PersistRequest{{
+		Op:    storage.OpDropIndex,
+		Index: &idx.def,
+	}})
+}
+
+// Indexes returns the names of all secondary indexes in ascending order.
+func (s *Store) Indexes() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.root.indexes))
+	for name := range s.root.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns an iterator over the keys whose indexed field equals value,
+// in key order.
+//
+//	store.CreateIndex("by_email", "user:", "$.email")
+//	it, err := store.Lookup("by_email", "alice@example.com")
+//	for it.Next() { ... }
+//
+// Returns ErrIndexNotFound if the index does not exist.
+func (s *Store) Lookup(index string, value interface{}) (*Iterator, error) {
+	enc, err := normalizeIndexValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	idx, exists := s.root.indexes[index]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrIndexNotFound, index)
+	}
+	return newIterator(s.root, entryKeys(idx.entries.Prefix(enc+indexSep)), s.codec, s.now()), nil
+}
+
+// LookupRange returns an iterator over the keys whose indexed field lies in
+// the half-open interval [lo, hi), ordered by field value and then by key.
+// A nil lo or hi leaves that side unbounded, and a limit <= 0 means no
+// limit. When reverse is true, keys are yielded from the highest value down.
+//
+//	it, err := store.LookupRange("by_age", 18, 65, 0, false)
+//
+// Returns ErrIndexNotFound if the index does not exist.
+func (s *Store) LookupRange(index string, lo, hi interface{}, limit int, reverse bool) (*Iterator, error) {
+	var start, end string
+	var err error
+	if lo != nil {
+		if start, err = normalizeIndexValue(lo); err != nil {
+			return nil, err
+		}
+	}
+	if hi != nil {
+		if end, err = normalizeIndexValue(hi); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	idx, exists := s.root.indexes[index]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrIndexNotFound, index)
+	}
+
+	// As with Range, the limit can only be pushed down when no key has a TTL
+	if len(s.root.expiry) == 0 {
+		return newIterator(s.root, entryKeys(idx.entries.Range(start, end, limit, reverse)), s.codec, s.now()), nil
+	}
+	it := newIterator(s.root, entryKeys(idx.entries.Range(start, end, 0, reverse)), s.codec, s.now())
+	if limit > 0 && limit < len(it.keys) {
+		it.keys, it.values = it.keys[:limit], it.values[:limit]
+	}
+	return it, nil
+}
+
+// indexFile is the sidecar file holding saved index entries.
+type indexFile struct {
+	Format  int                       `json:"format"`
+	Stamp   string                    `json:"stamp"` // Stamp of the data the entries were built from
+	Indexes map[string]indexFileEntry `json:"indexes"`
+}
+
+// indexFileEntry holds the saved entries of one index.
+type indexFileEntry struct {
+	Def     storage.IndexDef `json:"def"`
+	Entries [][]byte         `json:"entries"` // Raw entries; may not be valid UTF-8
+}
+
+// indexPath returns the path of the index sidecar file.
+func (s *Store) indexPath() string {
+	return s.path + ".idx"
+}
+
+// dataStamp returns the storer's stamp of the data on disk, or "" if it
+// has none.
+func (s *Store) dataStamp() string {
+	if stamper, ok := s.storer.(storage.Stamper); ok {
+		return stamper.Stamp()
+	}
+	return ""
+}
+
+// loadIndexes builds the indexes in defs, reusing saved entries from the
+// sidecar file when they match the loaded data and rebuilding otherwise.
+// The caller must hold s.mu for writing or own the store exclusively.
+func (s *Store) loadIndexes(defs map[string]storage.IndexDef) error {
+	if len(defs) == 0 {
+		return nil
+	}
+
+	saved := s.readIndexFile()
+	for name, def := range defs {
+		idx, err := newSecondaryIndex(def)
+		if err != nil {
+			return fmt.Errorf("invalid index %s: %w", name, err)
+		}
+		if entry, ok := saved[name]; ok && entry.Def == def {
+			for _, e := range entry.Entries {
+				sep := strings.Index(string(e), indexSep)
+				if sep < 0 {
+					continue
+				}
+				idx.entries.Insert(string(e))
+				idx.byKey[string(e[sep+len(indexSep):])] = string(e)
+			}
+		} else {
+			idx.build(s.root, s.codec)
+		}
+		s.root.indexes[name] = idx
+	}
+	return nil
+}
+
+// readIndexFile returns the saved index entries if the sidecar file exists,
+// is intact, and was written for the data currently loaded; otherwise nil.
+func (s *Store) readIndexFile() map[string]indexFileEntry {
+	raw, err := os.ReadFile(s.indexPath())
+	if err != nil {
+		return nil
+	}
+	if s.options.EncryptionKey != nil {
+		if raw, err = encryption.Decrypt(raw, s.options.EncryptionKey); err != nil {
+			return nil
+		}
+	}
+	if raw, err = integrity.Verify(raw); err != nil {
+		return nil
+	}
+
+	var f indexFile
+	if err := json.Unmarshal(raw, &f); err != nil || f.Format != indexFileFormat {
+		return nil
+	}
+	if f.Stamp == "" || f.Stamp != s.dataStamp() {
+		return nil // Stale: the data changed after the entries were saved
+	}
+	return f.Indexes
+}
+
+// saveIndexes writes the index entries to the sidecar file, or removes the
+// file when there are no indexes. The caller must hold s.mu and persistMu,
+// so the stamp matches the entries.
+func (s *Store) saveIndexes() error {
+	if len(s.root.indexes) == 0 {
+		if err := os.Remove(s.indexPath()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove index file: %w", err)
+		}
+		return nil
+	}
+
+	f := indexFile{
+		Format:  indexFileFormat,
+		Stamp:   s.dataStamp(),
+		Indexes: make(map[string]indexFileEntry, len(s.root.indexes)),
+	}
+	for name, idx := range s.root.indexes {
+		entries := idx.entries.Keys()
+		raw := make([][]byte, len(entries))
+		for i, e := range entries {
+			raw[i] = []byte(e)
+		}
+		f.Indexes[name] = indexFileEntry{Def: idx.def, Entries: raw}
+	}
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index file: %w", err)
+	}
+	if data, err = integrity.Sign(data); err != nil {
+		return fmt.Errorf("failed to sign index file: %w", err)
+	}
+	if s.options.EncryptionKey != nil {
+		if data, err = encryption.Encrypt(data, s.options.EncryptionKey); err != nil {
+			return fmt.Errorf("failed to encrypt index file: %w", err)
+		}
+	}
+	return atomic.WriteFile(s.indexPath(), data, 0600)
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type indexedUser struct {
+	Email string `json:"email"`
+	Age   int    `json:"age"`
+}
+
+func lookupKeys(t *testing.T, it *Iterator, err error) []string {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	return it.Keys()
+}
+
+func TestSecondaryIndex(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts Options
+	}{
+		{"snapshot mode", Options{}},
+		{"ledger mode", Options{LedgerMode: true}},
+		{"encrypted", Options{EncryptionKey: make([]byte, 32)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storePath := filepath.Join(t.TempDir(), "test.db")
+
+			store, err := NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("NewWithOptions() failed: %v", err)
+			}
+
+			store.Set("user:1", indexedUser{"alice@example.com", 30})
+			store.Set("user:2", indexedUser{"bob@example.com", 17})
+			store.Set("order:1", indexedUser{"alice@example.com", 99})
+
+			if err := store.CreateIndex("by_email", "user:", "$.email"); err != nil {
+				t.Fatalf("CreateIndex() failed: %v", err)
+			}
+			if err := store.CreateIndex("by_age", "user:", "age"); err != nil {
+				t.Fatalf("CreateIndex() failed: %v", err)
+			}
+			if err := store.CreateIndex("by_email", "", "$.email"); !errors.Is(err, ErrIndexExists) {
+				t.Errorf("expected ErrIndexExists, got %v", err)
+			}
+
+			// Existing values are indexed; keys outside the prefix are not
+			it, err := store.Lookup("by_email", "alice@example.com")
+			if keys := lookupKeys(t, it, err); !reflect.DeepEqual(keys, []string{"user:1"}) {
+				t.Errorf("unexpected lookup result: %v", keys)
+			}
+
+			// Writes keep the index up to date
+			store.Set("user:3", indexedUser{"alice@example.com", 45})
+			store.BatchSet(map[string]interface{}{"user:4": indexedUser{"dan@example.com", 8}})
+			store.Set("user:2", indexedUser{"bob@new.example.com", 17})
+			store.Update(func(tx *Tx) error { return tx.Delete("user:1") })
+
+			it, err = store.Lookup("by_email", "alice@example.com")
+			if keys := lookupKeys(t, it, err); !reflect.DeepEqual(keys, []string{"user:3"}) {
+				t.Errorf("unexpected lookup result after writes: %v", keys)
+			}
+			it, err = store.Lookup("by_email", "bob@example.com")
+			if keys := lookupKeys(t, it, err); len(keys) != 0 {
+				t.Errorf("expected stale value to be unindexed, got %v", keys)
+			}
+
+			// Numeric ranges are ordered by value, not by encoding
+			it, err = store.LookupRange("by_age", 10, 50, 0, false)
+			if keys := lookupKeys(t, it, err); !reflect.DeepEqual(keys, []string{"user:2", "user:3"}) {
+				t.Errorf("unexpected range result: %v", keys)
+			}
+			it, err = store.LookupRange("by_age", nil, nil, 1, true)
+			if keys := lookupKeys(t, it, err); !reflect.DeepEqual(keys, []string{"user:3"}) {
+				t.Errorf("unexpected reverse range result: %v", keys)
+			}
+			store.Close()
+
+			if _, err := os.Stat(storePath + ".idx"); err != nil {
+				t.Errorf("expected index file to be saved on close: %v", err)
+			}
+
+			store, err = NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("reopen failed: %v", err)
+			}
+			defer store.Close()
+
+			if names := store.Indexes(); !reflect.DeepEqual(names, []string{"by_age", "by_email"}) {
+				t.Errorf("unexpected indexes after restart: %v", names)
+			}
+			it, err = store.Lookup("by_email", "alice@example.com")
+			if keys := lookupKeys(t, it, err); !reflect.DeepEqual(keys, []string{"user:3"}) {
+				t.Errorf("unexpected lookup result after restart: %v", keys)
+			}
+
+			if err := store.DropIndex("by_age"); err != nil {
+				t.Fatalf("DropIndex() failed: %v", err)
+			}
+			if _, err := store.Lookup("by_age", 17); !errors.Is(err, ErrIndexNotFound) {
+				t.Errorf("expected ErrIndexNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSecondaryIndexRebuild(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts Options
+	}{
+		{"snapshot mode", Options{}},
+		{"ledger mode", Options{LedgerMode: true}},
+		{"hybrid mode", Options{HybridMode: true}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storePath := filepath.Join(t.TempDir(), "test.db")
+
+			store, err := NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("NewWithOptions() failed: %v", err)
+			}
+			store.Set("user:1", indexedUser{"alice@example.com", 30})
+			store.CreateIndex("by_email", "user:", "$.email")
+			store.Close()
+
+			stale, err := os.ReadFile(storePath + ".idx")
+			if err != nil {
+				t.Fatalf("failed to read index file: %v", err)
+			}
+
+			// The saved entries are used for unchanged data
+			store, err = NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("reopen failed: %v", err)
+			}
+			if store.readIndexFile() == nil {
+				t.Error("expected the index file to match the data")
+			}
+			store.Set("user:1", indexedUser{"carol@example.com", 30})
+			store.Close()
+
+			// Simulate a crash that left the old index file behind
+			if err := os.WriteFile(storePath+".idx", stale, 0600); err != nil {
+				t.Fatalf("failed to restore stale index file: %v", err)
+			}
+
+			store, err = NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("reopen failed: %v", err)
+			}
+			if store.readIndexFile() != nil {
+				t.Error("expected the old index file to be stale")
+			}
+			it, err := store.Lookup("by_email", "carol@example.com")
+			if keys := lookupKeys(t, it, err); !reflect.DeepEqual(keys, []string{"user:1"}) {
+				t.Errorf("expected stale index to be rebuilt, got %v", keys)
+			}
+			store.Close()
+
+			// A missing index file is rebuilt too
+			os.Remove(storePath + ".idx")
+			store, err = NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("reopen failed: %v", err)
+			}
+			defer store.Close()
+			it, err = store.Lookup("by_email", "carol@example.com")
+			if keys := lookupKeys(t, it, err); !reflect.DeepEqual(keys, []string{"user:1"}) {
+				t.Errorf("expected missing index to be rebuilt, got %v", keys)
+			}
+		})
+	}
+}
+
+func TestEncodeIndexValueOrder(t *testing.T) {
+	ordered := []interface{}{nil, false, true, -1e9, -2.5, float64(0), 0.5, float64(3), float64(1e12), "", "a", "a\x00", "ab", "b"}
+	for i := 1; i < len(ordered); i++ {
+		prev, _ := encodeIndexValue(ordered[i-1])
+		cur, _ := encodeIndexValue(ordered[i])
+		if prev+indexSep >= cur {
+			t.Errorf("expected %v to sort before %v", ordered[i-1], ordered[i])
+		}
+	}
+	if _, ok := encodeIndexValue(map[string]interface{}{}); ok {
+		t.Error("expected objects not to be indexable")
+	}
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"fmt"
+)
+
+// Iterator walks an ordered set of key-value pairs produced by Scan or Range.
+//
+// The iterator works on a point-in-time copy taken when it was created, so
+// writes made while iterating are not observed and no store lock is held.
+//
+//	it := store.Scan("user:")
+//	for it.Next() {
+//	    var u User
+//	    if err := it.Value(&u); err != nil {
+//	        return err
+//	    }
+//	    fmt.Println(it.Key(), u.Name)
+//	}
+type Iterator struct {
+	keys   []string
+	values [][]byte
+	codec  Codec
+	pos    int
+}
+
+// newIterator captures the values of keys in ks, skipping those expired at
+// now, to be decoded with c. The caller must hold s.mu.
+func newIterator(ks *keyspace, keys []string, c Codec, now int64) *Iterator {
+	keys = ks.liveKeys(keys, now)
+	values := make([][]byte, len(keys))
+	for i, k := range keys {
+		values[i] = ks.data[k]
+	}
+	return &Iterator{keys: keys, values: values, codec: c, pos: -1}
+}
+
+// Next advances the iterator and reports whether another pair is available.
+func (it *Iterator) Next() bool {
+	if it.pos+1 >= len(it.keys) {
+		it.pos = len(it.keys)
+		return false
+	}
+	it.pos++
+	return true
+}
+
+// Key returns the key at the current position.
+func (it *Iterator) Key() string {
+	if it.pos < 0 || it.pos >= len(it.keys) {
+		return ""
+	}
+	return it.keys[it.pos]
+}
+
+// Value unmarshals the value at the current position into v.
+func (it *Iterator) Value(v interface{}) error {
+	if it.pos < 0 || it.pos >= len(it.keys) {
+		return fmt.Errorf("iterator is not positioned on a key")
+	}
+	return it.codec.Unmarshal(it.values[it.pos], v)
+}
+
+// Len returns the total number of pairs the iterator yields.
+func (it *Iterator) Len() int {
+	return len(it.keys)
+}
+
+// Keys returns every key the iterator yields, in iteration order.
+func (it *Iterator) Keys() []string {
+	keys := make([]string, len(it.keys))
+	copy(keys, it.keys)
+	return keys
+}
+
+// Scan returns an iterator over all keys starting with prefix, in ascending order.
+// Hierarchical layouts such as "user:123:profile" can be listed cheaply with
+// Scan("user:123:").
+func (s *Store) Scan(prefix string) *Iterator {
+	return s.scan("", prefix)
+}
+
+// scan returns an iterator over the keys of bucket starting with prefix.
+func (s *Store) scan(bucket, prefix string) *Iterator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ks := s.keyspaceLocked(bucket, false)
+	if ks == nil {
+		return &Iterator{pos: -1}
+	}
+	return newIterator(ks, ks.index.Prefix(prefix), s.codec, s.now())
+}
+
+// Range returns an iterator over keys in the half-open interval [start, end).
+// An empty start or end leaves that side of the interval unbounded, and a
+// limit <= 0 means no limit. When reverse is true, keys are yielded in
+// descending order starting from the end of the interval.
+//
+// Paginate by passing the last key seen plus "\x00" as the next start:
+//
+//	it := store.Range(lastKey+"\x00", "", 50, false)
+func (s *Store) Range(start, end string, limit int, reverse bool) *Iterator {
+	return s.rangeKeys("", start, end, limit, reverse)
+}
+
+// rangeKeys returns an iterator over the keys of bucket in [start, end).
+func (s *Store) rangeKeys(bucket, start, end string, limit int, reverse bool) *Iterator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ks := s.keyspaceLocked(bucket, false)
+	if ks == nil {
+		return &Iterator{pos: -1}
+	}
+
+	// Expired keys are filtered after the range query, so the limit can only
+	// be pushed down to the index when no key carries a TTL
+	if len(ks.expiry) == 0 {
+		return newIterator(ks, ks.index.Range(start, end, limit, reverse), s.codec, s.now())
+	}
+	it := newIterator(ks, ks.index.Range(start, end, 0, reverse), s.codec, s.now())
+	if limit > 0 && limit < len(it.keys) {
+		it.keys, it.values = it.keys[:limit], it.values[:limit]
+	}
+	return it
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestScanAndRange(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts Options
+	}{
+		{"snapshot mode", Options{}},
+		{"ledger mode", Options{LedgerMode: true}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storePath := filepath.Join(t.TempDir(), "test.db")
+
+			store, err := NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("NewWithOptions() failed: %v", err)
+			}
+
+			for _, key := range []string{"user:2:profile", "user:1:profile", "user:1:settings", "order:1", "user:3:profile"} {
+				if err := store.Set(key, key+"-value"); err != nil {
+					t.Fatalf("Set() failed: %v", err)
+				}
+			}
+			if err := store.Delete("user:3:profile"); err != nil {
+				t.Fatalf("Delete() failed: %v", err)
+			}
+			store.Close()
+
+			// Reopen so the index is rebuilt from disk
+			store, err = NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("NewWithOptions() reopen failed: %v", err)
+			}
+			defer store.Close()
+
+			expectedKeys := []string{"order:1", "user:1:profile", "user:1:settings", "user:2:profile"}
+			if keys := store.Keys(); !reflect.DeepEqual(keys, expectedKeys) {
+				t.Errorf("expected sorted keys %v, got %v", expectedKeys, keys)
+			}
+
+			it := store.Scan("user:1:")
+			var got []string
+			for it.Next() {
+				var value string
+				if err := it.Value(&value); err != nil {
+					t.Fatalf("Value() failed: %v", err)
+				}
+				if value != it.Key()+"-value" {
+					t.Errorf("unexpected value %q for key %q", value, it.Key())
+				}
+				got = append(got, it.Key())
+			}
+			if !reflect.DeepEqual(got, []string{"user:1:profile", "user:1:settings"}) {
+				t.Errorf("unexpected scan result: %v", got)
+			}
+
+			page := store.Range("user:", "", 2, false).Keys()
+			if !reflect.DeepEqual(page, []string{"user:1:profile", "user:1:settings"}) {
+				t.Errorf("unexpected first page: %v", page)
+			}
+			next := store.Range(page[len(page)-1]+"\x00", "", 2, false).Keys()
+			if !reflect.DeepEqual(next, []string{"user:2:profile"}) {
+				t.Errorf("unexpected second page: %v", next)
+			}
+
+			reversed := store.Range("", "user:", 0, true).Keys()
+			if !reflect.DeepEqual(reversed, []string{"order:1"}) {
+				t.Errorf("unexpected reverse range: %v", reversed)
+			}
+		})
+	}
+}
+
+func TestIteratorIsolation(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer store.Close()
+
+	store.BatchSet(map[string]interface{}{"a": 1, "b": 2})
+
+	it := store.Scan("")
+	store.Set("c", 3)
+	store.Delete("a")
+
+	if it.Len() != 2 {
+		t.Fatalf("expected iterator to keep 2 pairs, got %d", it.Len())
+	}
+	it.Next()
+	var v int
+	if err := it.Value(&v); err != nil || it.Key() != "a" || v != 1 {
+		t.Errorf("expected a=1 from snapshot, got %s=%d (err=%v)", it.Key(), v, err)
+	}
+}
@@ -0,0 +1,482 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/evertonmj/codex/codex/app/src/codec"
+	"github.com/evertonmj/codex/codex/app/src/jsonpath"
+)
+
+// Query is an ad-hoc query over the decoded values of keys sharing a
+// prefix, built with Store.Query. Values must use a codec that decodes into
+// interface{} (JSON or MessagePack). Builder methods record errors, which are
+// returned by the method that runs the query.
+//
+//	results, err := store.Query("order:").
+//	    Where("$.status", "=", "pending").
+//	    Where("$.total", ">", 100).
+//	    OrderBy("$.created_at", false).
+//	    Limit(50).
+//	    All()
+//
+// When an equality or range predicate is on the path of a secondary index
+// covering the prefix, candidate keys come from the index instead of a scan.
+type Query struct {
+	store  *Store
+	prefix string
+	preds  []predicate
+	fields []jsonpath.Path
+	order  []sortKey
+	limit  int
+	offset int
+	err    error
+	plan   string // Index used by the last run ("" for a scan)
+}
+
+// QueryResult is one value matched by a query.
+type QueryResult struct {
+	Key   string
+	Value json.RawMessage // The value as JSON, or the projected fields when Select was used
+}
+
+// Decode unmarshals the result's value into v.
+func (r QueryResult) Decode(v interface{}) error {
+	return json.Unmarshal(r.Value, v)
+}
+
+// predicate is one Where condition.
+type predicate struct {
+	path  jsonpath.Path
+	op    string
+	value interface{} // Normalized to its decoded JSON form
+}
+
+// sortKey is one OrderBy clause.
+type sortKey struct {
+	path jsonpath.Path
+	desc bool
+}
+
+// queryRow is a candidate value during query execution.
+type queryRow struct {
+	key string
+	raw []byte
+	doc interface{}
+}
+
+// Query starts a query over the values of keys starting with prefix.
+func (s *Store) Query(prefix string) *Query {
+	return &Query{store: s, prefix: prefix}
+}
+
+// Where keeps only values whose field at path compares to value with op:
+// "=", "!=", "<", "<=", ">", ">=", or "exists" (value is ignored).
+// Ordered comparisons only match values of the same JSON type, and a
+// missing field never matches anything but "exists".
+func (q *Query) Where(path, op string, value interface{}) *Query {
+	p, err := jsonpath.Parse(path)
+	if err != nil {
+		q.setErr(err)
+		return q
+	}
+	switch op {
+	case "=", "!=", "<", "<=", ">", ">=", "exists":
+	default:
+		q.setErr(fmt.Errorf("unsupported query operator %q", op))
+		return q
+	}
+
+	var v interface{}
+	if op != "exists" {
+		data, err := json.Marshal(value)
+		if err != nil {
+			q.setErr(fmt.Errorf("failed to marshal query value: %w", err))
+			return q
+		}
+		if err := json.Unmarshal(data, &v); err != nil {
+			q.setErr(fmt.Errorf("failed to normalize query value: %w", err))
+			return q
+		}
+	}
+	q.preds = append(q.preds, predicate{path: p, op: op, value: v})
+	return q
+}
+
+// Select projects each result to an object holding only the given fields,
+// keyed by the path without its leading "$." (for example "customer.id").
+func (q *Query) Select(paths ...string) *Query {
+	for _, path := range paths {
+		p, err := jsonpath.Parse(path)
+		if err != nil {
+			q.setErr(err)
+			return q
+		}
+		q.fields = append(q.fields, p)
+	}
+	return q
+}
+
+// OrderBy sorts results by the field at path. Calls are cumulative; ties
+// and unordered results fall back to key order. Values sort by type first
+// (missing, null, booleans, numbers, strings, others) and then by value.
+func (q *Query) OrderBy(path string, desc bool) *Query {
+	p, err := jsonpath.Parse(path)
+	if err != nil {
+		q.setErr(err)
+		return q
+	}
+	q.order = append(q.order, sortKey{path: p, desc: desc})
+	return q
+}
+
+// Limit caps the number of results returned by All (n <= 0 means no limit).
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+// Offset skips the first n results returned by All.
+func (q *Query) Offset(n int) *Query {
+	if n < 0 {
+		q.setErr(fmt.Errorf("invalid offset %d: must not be negative", n))
+		return q
+	}
+	q.offset = n
+	return q
+}
+
+func (q *Query) setErr(err error) {
+	if q.err == nil {
+		q.err = err
+	}
+}
+
+// All runs the query and returns the matching values, sorted, offset, and
+// limited as requested.
+func (q *Query) All() ([]QueryResult, error) {
+	rows, err := q.run()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(q.order) > 0 {
+		sort.SliceStable(rows, func(i, j int) bool {
+			for _, o := range q.order {
+				a, aok := o.path.Eval(rows[i].doc)
+				b, bok := o.path.Eval(rows[j].doc)
+				c := compareFields(a, aok, b, bok)
+				if c != 0 {
+					return (c < 0) != o.desc
+				}
+			}
+			return false
+		})
+	}
+
+	if q.offset >= len(rows) {
+		return []QueryResult{}, nil
+	}
+	rows = rows[q.offset:]
+	if q.limit > 0 && q.limit < len(rows) {
+		rows = rows[:q.limit]
+	}
+
+	results := make([]QueryResult, len(rows))
+	for i, r := range rows {
+		results[i] = QueryResult{Key: r.key, Value: r.raw}
+		if q.store.codec.ID() != JSONCodec.ID() && len(q.fields) == 0 {
+			data, err := json.Marshal(r.doc)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal value for key %s: %w", r.key, err)
+			}
+			results[i].Value = data
+		}
+		if len(q.fields) > 0 {
+			projected := make(map[string]interface{}, len(q.fields))
+			for _, f := range q.fields {
+				if v, ok := f.Eval(r.doc); ok {
+					projected[strings.TrimPrefix(strings.TrimPrefix(f.String(), "$"), ".")] = v
+				}
+			}
+			data, err := json.Marshal(projected)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal projection for key %s: %w", r.key, err)
+			}
+			results[i].Value = data
+		}
+	}
+	return results, nil
+}
+
+// Aggregates cover every matching value; OrderBy, Limit, and Offset do not
+// apply to them.
+
+// Count returns the number of matching values.
+func (q *Query) Count() (int, error) {
+	rows, err := q.run()
+	return len(rows), err
+}
+
+// Sum returns the sum of the numeric field at path over matching values.
+// Values where the field is missing or not a number are skipped.
+func (q *Query) Sum(path string) (float64, error) {
+	p, err := jsonpath.Parse(path)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := q.run()
+	if err != nil {
+		return 0, err
+	}
+	var sum float64
+	for _, r := range rows {
+		if v, ok := p.Eval(r.doc); ok {
+			if n, ok := v.(float64); ok {
+				sum += n
+			}
+		}
+	}
+	return sum, nil
+}
+
+// Min returns the smallest value of the field at path over matching values,
+// using the same ordering as OrderBy, or nil if no value has the field.
+func (q *Query) Min(path string) (interface{}, error) {
+	return q.extreme(path, -1)
+}
+
+// Max returns the largest value of the field at path over matching values,
+// using the same ordering as OrderBy, or nil if no value has the field.
+func (q *Query) Max(path string) (interface{}, error) {
+	return q.extreme(path, 1)
+}
+
+func (q *Query) extreme(path string, sign int) (interface{}, error) {
+	p, err := jsonpath.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.run()
+	if err != nil {
+		return nil, err
+	}
+	var best interface{}
+	found := false
+	for _, r := range rows {
+		v, ok := p.Eval(r.doc)
+		if !ok {
+			continue
+		}
+		if !found || compareValues(v, best)*sign > 0 {
+			best, found = v, true
+		}
+	}
+	return best, nil
+}
+
+// run collects the candidate values, decodes them, and applies the
+// predicates. Rows are returned in key order.
+func (q *Query) run() ([]queryRow, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+
+	s := q.store
+	s.mu.RLock()
+	keys, index := q.candidatesLocked()
+	it := newIterator(s.root, keys, s.codec, s.now())
+	s.mu.RUnlock()
+	q.plan = index
+
+	rows := make([]queryRow, 0, len(it.keys))
+	for i, key := range it.keys {
+		if !strings.HasPrefix(key, q.prefix) {
+			continue
+		}
+		doc, err := codec.Generic(s.codec, it.values[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode value for key %s: %w", key, err)
+		}
+		if q.matches(doc) {
+			rows = append(rows, queryRow{key: key, raw: it.values[i], doc: doc})
+		}
+	}
+	return rows, nil
+}
+
+// matches reports whether doc satisfies every predicate.
+func (q *Query) matches(doc interface{}) bool {
+	for _, p := range q.preds {
+		v, ok := p.path.Eval(doc)
+		if p.op == "exists" {
+			if !ok {
+				return false
+			}
+			continue
+		}
+		if !ok {
+			return false
+		}
+
+		var match bool
+		switch p.op {
+		case "=":
+			match = valuesEqual(v, p.value)
+		case "!=":
+			match = !valuesEqual(v, p.value)
+		default:
+			if valueRank(v) != valueRank(p.value) || valueRank(v) > 4 {
+				return false
+			}
+			c := compareValues(v, p.value)
+			switch p.op {
+			case "<":
+				match = c < 0
+			case "<=":
+				match = c <= 0
+			case ">":
+				match = c > 0
+			case ">=":
+				match = c >= 0
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+// candidatesLocked returns the keys that may match, in key order, and the
+// name of the index used to find them ("" when scanning the prefix).
+// Equality predicates are preferred over ranges. The caller must hold s.mu.
+func (q *Query) candidatesLocked() ([]string, string) {
+	ks := q.store.root
+
+	names := make([]string, 0, len(ks.indexes))
+	for name, idx := range ks.indexes {
+		if strings.HasPrefix(q.prefix, idx.def.Prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var best []string
+	bestIndex, bestIsEq := "", false
+	for _, p := range q.preds {
+		if p.op == "!=" || p.op == "exists" {
+			continue
+		}
+		enc, ok := encodeIndexValue(p.value)
+		if !ok {
+			continue
+		}
+		for _, name := range names {
+			idx := ks.indexes[name]
+			if !idx.path.Equal(p.path) || (bestIndex != "" && (bestIsEq || p.op != "=")) {
+				continue
+			}
+
+			// Bounds stay within the value's type; predicates are re-checked
+			// against every candidate, so the bounds only need to be inclusive
+			typeStart, typeEnd := enc[:1], string(enc[0]+1)
+			var entries []string
+			switch p.op {
+			case "=":
+				entries = idx.entries.Prefix(enc + indexSep)
+			case ">":
+				entries = idx.entries.Range(enc+"\x00\x02", typeEnd, 0, false)
+			case ">=":
+				entries = idx.entries.Range(enc, typeEnd, 0, false)
+			case "<":
+				entries = idx.entries.Range(typeStart, enc, 0, false)
+			case "<=":
+				entries = idx.entries.Range(typeStart, enc+"\x00\x02", 0, false)
+			}
+			best, bestIndex, bestIsEq = entryKeys(entries), name, p.op == "="
+		}
+	}
+
+	if bestIndex == "" {
+		return ks.index.Prefix(q.prefix), ""
+	}
+	sort.Strings(best)
+	return best, bestIndex
+}
+
+// valueRank orders JSON types: missing, null, booleans, numbers, strings,
+// then objects and arrays.
+func valueRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 1
+	case bool:
+		return 2
+	case float64:
+		return 3
+	case string:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// compareValues orders two decoded JSON values by type, then by value.
+// Objects and arrays compare equal to each other.
+func compareValues(a, b interface{}) int {
+	ra, rb := valueRank(a), valueRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+	switch x := a.(type) {
+	case bool:
+		y := b.(bool)
+		if x == y {
+			return 0
+		}
+		if !x {
+			return -1
+		}
+		return 1
+	case float64:
+		y := b.(float64)
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, b.(string))
+	}
+	return 0
+}
+
+// compareFields is compareValues with missing fields sorting first.
+func compareFields(a interface{}, aok bool, b interface{}, bok bool) int {
+	switch {
+	case !aok && !bok:
+		return 0
+	case !aok:
+		return -1
+	case !bok:
+		return 1
+	}
+	return compareValues(a, b)
+}
+
+// valuesEqual reports whether two decoded JSON values are equal.
+func valuesEqual(a, b interface{}) bool {
+	if valueRank(a) <= 4 {
+		return valueRank(a) == valueRank(b) && compareValues(a, b) == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type queryOrder struct {
+	Status    string  `json:"status"`
+	Total     float64 `json:"total"`
+	CreatedAt string  `json:"created_at"`
+	Customer  struct {
+		ID string `json:"id"`
+	} `json:"customer"`
+}
+
+func newQueryStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	orders := map[string]interface{}{
+		"order:1": queryOrder{Status: "pending", Total: 150, CreatedAt: "2024-03-01"},
+		"order:2": queryOrder{Status: "pending", Total: 80, CreatedAt: "2024-01-01"},
+		"order:3": queryOrder{Status: "shipped", Total: 300, CreatedAt: "2024-02-01"},
+		"order:4": queryOrder{Status: "pending", Total: 120, CreatedAt: "2024-02-15"},
+		"order:5": map[string]interface{}{"status": "pending", "total": "n/a"},
+		"user:1":  queryOrder{Status: "pending", Total: 999},
+	}
+	if err := store.BatchSet(orders); err != nil {
+		t.Fatalf("BatchSet() failed: %v", err)
+	}
+	return store
+}
+
+func resultKeys(results []QueryResult) []string {
+	keys := make([]string, len(results))
+	for i, r := range results {
+		keys[i] = r.Key
+	}
+	return keys
+}
+
+func TestQuery(t *testing.T) {
+	store := newQueryStore(t)
+	defer store.Close()
+
+	results, err := store.Query("order:").
+		Where("$.status", "=", "pending").
+		Where("$.total", ">", 100).
+		OrderBy("$.created_at", false).
+		All()
+	if err != nil {
+		t.Fatalf("All() failed: %v", err)
+	}
+	if keys := resultKeys(results); !reflect.DeepEqual(keys, []string{"order:4", "order:1"}) {
+		t.Errorf("unexpected results: %v", keys)
+	}
+
+	results, err = store.Query("order:").
+		Where("status", "!=", "shipped").
+		OrderBy("$.total", true).
+		Offset(1).
+		Limit(2).
+		Select("$.total", "customer.id").
+		All()
+	if err != nil {
+		t.Fatalf("All() failed: %v", err)
+	}
+	// The string total sorts after numbers, so it comes first descending
+	if keys := resultKeys(results); !reflect.DeepEqual(keys, []string{"order:1", "order:4"}) {
+		t.Errorf("unexpected paged results: %v", keys)
+	}
+	var projected map[string]interface{}
+	results[0].Decode(&projected)
+	expected := map[string]interface{}{"total": float64(150), "customer.id": ""}
+	if !reflect.DeepEqual(projected, expected) {
+		t.Errorf("unexpected projection: %v", projected)
+	}
+
+	if _, err := store.Query("order:").Where("$.total", "~", 1).All(); err == nil {
+		t.Error("expected error for unsupported operator")
+	}
+}
+
+func TestQueryAggregates(t *testing.T) {
+	store := newQueryStore(t)
+	defer store.Close()
+
+	q := store.Query("order:").Where("$.status", "=", "pending")
+
+	if n, err := q.Count(); err != nil || n != 4 {
+		t.Errorf("expected count 4, got %d (err=%v)", n, err)
+	}
+	if sum, err := q.Sum("$.total"); err != nil || sum != 350 {
+		t.Errorf("expected sum 350, got %v (err=%v)", sum, err)
+	}
+	if min, err := store.Query("order:").Where("$.total", ">=", 0).Min("$.total"); err != nil || min != float64(80) {
+		t.Errorf("expected min 80, got %v (err=%v)", min, err)
+	}
+	if max, err := store.Query("order:").Max("$.created_at"); err != nil || max != "2024-03-01" {
+		t.Errorf("expected max 2024-03-01, got %v (err=%v)", max, err)
+	}
+	if max, err := store.Query("none:").Max("$.total"); err != nil || max != nil {
+		t.Errorf("expected nil max for empty result, got %v (err=%v)", max, err)
+	}
+}
+
+func TestQueryUsesIndex(t *testing.T) {
+	store := newQueryStore(t)
+	defer store.Close()
+
+	store.CreateIndex("by_total", "order:", "$.total")
+	store.CreateIndex("by_status", "", "status")
+
+	testCases := []struct {
+		name     string
+		query    *Query
+		plan     string
+		expected []string
+	}{
+		{"equality preferred", store.Query("order:").Where("$.total", ">", 100).Where("$.status", "=", "pending"), "by_status", []string{"order:1", "order:4"}},
+		{"range", store.Query("order:").Where("$.total", "<=", 120), "by_total", []string{"order:2", "order:4"}},
+		{"index prefix too narrow", store.Query("").Where("$.total", ">", 500), "", []string{"user:1"}},
+		{"no usable predicate", store.Query("order:").Where("$.status", "!=", "pending"), "", []string{"order:3"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			results, err := tc.query.All()
+			if err != nil {
+				t.Fatalf("All() failed: %v", err)
+			}
+			if keys := resultKeys(results); !reflect.DeepEqual(keys, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, keys)
+			}
+			if tc.query.plan != tc.plan {
+				t.Errorf("expected plan %q, got %q", tc.plan, tc.query.plan)
+			}
+		})
+	}
+}
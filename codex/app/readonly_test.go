@@ -0,0 +1,109 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadOnly(t *testing.T) {
+	for _, ledger := range []bool{false, true} {
+		name := "snapshot"
+		if ledger {
+			name = "ledger"
+		}
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			writer, err := NewWithOptions(path, Options{LedgerMode: ledger})
+			if err != nil {
+				t.Fatalf("failed to open writer: %v", err)
+			}
+			defer writer.Close()
+			writer.Set("user:1", map[string]string{"name": "alice"})
+			writer.Bucket("logs").Set("a", 1)
+			if err := writer.CreateIndex("by_name", "user:", "name"); err != nil {
+				t.Fatalf("CreateIndex failed: %v", err)
+			}
+
+			// Several readers open alongside the writer
+			readers := make([]*Store, 2)
+			for i := range readers {
+				readers[i], err = NewWithOptions(path, Options{LedgerMode: ledger, ReadOnly: true})
+				if err != nil {
+					t.Fatalf("failed to open reader %d: %v", i, err)
+				}
+				defer readers[i].Close()
+			}
+			r := readers[0]
+
+			var user map[string]string
+			if err := r.Get("user:1", &user); err != nil || user["name"] != "alice" {
+				t.Errorf("expected reader to see user:1, got %v (err=%v)", user, err)
+			}
+			if it, err := r.Lookup("by_name", "alice"); err != nil || it.Len() != 1 {
+				t.Errorf("expected index lookup to find 1 key (err=%v)", err)
+			}
+			var n int
+			if err := r.Bucket("logs").Get("a", &n); err != nil || n != 1 {
+				t.Errorf("expected reader to see bucket key, got %d (err=%v)", n, err)
+			}
+
+			writes := map[string]func() error{
+				"Set":             func() error { return r.Set("k", 1) },
+				"SetBytes":        func() error { return r.SetBytes("k", []byte{1}) },
+				"Delete":          func() error { return r.Delete("user:1") },
+				"Clear":           func() error { return r.Clear() },
+				"BatchSet":        func() error { return r.BatchSet(map[string]interface{}{"k": 1}) },
+				"BatchDelete":     func() error { return r.BatchDelete([]string{"user:1"}) },
+				"Batch.Execute":   func() error { b := r.NewBatch(); b.Set("k", 1); return b.Execute() },
+				"Update":          func() error { return r.Update(func(tx *Tx) error { return tx.Set("k", 1) }) },
+				"SetWithTTL":      func() error { return r.SetWithTTL("k", 1, time.Minute) },
+				"Expire":          func() error { return r.Expire("user:1", time.Minute) },
+				"SetIfAbsent":     func() error { return r.SetIfAbsent("k", 1) },
+				"DeleteIfVersion": func() error { return r.DeleteIfVersion("user:1", 1) },
+				"Bucket.Set":      func() error { return r.Bucket("logs").Set("b", 2) },
+				"DropBucket":      func() error { return r.DropBucket("logs") },
+				"CreateIndex":     func() error { return r.CreateIndex("other", "", "name") },
+				"DropIndex":       func() error { return r.DropIndex("by_name") },
+			}
+			for op, write := range writes {
+				if err := write(); !errors.Is(err, ErrReadOnly) {
+					t.Errorf("%s: expected ErrReadOnly, got %v", op, err)
+				}
+			}
+			if !r.Has("user:1") || r.Has("k") {
+				t.Error("rejected writes changed the reader's data")
+			}
+
+			// The writer is unaffected by its readers
+			if err := writer.Set("user:2", map[string]string{"name": "bob"}); err != nil {
+				t.Errorf("writer Set with readers open failed: %v", err)
+			}
+			if _, err := NewWithOptions(path, Options{LedgerMode: ledger}); !errors.Is(err, ErrLocked) {
+				t.Errorf("expected ErrLocked for a second writer, got %v", err)
+			}
+
+			// A reader opened later sees the new data
+			late, err := NewWithOptions(path, Options{LedgerMode: ledger, ReadOnly: true})
+			if err != nil {
+				t.Fatalf("failed to open late reader: %v", err)
+			}
+			defer late.Close()
+			if !late.Has("user:2") {
+				t.Error("expected late reader to see user:2")
+			}
+		})
+	}
+}
+
+func TestReadOnlyMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "test.db")
+	if _, err := NewWithOptions(path, Options{ReadOnly: true}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+		t.Error("read-only open created the store directory")
+	}
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/evertonmj/codex/codex/app/src/backup"
+	"github.com/evertonmj/codex/codex/app/src/storage"
+)
+
+// RotateKey re-encrypts the database with newKey, which must be 16, 24, or
+// 32 bytes, while it stays open. A snapshot is rewritten, a ledger is
+// rewritten with its history, and hybrid mode writes a checkpoint and a new
+// log. Each file is written in full and atomically swapped in, so readers
+// never see a mix of keys; writers wait until the rotation is done. The
+// new key replaces Options.Passphrase, if the store was opened with one;
+// use RotatePassphrase to keep encrypting with a passphrase.
+//
+// Backups are re-encrypted as well. A backup that cannot be read with the
+// old key is renamed with an ".oldkey" suffix, taking it out of rotation.
+// Ledger hash chains are rebuilt and re-signed, as by a compaction, so a
+// store with TrustedKeys but no Signer returns ErrSignerRequired.
+func (s *Store) RotateKey(newKey []byte) error {
+	if n := len(newKey); n != 16 && n != 24 && n != 32 {
+		return ErrInvalidKey
+	}
+	return s.rotate(storage.Options{EncryptionKey: append([]byte(nil), newKey...)})
+}
+
+// RotatePassphrase re-encrypts the database, as RotateKey does, with a key
+// derived from newPass by Options.KDF with a fresh salt. The store must be
+// reopened with newPass as its Passphrase; a store opened with an
+// EncryptionKey switches to the passphrase.
+func (s *Store) RotatePassphrase(newPass []byte) error {
+	if len(newPass) == 0 {
+		return fmt.Errorf("Passphrase must not be empty")
+	}
+	return s.rotate(storage.Options{Passphrase: append([]byte(nil), newPass...), KDF: s.options.KDF})
+}
+
+// rotate re-encrypts the database and its backups with the key or
+// passphrase of to.
+func (s *Store) rotate(to storage.Options) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	// Derive once, so every file shares the salt
+	to, err := storage.DeriveKey(to)
+	if err != nil {
+		return err
+	}
+
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+
+	switch storer := s.storer.(type) {
+	case *storage.Ledger:
+		err = storer.Rekey(to)
+	case *storage.Hybrid:
+		err = storer.Rekey(to, s.persistedState())
+	case *storage.Snapshot:
+		err = storer.Rekey(to, s.persistedState())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rotate key: %w", err)
+	}
+	// The rewritten files were synced in full
+	s.flushedHeld()
+
+	old := storage.Options{
+		EncryptionKey: s.options.EncryptionKey,
+		Compression:   s.options.Compression,
+		Codec:         s.codec.ID(),
+	}
+	if s.options.Passphrase != nil {
+		old.EncryptionKey, old.Passphrase = nil, s.options.Passphrase
+	}
+
+	s.mu.Lock()
+	s.options.EncryptionKey, s.options.Passphrase = to.EncryptionKey, to.Passphrase
+	err = s.saveIndexes()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return rekeyBackups(s.path, old, to)
+}
+
+// rekeyBackups re-encrypts the backups of path, read with old, as set by to.
+func rekeyBackups(path string, old storage.Options, to storage.Options) error {
+	backups, err := backup.List(path)
+	if err != nil {
+		return err
+	}
+	for _, b := range backups {
+		if storage.RekeyFile(b, old, to) == nil {
+			continue
+		}
+		if err := os.Rename(b, b+".oldkey"); err != nil {
+			return fmt.Errorf("key rotated, but failed to mark backup %s: %w", b, err)
+		}
+	}
+	return nil
+}
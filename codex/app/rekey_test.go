@@ -0,0 +1,165 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateKey(t *testing.T) {
+	oldKey := []byte("0123456789abcdef0123456789abcdef")
+	newKey := []byte("fedcba9876543210")
+	modes := map[string]Options{
+		"snapshot":   {EncryptionKey: oldKey, NumBackups: 2},
+		"ledger":     {EncryptionKey: oldKey, LedgerMode: true},
+		"hybrid":     {EncryptionKey: oldKey, HybridMode: true},
+		"passphrase": {Passphrase: []byte("passphrase"), KDF: KDFParams{Algorithm: KDFScrypt, N: 1024}, LedgerMode: true},
+		"plaintext":  {},
+	}
+	for name, opts := range modes {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			store, err := NewWithOptions(path, opts)
+			if err != nil {
+				t.Fatalf("failed to open store: %v", err)
+			}
+			store.Set("a", 1)
+			store.Set("b", 2)
+			store.CreateIndex("by_value", "", "$")
+			if err := store.RotateKey(newKey); err != nil {
+				t.Fatalf("RotateKey() failed: %v", err)
+			}
+			store.Set("c", 3)
+			store.Close()
+
+			reopened := opts
+			reopened.EncryptionKey, reopened.Passphrase = newKey, nil
+			store, err = NewWithOptions(path, reopened)
+			if err != nil {
+				t.Fatalf("failed to reopen with the new key: %v", err)
+			}
+			if keys := store.Keys(); len(keys) != 3 {
+				t.Errorf("expected 3 keys, got %v", keys)
+			}
+			if store.readIndexFile() == nil {
+				t.Error("expected the index file to be rewritten with the new key")
+			}
+			store.Close()
+
+			if opts.EncryptionKey != nil {
+				opts.ReadOnly = true
+				if _, err := NewWithOptions(path, opts); !errors.Is(err, ErrWrongKey) {
+					t.Errorf("expected ErrWrongKey for the old key, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRotatePassphrase(t *testing.T) {
+	kdf := KDFParams{Algorithm: KDFScrypt, N: 1024}
+	oldPass, newPass := []byte("passphrase"), []byte("new passphrase")
+	modes := map[string]Options{
+		"snapshot": {Passphrase: oldPass, KDF: kdf, NumBackups: 2},
+		"ledger":   {Passphrase: oldPass, KDF: kdf, LedgerMode: true},
+		"hybrid":   {Passphrase: oldPass, KDF: kdf, HybridMode: true},
+		"raw key":  {EncryptionKey: []byte("0123456789abcdef"), KDF: kdf},
+	}
+	for name, opts := range modes {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			store, err := NewWithOptions(path, opts)
+			if err != nil {
+				t.Fatalf("failed to open store: %v", err)
+			}
+			store.Set("a", 1)
+			store.Set("b", 2)
+			store.CreateIndex("by_value", "", "$")
+			if err := store.RotatePassphrase(newPass); err != nil {
+				t.Fatalf("RotatePassphrase() failed: %v", err)
+			}
+			store.Set("c", 3)
+			store.Close()
+
+			reopened := opts
+			reopened.EncryptionKey, reopened.Passphrase = nil, newPass
+			store, err = NewWithOptions(path, reopened)
+			if err != nil {
+				t.Fatalf("failed to reopen with the new passphrase: %v", err)
+			}
+			if keys := store.Keys(); len(keys) != 3 {
+				t.Errorf("expected 3 keys, got %v", keys)
+			}
+			if store.readIndexFile() == nil {
+				t.Error("expected the index file to be rewritten with the new key")
+			}
+			store.Close()
+
+			if opts.NumBackups > 0 {
+				b, err := NewWithOptions(path+".bak.1", Options{Passphrase: newPass, ReadOnly: true})
+				if err != nil {
+					t.Errorf("expected the backup to open with the new passphrase, got %v", err)
+				} else {
+					b.Close()
+				}
+			}
+			opts.ReadOnly = true
+			if _, err := NewWithOptions(path, opts); !errors.Is(err, ErrWrongKey) && !errors.Is(err, ErrOptionsMismatch) {
+				t.Errorf("expected the old key to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRotateKeyBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	opts := Options{EncryptionKey: []byte("0123456789abcdef"), NumBackups: 3}
+	store, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+	for i := 0; i < 3; i++ {
+		store.Set("key", i)
+	}
+	os.WriteFile(path+".bak.3", []byte("not a snapshot"), 0600)
+
+	newKey := []byte("fedcba9876543210")
+	if err := store.RotateKey(newKey); err != nil {
+		t.Fatalf("RotateKey() failed: %v", err)
+	}
+	for _, backup := range []string{path + ".bak.1", path + ".bak.2"} {
+		b, err := NewWithOptions(backup, Options{EncryptionKey: newKey, ReadOnly: true})
+		if err != nil {
+			t.Errorf("expected %s to open with the new key, got %v", backup, err)
+			continue
+		}
+		b.Close()
+	}
+	if _, err := os.Stat(path + ".bak.3.oldkey"); err != nil {
+		t.Errorf("expected the unreadable backup to be marked: %v", err)
+	}
+}
+
+func TestRotateKeyInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := New(path)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	store.Set("a", 1)
+	if err := store.RotateKey([]byte("short")); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey, got %v", err)
+	}
+	store.Close()
+
+	store, err = NewWithOptions(path, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open read-only store: %v", err)
+	}
+	defer store.Close()
+	if err := store.RotateKey([]byte("0123456789abcdef")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+}
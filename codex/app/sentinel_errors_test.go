@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 // TestErrNotFound verifies that the ErrNotFound sentinel error is returned
@@ -96,6 +97,72 @@ func TestErrLocked(t *testing.T) {
 	t.Log("ErrLocked sentinel error works correctly")
 }
 
+// TestErrLockedHolder verifies that ErrLocked names the holder and that
+// LockTimeout waits for it to close
+func TestErrLockedHolder(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+
+	store1, err := New(storePath)
+	if err != nil {
+		t.Fatalf("Failed to create first store: %v", err)
+	}
+
+	_, err = NewWithOptions(storePath, Options{LockTimeout: 50 * time.Millisecond})
+	var locked *LockedError
+	if !errors.Is(err, ErrLocked) || !errors.As(err, &locked) {
+		t.Fatalf("Expected ErrLocked with a LockedError, got: %v", err)
+	}
+	if locked.Holder.PID != os.Getpid() || locked.Holder.Stale() {
+		t.Errorf("Expected the running test process as holder, got: %v", locked.Holder)
+	}
+
+	released := make(chan struct{})
+	go func() {
+		defer close(released)
+		time.Sleep(100 * time.Millisecond)
+		store1.Close()
+	}()
+	store2, err := NewWithOptions(storePath, Options{LockTimeout: 5 * time.Second})
+	<-released
+	if err != nil {
+		t.Fatalf("Expected LockTimeout to wait for the first store, got: %v", err)
+	}
+	store2.Close()
+}
+
+// TestErrWrongKey verifies that a wrong key or mismatched options are told
+// apart from corruption when opening a store
+func TestErrWrongKey(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	store, err := NewWithOptions(storePath, Options{EncryptionKey: key, LedgerMode: true})
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.Set("key", "value")
+	store.Close()
+
+	tests := []struct {
+		name string
+		opts Options
+		err  error
+	}{
+		{"wrong key", Options{EncryptionKey: []byte("fedcba9876543210fedcba9876543210"), LedgerMode: true}, ErrWrongKey},
+		{"no key", Options{LedgerMode: true}, ErrOptionsMismatch},
+		{"snapshot mode", Options{EncryptionKey: key}, ErrOptionsMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.ReadOnly = true
+			_, err := NewWithOptions(storePath, tt.opts)
+			if !errors.Is(err, tt.err) || errors.Is(err, ErrCorrupted) {
+				t.Errorf("Expected %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
 // TestSentinelErrorsUsage demonstrates how users should use sentinel errors
 func TestSentinelErrorsUsage(t *testing.T) {
 	tempDir := t.TempDir()
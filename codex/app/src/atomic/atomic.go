@@ -71,6 +71,16 @@ func WriteFile(filename string, data []byte, perm os.FileMode) error {
 	return syncDir(dir)
 }
 
+// Replace atomically renames tmpName, which must be fully written and
+// synced, over filename and makes the rename durable.
+// Both files must be in the same directory.
+func Replace(tmpName, filename string) error {
+	if err := os.Rename(tmpName, filename); err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return syncDir(filepath.Dir(filename))
+}
+
 // syncDir syncs a directory to ensure file operations are durable
 func syncDir(dir string) error {
 	d, err := os.Open(dir)
@@ -181,3 +181,25 @@ func TestAtomicity(t *testing.T) {
 		}
 	}
 }
+
+func TestReplace(t *testing.T) {
+	tmpDir := t.TempDir()
+	filename := filepath.Join(tmpDir, "test.txt")
+	tmpName := filepath.Join(tmpDir, "test.txt.new")
+
+	os.WriteFile(filename, []byte("old"), 0600)
+	os.WriteFile(tmpName, []byte("new"), 0600)
+
+	if err := Replace(tmpName, filename); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	if read, _ := os.ReadFile(filename); string(read) != "new" {
+		t.Errorf("expected replaced contents, got %q", read)
+	}
+	if Exists(tmpName) {
+		t.Error("expected temp file to be gone")
+	}
+	if err := Replace(tmpName, filename); err == nil {
+		t.Error("expected error replacing with a missing file")
+	}
+}
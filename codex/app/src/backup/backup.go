@@ -24,7 +24,10 @@ package backup
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -78,3 +81,27 @@ func Create(path string, numBackups int) error {
 
 	return nil
 }
+
+// List returns the paths of the existing backups of path, newest first.
+func List(path string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	prefix := filepath.Base(path) + ".bak."
+	numbers := make(map[string]int)
+	var backups []string
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if n, err := strconv.Atoi(name); err == nil && n > 0 {
+			backup := path + ".bak." + name
+			numbers[backup] = n
+			backups = append(backups, backup)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return numbers[backups[i]] < numbers[backups[j]] })
+	return backups, nil
+}
@@ -61,3 +61,20 @@ func TestCreate(t *testing.T) {
 		t.Errorf("Expected backup content 'version4', got '%s'", string(content))
 	}
 }
+
+func TestList(t *testing.T) {
+	tempDir := t.TempDir()
+	storePath := filepath.Join(tempDir, "test.db")
+	for _, name := range []string{"test.db", "test.db.bak.10", "test.db.bak.2", "test.db.bak.1", "test.db.bak.2.oldkey", "other.db.bak.1"} {
+		os.WriteFile(filepath.Join(tempDir, name), nil, 0600)
+	}
+
+	backups, err := List(storePath)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	expected := []string{storePath + ".bak.1", storePath + ".bak.2", storePath + ".bak.10"}
+	if fmt.Sprint(backups) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, backups)
+	}
+}
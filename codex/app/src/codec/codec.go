@@ -0,0 +1,161 @@
+// Package codec provides the value encodings a store can use to turn Go
+// values into the bytes it keeps in memory and on disk.
+//
+// Built-in codecs:
+//   - JSON: encoding/json (default, human-readable, queryable)
+//   - Gob: encoding/gob (compact and fast for Go structs)
+//   - MessagePack: binary JSON-compatible encoding (compact, queryable)
+//   - Raw: stores []byte and string values as-is
+//
+// Every codec has a stable ID that stores record in their files, so data
+// written with one codec is never decoded with another.
+//
+// Example:
+//
+//	data, err := codec.MessagePack.Marshal(user)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//
+//	var decoded User
+//	if err := codec.MessagePack.Unmarshal(data, &decoded); err != nil {
+//	    log.Fatal(err)
+//	}
+package codec
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Codec encodes and decodes stored values.
+type Codec interface {
+	// ID identifies the encoding. It is recorded in database files and
+	// must never change once data has been written with it.
+	ID() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSON encodes values with encoding/json.
+	JSON Codec = jsonCodec{}
+	// Gob encodes values with encoding/gob. Values cannot be decoded into
+	// interface{}, so BatchGet, indexes, and queries do not work with it.
+	Gob Codec = gobCodec{}
+	// MessagePack encodes values as MessagePack, following the same field
+	// names and rules as encoding/json.
+	MessagePack Codec = msgpackCodec{}
+	// Raw stores byte slices and strings unchanged. Decoding into
+	// interface{} yields a []byte.
+	Raw Codec = rawCodec{}
+)
+
+// ByID returns the built-in codec with the given ID.
+func ByID(id string) (Codec, bool) {
+	for _, c := range []Codec{JSON, Gob, MessagePack, Raw} {
+		if c.ID() == id {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Generic decodes data into the form encoding/json gives an interface{}:
+// maps, slices, float64, string, bool, and nil.
+func Generic(c Codec, data []byte) (interface{}, error) {
+	var v interface{}
+	if err := c.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	if c.ID() == JSON.ID() || c.ID() == MessagePack.ID() {
+		return v, nil
+	}
+
+	// Other codecs may produce any Go type; convert through JSON
+	normalized, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	v = nil
+	if err := json.Unmarshal(normalized, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() string { return "json" }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ID() string { return "gob" }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) ID() string { return "raw" }
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(encoding.BinaryMarshaler); ok {
+		return m.MarshalBinary()
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case !rv.IsValid():
+		return nil, fmt.Errorf("raw codec cannot encode nil")
+	case rv.Kind() == reflect.String:
+		return append([]byte{}, rv.String()...), nil
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8:
+		return append([]byte{}, rv.Bytes()...), nil
+	}
+	return nil, fmt.Errorf("raw codec cannot encode %T: only []byte and string values are supported", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	if u, ok := v.(encoding.BinaryUnmarshaler); ok {
+		return u.UnmarshalBinary(data)
+	}
+	if p, ok := v.(*interface{}); ok {
+		*p = append([]byte{}, data...)
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("raw codec cannot decode into %T: need a non-nil pointer", v)
+	}
+	elem := rv.Elem()
+	switch {
+	case elem.Kind() == reflect.String:
+		elem.SetString(string(data))
+		return nil
+	case elem.Kind() == reflect.Slice && elem.Type().Elem().Kind() == reflect.Uint8:
+		elem.SetBytes(append([]byte{}, data...))
+		return nil
+	}
+	return fmt.Errorf("raw codec cannot decode into %T: only []byte and string values are supported", v)
+}
@@ -0,0 +1,239 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type record struct {
+	Name    string            `json:"name"`
+	Count   int64             `json:"count"`
+	Big     uint64            `json:"big"`
+	Ratio   float64           `json:"ratio"`
+	Tags    []string          `json:"tags"`
+	Attrs   map[string]string `json:"attrs"`
+	Payload []byte            `json:"payload"`
+	Nested  *record           `json:"nested,omitempty"`
+}
+
+func sampleRecord() record {
+	return record{
+		Name:    strings.Repeat("x", 300),
+		Count:   math.MinInt64,
+		Big:     math.MaxUint64,
+		Ratio:   -2.5,
+		Tags:    []string{"a", "", "ccc"},
+		Attrs:   map[string]string{"k": "v", "z": "w"},
+		Payload: []byte{0, 1, 2},
+		Nested:  &record{Name: "inner", Count: 70000},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range []Codec{JSON, Gob, MessagePack} {
+		t.Run(c.ID(), func(t *testing.T) {
+			original := sampleRecord()
+			data, err := c.Marshal(original)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			var decoded record
+			if err := c.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if !reflect.DeepEqual(original, decoded) {
+				t.Errorf("round trip mismatch:\n got %+v\nwant %+v", decoded, original)
+			}
+		})
+	}
+}
+
+func TestMessagePackIntegers(t *testing.T) {
+	values := []int64{0, 1, 127, 128, 255, 256, 65535, 65536, math.MaxUint32, math.MaxUint32 + 1, math.MaxInt64,
+		-1, -32, -33, -128, -129, -32768, -32769, math.MinInt32, math.MinInt32 - 1, math.MinInt64}
+	for _, n := range values {
+		data, err := MessagePack.Marshal(n)
+		if err != nil {
+			t.Fatalf("Marshal(%d) failed: %v", n, err)
+		}
+		var decoded int64
+		if err := MessagePack.Unmarshal(data, &decoded); err != nil || decoded != n {
+			t.Errorf("expected %d, got %d (err=%v)", n, decoded, err)
+		}
+	}
+
+	// Known encodings from the specification
+	data, _ := MessagePack.Marshal(map[string]interface{}{"a": []int{1, -1}, "b": nil})
+	expected := []byte{0x82, 0xa1, 'a', 0x92, 0x01, 0xff, 0xa1, 'b', 0xc0}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("expected % x, got % x", expected, data)
+	}
+}
+
+func TestMessagePackErrors(t *testing.T) {
+	var v interface{}
+	for _, data := range [][]byte{{}, {0xa5, 'a'}, {0x92, 0x01}, {0xc1}, {0x01, 0x02}} {
+		if err := MessagePack.Unmarshal(data, &v); err == nil {
+			t.Errorf("expected error decoding % x", data)
+		}
+	}
+}
+
+func TestRaw(t *testing.T) {
+	data, err := Raw.Marshal("hello")
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("unexpected Marshal result %q (err=%v)", data, err)
+	}
+	if _, err := Raw.Marshal(42); err == nil {
+		t.Error("expected error encoding an int")
+	}
+
+	var b []byte
+	if err := Raw.Unmarshal([]byte{1, 2}, &b); err != nil || !bytes.Equal(b, []byte{1, 2}) {
+		t.Errorf("unexpected bytes %v (err=%v)", b, err)
+	}
+	var s string
+	if err := Raw.Unmarshal([]byte("hi"), &s); err != nil || s != "hi" {
+		t.Errorf("unexpected string %q (err=%v)", s, err)
+	}
+	var v interface{}
+	if err := Raw.Unmarshal([]byte("hi"), &v); err != nil || !bytes.Equal(v.([]byte), []byte("hi")) {
+		t.Errorf("unexpected generic value %v (err=%v)", v, err)
+	}
+}
+
+func TestGeneric(t *testing.T) {
+	expected := map[string]interface{}{"n": float64(3), "s": "x", "l": []interface{}{true, nil}}
+	for _, c := range []Codec{JSON, MessagePack} {
+		data, _ := c.Marshal(map[string]interface{}{"n": 3, "s": "x", "l": []interface{}{true, nil}})
+		v, err := Generic(c, data)
+		if err != nil || !reflect.DeepEqual(v, expected) {
+			t.Errorf("%s: unexpected generic value %v (err=%v)", c.ID(), v, err)
+		}
+	}
+
+	data, _ := Gob.Marshal(sampleRecord())
+	if _, err := Generic(Gob, data); err == nil {
+		t.Error("expected gob values not to decode generically")
+	}
+}
+
+func TestByID(t *testing.T) {
+	for _, c := range []Codec{JSON, Gob, MessagePack, Raw} {
+		if got, ok := ByID(c.ID()); !ok || got != c {
+			t.Errorf("ByID(%q) did not return the codec", c.ID())
+		}
+	}
+	if _, ok := ByID("xml"); ok {
+		t.Error("expected unknown ID to fail")
+	}
+}
+
+type Base struct {
+	ID   int    `json:"id"`
+	Note string `json:"note,omitempty"`
+}
+
+type document struct {
+	*Base
+	Title   string                 `json:"title"`
+	Created time.Time              `json:"created"`
+	Count   int                    `json:"count,string"`
+	Scores  [3]float32             `json:"scores"`
+	ByID    map[int]string         `json:"by_id"`
+	Any     interface{}            `json:"any"`
+	Number  json.Number            `json:"number"`
+	Skipped string                 `json:"-"`
+	Empty   []string               `json:"empty,omitempty"`
+	Extra   map[string]interface{} `json:"extra"`
+	hidden  int
+}
+
+func TestMessagePackMatchesJSON(t *testing.T) {
+	original := document{
+		Base:    &Base{ID: 7},
+		Title:   "doc",
+		Created: time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		Count:   42,
+		Scores:  [3]float32{0.1, 2, -3.5},
+		ByID:    map[int]string{1: "a", -2: "b"},
+		Any:     []interface{}{1.5, "x", map[string]interface{}{"y": true}},
+		Number:  "12.5",
+		Skipped: "gone",
+		Extra:   map[string]interface{}{"n": 3.0, "s": []interface{}{}},
+		hidden:  1,
+	}
+
+	// Both codecs decode to the same value, and in interface{} to the same
+	// generic form
+	jsonData, _ := JSON.Marshal(original)
+	var want document
+	var wantGeneric interface{}
+	if err := json.Unmarshal(jsonData, &want); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	json.Unmarshal(jsonData, &wantGeneric)
+
+	data, err := MessagePack.Marshal(original)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var got document
+	if err := MessagePack.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mismatch with JSON:\n got %+v\nwant %+v", got, want)
+	}
+	generic, err := Generic(MessagePack, data)
+	if err != nil || !reflect.DeepEqual(generic, wantGeneric) {
+		t.Errorf("generic mismatch with JSON:\n got %v\nwant %v (err=%v)", generic, wantGeneric, err)
+	}
+
+	// Decoding fails where JSON would fail
+	var small struct {
+		N int8 `json:"n"`
+	}
+	data, _ = MessagePack.Marshal(map[string]int{"n": 300})
+	if err := MessagePack.Unmarshal(data, &small); err == nil {
+		t.Error("expected overflow error")
+	}
+	data, _ = MessagePack.Marshal(map[string]string{"n": "x"})
+	if err := MessagePack.Unmarshal(data, &small); err == nil {
+		t.Error("expected type error")
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	for _, c := range []Codec{JSON, MessagePack} {
+		b.Run(c.ID(), func(b *testing.B) {
+			v := sampleRecord()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.Marshal(v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	for _, c := range []Codec{JSON, MessagePack} {
+		b.Run(c.ID(), func(b *testing.B) {
+			data, _ := c.Marshal(sampleRecord())
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var v record
+				if err := c.Unmarshal(data, &v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
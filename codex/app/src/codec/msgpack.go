@@ -0,0 +1,669 @@
+package codec
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// msgpackCodec implements MessagePack with the rules of encoding/json:
+// struct tags, omitempty, Marshaler and TextMarshaler implementations, and
+// the types values decode to in interface{} are the same as with the JSON
+// codec. Values are encoded and decoded directly; only types with their
+// own MarshalJSON or UnmarshalJSON go through their JSON form. Integers
+// keep full 64-bit precision and []byte is stored as binary data.
+type msgpackCodec struct{}
+
+func (msgpackCodec) ID() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	e := msgpackEncoder{buf: make([]byte, 0, 64)}
+	if err := e.encode(reflect.ValueOf(v), 0); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("msgpack: cannot decode into %T: need a non-nil pointer", v)
+	}
+	d := msgpackDecoder{data: data}
+	if err := d.decodeInto(rv, 0); err != nil {
+		return err
+	}
+	if d.pos != len(data) {
+		return fmt.Errorf("msgpack: %d trailing bytes", len(data)-d.pos)
+	}
+	return nil
+}
+
+// maxMsgpackDepth bounds the nesting of encoded and decoded values, so a
+// cyclic value or hostile input fails instead of exhausting the stack.
+const maxMsgpackDepth = 10000
+
+var (
+	jsonMarshalerType   = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	jsonNumberType      = reflect.TypeFor[json.Number]()
+)
+
+// msgpackEncoder appends the MessagePack encoding of values to buf.
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value, depth int) error {
+	if depth > maxMsgpackDepth {
+		return fmt.Errorf("msgpack: value nested too deeply or cyclic")
+	}
+	if !v.IsValid() {
+		e.buf = append(e.buf, 0xc0)
+		return nil
+	}
+
+	t := v.Type()
+	if m := cachedMarshalers(t); m != 0 {
+		switch {
+		case m&jsonMarshaler != 0 || (m&jsonMarshalerAddr != 0 && v.CanAddr()):
+			return e.encodeMarshaler(v)
+		case m&textMarshaler != 0 || (m&textMarshalerAddr != 0 && v.CanAddr()):
+			return e.encodeTextMarshaler(v)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		e.appendBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.appendInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.appendUint(v.Uint())
+	case reflect.Float32:
+		// Use the shortest decimal form, as encoding/json does
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(v.Float(), 'g', -1, 32), 64)
+		return e.encodeFloat(f)
+	case reflect.Float64:
+		return e.encodeFloat(v.Float())
+	case reflect.String:
+		if t == jsonNumberType {
+			return e.encodeNumber(v.String())
+		}
+		e.appendString(v.String())
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		return e.encode(v.Elem(), depth+1)
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 && cachedMarshalers(reflect.PointerTo(t.Elem())) == 0 {
+			e.appendBinary(v.Bytes())
+			return nil
+		}
+		return e.encodeArray(v, depth)
+	case reflect.Array:
+		return e.encodeArray(v, depth)
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		return e.encodeMap(v, depth)
+	case reflect.Struct:
+		return e.encodeStruct(v, depth)
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", t)
+	}
+	return nil
+}
+
+// encodeMarshaler encodes the JSON form of a json.Marshaler.
+func (e *msgpackEncoder) encodeMarshaler(v reflect.Value) error {
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		e.buf = append(e.buf, 0xc0)
+		return nil
+	}
+	if !v.Type().Implements(jsonMarshalerType) {
+		v = v.Addr()
+	}
+	js, err := json.Marshal(v.Interface())
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return err
+	}
+	return e.encodeGeneric(generic)
+}
+
+// encodeTextMarshaler encodes the text of an encoding.TextMarshaler as a string.
+func (e *msgpackEncoder) encodeTextMarshaler(v reflect.Value) error {
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		e.buf = append(e.buf, 0xc0)
+		return nil
+	}
+	if !v.Type().Implements(textMarshalerType) {
+		v = v.Addr()
+	}
+	text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+	if err != nil {
+		return err
+	}
+	e.appendString(string(text))
+	return nil
+}
+
+func (e *msgpackEncoder) encodeArray(v reflect.Value, depth int) error {
+	n := v.Len()
+	e.appendLength(n, 0x90, 16, 0, 0xdc, 0xdd)
+	for i := 0; i < n; i++ {
+		if err := e.encode(v.Index(i), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeMap writes the entries of a map sorted by key, as encoding/json
+// does, so equal values always encode to the same bytes.
+func (e *msgpackEncoder) encodeMap(v reflect.Value, depth int) error {
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+	entries := make([]entry, 0, v.Len())
+	for it := v.MapRange(); it.Next(); {
+		key, err := mapKeyString(it.Key())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{key, it.Value()})
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return strings.Compare(a.key, b.key) })
+
+	e.appendLength(len(entries), 0x80, 16, 0, 0xde, 0xdf)
+	for _, en := range entries {
+		e.appendString(en.key)
+		if err := e.encode(en.value, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapKeyString returns the string form of a map key, following encoding/json.
+func mapKeyString(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Pointer && k.IsNil() {
+			return "", nil
+		}
+		text, err := tm.MarshalText()
+		return string(text), err
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("msgpack: unsupported map key type %s", k.Type())
+}
+
+func (e *msgpackEncoder) encodeStruct(v reflect.Value, depth int) error {
+	fields := cachedFields(v.Type()).list
+	omitted := func(f *structField) (reflect.Value, bool) {
+		fv, ok := fieldByIndex(v, f.index)
+		return fv, !ok || (f.omitEmpty && isEmptyValue(fv)) || (f.omitZero && isZeroValue(fv))
+	}
+
+	// The map header holds the number of fields written: with fewer than
+	// 16 it is one byte, patched afterwards; otherwise count them first
+	header := len(e.buf)
+	if len(fields) < 16 {
+		e.buf = append(e.buf, 0x80)
+	} else {
+		n := 0
+		for i := range fields {
+			if _, skip := omitted(&fields[i]); !skip {
+				n++
+			}
+		}
+		e.appendLength(n, 0x80, 16, 0, 0xde, 0xdf)
+	}
+
+	n := 0
+	for i := range fields {
+		f := &fields[i]
+		fv, skip := omitted(f)
+		if skip {
+			continue
+		}
+		n++
+		e.appendString(f.name)
+		if f.quoted {
+			js, err := json.Marshal(fv.Interface())
+			if err != nil {
+				return err
+			}
+			e.appendString(string(js))
+			continue
+		}
+		if err := e.encode(fv, depth+1); err != nil {
+			return err
+		}
+	}
+	if len(fields) < 16 {
+		e.buf[header] = 0x80 | byte(n)
+	}
+	return nil
+}
+
+// fieldByIndex returns the field of struct v at index, or false if it is
+// inside a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isEmptyValue reports whether v is empty in the sense of omitempty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+// isZeroValue reports whether v is zero in the sense of omitzero: by its
+// IsZero method if it has one, and by reflect.Value.IsZero otherwise.
+func isZeroValue(v reflect.Value) bool {
+	if z, ok := v.Interface().(interface{ IsZero() bool }); ok {
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return true
+		}
+		return z.IsZero()
+	}
+	return v.IsZero()
+}
+
+// encodeFloat writes f, which JSON can represent only if it is finite.
+func (e *msgpackEncoder) encodeFloat(f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("msgpack: unsupported value %v", f)
+	}
+	e.appendFloat(f)
+	return nil
+}
+
+// encodeNumber writes a json.Number.
+func (e *msgpackEncoder) encodeNumber(s string) error {
+	if s == "" {
+		s = "0" // As encoding/json
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		e.appendInt(n)
+	} else if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		e.appendUint(u)
+	} else {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("msgpack: invalid number %s", s)
+		}
+		e.appendFloat(f)
+	}
+	return nil
+}
+
+// encodeGeneric writes a value decoded by encoding/json with UseNumber.
+func (e *msgpackEncoder) encodeGeneric(v interface{}) error {
+	switch x := v.(type) {
+	case nil:
+		e.buf = append(e.buf, 0xc0)
+	case bool:
+		e.appendBool(x)
+	case json.Number:
+		return e.encodeNumber(string(x))
+	case string:
+		e.appendString(x)
+	case []interface{}:
+		e.appendLength(len(x), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, el := range x {
+			if err := e.encodeGeneric(el); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		e.appendLength(len(x), 0x80, 16, 0, 0xde, 0xdf)
+		for _, k := range keys {
+			e.appendString(k)
+			if err := e.encodeGeneric(x[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: cannot encode %T", v)
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) appendBool(b bool) {
+	if b {
+		e.buf = append(e.buf, 0xc3)
+	} else {
+		e.buf = append(e.buf, 0xc2)
+	}
+}
+
+func (e *msgpackEncoder) appendString(s string) {
+	e.appendLength(len(s), 0xa0, 32, 0xd9, 0xda, 0xdb)
+	e.buf = append(e.buf, s...)
+}
+
+func (e *msgpackEncoder) appendBinary(b []byte) {
+	switch n := len(b); {
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xc5), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xc6), uint32(n))
+	}
+	e.buf = append(e.buf, b...)
+}
+
+// appendFloat writes f as an integer when it has no fractional part and
+// fits in one, which is smaller and decodes to the same float64.
+func (e *msgpackEncoder) appendFloat(f float64) {
+	if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+		e.appendInt(int64(f))
+		return
+	}
+	e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcb), math.Float64bits(f))
+}
+
+func (e *msgpackEncoder) appendUint(u uint64) {
+	if u <= math.MaxInt64 {
+		e.appendInt(int64(u))
+		return
+	}
+	e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcf), u)
+}
+
+// appendInt writes n in the smallest integer format that holds it.
+func (e *msgpackEncoder) appendInt(n int64) {
+	b := e.buf
+	switch {
+	case n >= 0 && n < 128:
+		b = append(b, byte(n))
+	case n >= -32 && n < 0:
+		b = append(b, byte(n))
+	case n >= 0 && n <= math.MaxUint8:
+		b = append(b, 0xcc, byte(n))
+	case n >= 0 && n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(n))
+	case n >= 0 && n <= math.MaxUint32:
+		b = binary.BigEndian.AppendUint32(append(b, 0xce), uint32(n))
+	case n >= 0:
+		b = binary.BigEndian.AppendUint64(append(b, 0xcf), uint64(n))
+	case n >= math.MinInt8:
+		b = append(b, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		b = binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(n))
+	default:
+		b = binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(n))
+	}
+	e.buf = b
+}
+
+// appendLength writes a string, array, or map header. fix is the
+// fixed-size format for lengths below fixMax; f8 (0 if unsupported), f16,
+// and f32 are the 8, 16, and 32-bit length formats.
+func (e *msgpackEncoder) appendLength(n int, fix byte, fixMax int, f8, f16, f32 byte) {
+	switch {
+	case n < fixMax:
+		e.buf = append(e.buf, fix|byte(n))
+	case f8 != 0 && n <= math.MaxUint8:
+		e.buf = append(e.buf, f8, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, f16), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, f32), uint32(n))
+	}
+}
+
+// marshalers records which marshaling interfaces a type implements.
+type marshalers uint8
+
+const (
+	jsonMarshaler     marshalers = 1 << iota // The type implements json.Marshaler
+	jsonMarshalerAddr                        // Its pointer type does
+	textMarshaler                            // The type implements encoding.TextMarshaler
+	textMarshalerAddr                        // Its pointer type does
+)
+
+var marshalerCache sync.Map // reflect.Type -> marshalers
+
+// cachedMarshalers returns the marshaling interfaces t implements.
+func cachedMarshalers(t reflect.Type) marshalers {
+	if m, ok := marshalerCache.Load(t); ok {
+		return m.(marshalers)
+	}
+	var m marshalers
+	if t.Implements(jsonMarshalerType) {
+		m |= jsonMarshaler
+	} else if t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(jsonMarshalerType) {
+		m |= jsonMarshalerAddr
+	}
+	if t.Implements(textMarshalerType) {
+		m |= textMarshaler
+	} else if t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(textMarshalerType) {
+		m |= textMarshalerAddr
+	}
+	marshalerCache.Store(t, m)
+	return m
+}
+
+// structField is a struct field as encoding/json sees it.
+type structField struct {
+	name      string
+	index     []int // Path through embedded structs
+	typ       reflect.Type
+	tagged    bool // Named by its tag
+	omitEmpty bool
+	omitZero  bool
+	quoted    bool // The ",string" option on a scalar field
+}
+
+// structFields lists the fields of a struct type in encoding order.
+type structFields struct {
+	list   []structField
+	byName map[string]int // Index in list by exact name
+}
+
+var fieldCache sync.Map // reflect.Type -> *structFields
+
+// cachedFields returns the fields of struct type t, computing them once.
+func cachedFields(t reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.(*structFields)
+}
+
+// typeFields returns the fields encoding/json would encode for t: exported
+// fields named by their json tag or Go name, with the fields of untagged
+// embedded structs promoted by Go's visibility rules.
+func typeFields(t reflect.Type) *structFields {
+	type level struct {
+		typ   reflect.Type
+		index []int
+	}
+	var fields []structField
+	current, next := []level{}, []level{{typ: t}}
+	count, nextCount := map[reflect.Type]int{}, map[reflect.Type]int{}
+	visited := map[reflect.Type]bool{}
+
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, l := range current {
+			if visited[l.typ] {
+				continue
+			}
+			visited[l.typ] = true
+
+			for i := 0; i < l.typ.NumField(); i++ {
+				sf := l.typ.Field(i)
+				if sf.Anonymous {
+					ft := sf.Type
+					if ft.Kind() == reflect.Pointer {
+						ft = ft.Elem()
+					}
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				index := append(slices.Clone(l.index), i)
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+
+				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+					f := structField{
+						name:      name,
+						index:     index,
+						typ:       ft,
+						tagged:    name != "",
+						omitEmpty: hasOption(opts, "omitempty"),
+						omitZero:  hasOption(opts, "omitzero"),
+					}
+					if f.name == "" {
+						f.name = sf.Name
+					}
+					if hasOption(opts, "string") {
+						switch ft.Kind() {
+						case reflect.Bool,
+							reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+							reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+							reflect.Float32, reflect.Float64, reflect.String:
+							f.quoted = true
+						}
+					}
+					fields = append(fields, f)
+					if count[l.typ] > 1 {
+						// The same embedded type twice at one depth hides its fields
+						fields = append(fields, f)
+					}
+					continue
+				}
+
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					next = append(next, level{typ: ft, index: index})
+				}
+			}
+		}
+	}
+
+	// Of fields sharing a name, the shallowest wins, then a tagged one;
+	// any other tie hides them all
+	slices.SortStableFunc(fields, func(a, b structField) int {
+		if c := strings.Compare(a.name, b.name); c != 0 {
+			return c
+		}
+		if len(a.index) != len(b.index) {
+			return len(a.index) - len(b.index)
+		}
+		if a.tagged != b.tagged {
+			if a.tagged {
+				return -1
+			}
+			return 1
+		}
+		return slices.Compare(a.index, b.index)
+	})
+	kept := fields[:0]
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].name == fields[i].name {
+			j++
+		}
+		group := fields[i:j]
+		if len(group) == 1 || len(group[1].index) > len(group[0].index) || (group[0].tagged && !group[1].tagged) {
+			kept = append(kept, group[0])
+		}
+		i = j
+	}
+	slices.SortFunc(kept, func(a, b structField) int { return slices.Compare(a.index, b.index) })
+
+	sf := &structFields{list: kept, byName: make(map[string]int, len(kept))}
+	for i, f := range kept {
+		sf.byName[f.name] = i
+	}
+	return sf
+}
+
+// hasOption reports whether the comma-separated tag options include name.
+func hasOption(opts, name string) bool {
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
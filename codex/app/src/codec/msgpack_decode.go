@@ -0,0 +1,692 @@
+package codec
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// msgpackDecoder decodes MessagePack into Go values following the rules
+// of encoding/json.
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+// Kinds of MessagePack values, as told by their format byte.
+const (
+	mpNil = iota
+	mpBool
+	mpInt   // Signed or unsigned integer
+	mpFloat // 32 or 64-bit float
+	mpString
+	mpBinary
+	mpArray
+	mpMap
+)
+
+// mpValue is the header of a MessagePack value: its kind, and its scalar
+// value or length.
+type mpValue struct {
+	kind     int
+	b        bool
+	i        int64
+	u        uint64 // Set instead of i for integers above math.MaxInt64
+	unsigned bool
+	f        float64
+	n        int // Length of a string, binary, array, or map
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, fmt.Errorf("msgpack: unexpected end of data")
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// uint reads an n-byte big-endian unsigned integer.
+func (d *msgpackDecoder) uint(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+// header reads the format of the next value and its scalar value or
+// length. The contents of strings, binaries, arrays, and maps follow.
+func (d *msgpackDecoder) header() (mpValue, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return mpValue{}, err
+	}
+	c := b[0]
+
+	switch {
+	case c <= 0x7f:
+		return mpValue{kind: mpInt, i: int64(c)}, nil
+	case c >= 0xe0:
+		return mpValue{kind: mpInt, i: int64(int8(c))}, nil
+	case c&0xf0 == 0x80:
+		return d.length(mpMap, int(c&0x0f), 2)
+	case c&0xf0 == 0x90:
+		return d.length(mpArray, int(c&0x0f), 1)
+	case c&0xe0 == 0xa0:
+		return d.length(mpString, int(c&0x1f), 0)
+	}
+
+	var u uint64
+	switch c {
+	case 0xc0:
+		return mpValue{kind: mpNil}, nil
+	case 0xc2, 0xc3:
+		return mpValue{kind: mpBool, b: c == 0xc3}, nil
+	case 0xc4, 0xc5, 0xc6:
+		if u, err = d.uint(1 << (c - 0xc4)); err != nil {
+			return mpValue{}, err
+		}
+		return d.length(mpBinary, int(u), 0)
+	case 0xca:
+		u, err = d.uint(4)
+		return mpValue{kind: mpFloat, f: float64(math.Float32frombits(uint32(u)))}, err
+	case 0xcb:
+		u, err = d.uint(8)
+		return mpValue{kind: mpFloat, f: math.Float64frombits(u)}, err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err = d.uint(1 << (c - 0xcc))
+		if u > math.MaxInt64 {
+			return mpValue{kind: mpInt, u: u, unsigned: true}, err
+		}
+		return mpValue{kind: mpInt, i: int64(u)}, err
+	case 0xd0:
+		u, err = d.uint(1)
+		return mpValue{kind: mpInt, i: int64(int8(u))}, err
+	case 0xd1:
+		u, err = d.uint(2)
+		return mpValue{kind: mpInt, i: int64(int16(u))}, err
+	case 0xd2:
+		u, err = d.uint(4)
+		return mpValue{kind: mpInt, i: int64(int32(u))}, err
+	case 0xd3:
+		u, err = d.uint(8)
+		return mpValue{kind: mpInt, i: int64(u)}, err
+	case 0xd9, 0xda, 0xdb:
+		if u, err = d.uint(1 << (c - 0xd9)); err != nil {
+			return mpValue{}, err
+		}
+		return d.length(mpString, int(u), 0)
+	case 0xdc, 0xdd:
+		if u, err = d.uint(2 << (c - 0xdc)); err != nil {
+			return mpValue{}, err
+		}
+		return d.length(mpArray, int(u), 1)
+	case 0xde, 0xdf:
+		if u, err = d.uint(2 << (c - 0xde)); err != nil {
+			return mpValue{}, err
+		}
+		return d.length(mpMap, int(u), 2)
+	}
+	return mpValue{}, fmt.Errorf("msgpack: unsupported format byte 0x%02x", c)
+}
+
+// length returns the header of a value of n items, each taking at least
+// size bytes (1 byte for size 0), failing if the data cannot hold them.
+func (d *msgpackDecoder) length(kind, n, size int) (mpValue, error) {
+	if n < 0 || n > (len(d.data)-d.pos)/max(size, 1) {
+		return mpValue{}, fmt.Errorf("msgpack: unexpected end of data")
+	}
+	return mpValue{kind: kind, n: n}, nil
+}
+
+// number returns an integer or float header as float64, the type
+// encoding/json decodes numbers to.
+func (h mpValue) number() float64 {
+	switch {
+	case h.kind == mpFloat:
+		return h.f
+	case h.unsigned:
+		return float64(h.u)
+	}
+	return float64(h.i)
+}
+
+// String describes the value in errors.
+func (h mpValue) String() string {
+	switch h.kind {
+	case mpNil:
+		return "null"
+	case mpBool:
+		return "bool"
+	case mpInt, mpFloat:
+		return "number"
+	case mpString, mpBinary:
+		return "string"
+	case mpArray:
+		return "array"
+	}
+	return "object"
+}
+
+// decode decodes the next value into the form encoding/json gives an
+// interface{}: maps, slices, float64, string, bool, and nil. With exact
+// set, integers are int64 or uint64 and binary data is []byte instead, so
+// the value marshals back to JSON without losing precision.
+func (d *msgpackDecoder) decode(exact bool, depth int) (interface{}, error) {
+	if depth > maxMsgpackDepth {
+		return nil, fmt.Errorf("msgpack: value nested too deeply")
+	}
+	h, err := d.header()
+	if err != nil {
+		return nil, err
+	}
+	switch h.kind {
+	case mpNil:
+		return nil, nil
+	case mpBool:
+		return h.b, nil
+	case mpInt:
+		if !exact {
+			return h.number(), nil
+		}
+		if h.unsigned {
+			return h.u, nil
+		}
+		return h.i, nil
+	case mpFloat:
+		return h.f, nil
+	case mpString:
+		b, err := d.next(h.n)
+		return string(b), err
+	case mpBinary:
+		b, err := d.next(h.n)
+		if err != nil {
+			return nil, err
+		}
+		if exact {
+			return append([]byte{}, b...), nil
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+	case mpArray:
+		arr := make([]interface{}, h.n)
+		for i := range arr {
+			if arr[i], err = d.decode(exact, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	m := make(map[string]interface{}, h.n)
+	for i := 0; i < h.n; i++ {
+		k, err := d.decode(exact, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode(exact, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := k.(string); ok {
+			m[s] = v
+		} else {
+			m[fmt.Sprint(k)] = v
+		}
+	}
+	return m, nil
+}
+
+// mapKey reads a map key, which encoding/json would have written as a string.
+func (d *msgpackDecoder) mapKey() (string, error) {
+	h, err := d.header()
+	if err != nil {
+		return "", err
+	}
+	switch h.kind {
+	case mpString:
+		b, err := d.next(h.n)
+		return string(b), err
+	case mpInt, mpFloat:
+		return strconv.FormatFloat(h.number(), 'g', -1, 64), nil
+	}
+	return "", fmt.Errorf("msgpack: unsupported map key of type %s", h)
+}
+
+// decodeInto decodes the next value into v, following encoding/json:
+// pointers are allocated as needed, Unmarshaler and TextUnmarshaler
+// implementations are used, and null leaves non-nullable values unchanged.
+func (d *msgpackDecoder) decodeInto(v reflect.Value, depth int) error {
+	if depth > maxMsgpackDepth {
+		return fmt.Errorf("msgpack: value nested too deeply")
+	}
+	if d.pos >= len(d.data) {
+		return fmt.Errorf("msgpack: unexpected end of data")
+	}
+
+	isNil := d.data[d.pos] == 0xc0
+	u, tu, v := indirect(v, isNil)
+	if u != nil {
+		generic, err := d.decode(true, depth)
+		if err != nil {
+			return err
+		}
+		js, err := json.Marshal(generic)
+		if err != nil {
+			return err
+		}
+		return u.UnmarshalJSON(js)
+	}
+
+	start := d.pos
+	h, err := d.header()
+	if err != nil {
+		return err
+	}
+	if tu != nil {
+		if h.kind != mpString {
+			return d.typeError(h, reflect.TypeOf(tu))
+		}
+		b, err := d.next(h.n)
+		if err != nil {
+			return err
+		}
+		return tu.UnmarshalText(b)
+	}
+
+	switch h.kind {
+	case mpNil:
+		switch v.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Map, reflect.Slice:
+			v.SetZero()
+		}
+		return nil
+
+	case mpBool:
+		switch {
+		case v.Kind() == reflect.Bool:
+			v.SetBool(h.b)
+		case isEmptyInterface(v):
+			v.Set(reflect.ValueOf(h.b))
+		default:
+			return d.typeError(h, v.Type())
+		}
+		return nil
+
+	case mpInt, mpFloat:
+		return d.storeNumber(h, v)
+
+	case mpString, mpBinary:
+		b, err := d.next(h.n)
+		if err != nil {
+			return err
+		}
+		return d.storeString(h, b, v)
+
+	case mpArray:
+		if isEmptyInterface(v) {
+			d.pos = start
+			generic, err := d.decode(false, depth)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(generic))
+			return nil
+		}
+		return d.decodeArray(h.n, v, depth)
+	}
+
+	switch {
+	case isEmptyInterface(v):
+		d.pos = start
+		generic, err := d.decode(false, depth)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(generic))
+		return nil
+	case v.Kind() == reflect.Struct:
+		return d.decodeStruct(h.n, v, depth)
+	case v.Kind() == reflect.Map:
+		return d.decodeMap(h.n, v, depth)
+	}
+	return d.typeError(h, v.Type())
+}
+
+// typeError reports a value that cannot be stored in a Go value of type t.
+func (d *msgpackDecoder) typeError(h mpValue, t reflect.Type) error {
+	return &json.UnmarshalTypeError{Value: h.String(), Type: t, Offset: int64(d.pos)}
+}
+
+// isEmptyInterface reports whether v is an interface{} that takes any value.
+func isEmptyInterface(v reflect.Value) bool {
+	return v.Kind() == reflect.Interface && v.NumMethod() == 0
+}
+
+// storeNumber stores an integer or float in v.
+func (d *msgpackDecoder) storeNumber(h mpValue, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := h.i
+		if h.kind == mpFloat {
+			if h.f != math.Trunc(h.f) || h.f < math.MinInt64 || h.f >= math.MaxInt64 {
+				return d.typeError(h, v.Type())
+			}
+			n = int64(h.f)
+		}
+		if h.unsigned || v.OverflowInt(n) {
+			return d.typeError(h, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := h.u
+		switch {
+		case h.kind == mpFloat:
+			if h.f != math.Trunc(h.f) || h.f < 0 || h.f >= math.MaxUint64 {
+				return d.typeError(h, v.Type())
+			}
+			n = uint64(h.f)
+		case !h.unsigned:
+			if h.i < 0 {
+				return d.typeError(h, v.Type())
+			}
+			n = uint64(h.i)
+		}
+		if v.OverflowUint(n) {
+			return d.typeError(h, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f := h.number()
+		if v.OverflowFloat(f) {
+			return d.typeError(h, v.Type())
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		if v.Type() != jsonNumberType {
+			return d.typeError(h, v.Type())
+		}
+		switch {
+		case h.kind == mpFloat:
+			v.SetString(strconv.FormatFloat(h.f, 'g', -1, 64))
+		case h.unsigned:
+			v.SetString(strconv.FormatUint(h.u, 10))
+		default:
+			v.SetString(strconv.FormatInt(h.i, 10))
+		}
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return d.typeError(h, v.Type())
+		}
+		v.Set(reflect.ValueOf(h.number()))
+	default:
+		return d.typeError(h, v.Type())
+	}
+	return nil
+}
+
+// storeString stores a string or binary value in v. encoding/json writes
+// []byte as base64 strings, so either kind decodes into either type.
+func (d *msgpackDecoder) storeString(h mpValue, b []byte, v reflect.Value) error {
+	switch {
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		if h.kind == mpString {
+			decoded, err := base64.StdEncoding.DecodeString(string(b))
+			if err != nil {
+				return err
+			}
+			b = decoded
+		} else {
+			b = append([]byte{}, b...)
+		}
+		v.SetBytes(b)
+	case v.Kind() == reflect.String && v.Type() == jsonNumberType:
+		if h.kind != mpString || !json.Valid(b) {
+			return d.typeError(h, v.Type())
+		}
+		v.SetString(string(b))
+	case v.Kind() == reflect.String || isEmptyInterface(v):
+		s := string(b)
+		if h.kind == mpBinary {
+			s = base64.StdEncoding.EncodeToString(b)
+		}
+		if v.Kind() == reflect.String {
+			v.SetString(s)
+		} else {
+			v.Set(reflect.ValueOf(s))
+		}
+	default:
+		return d.typeError(h, v.Type())
+	}
+	return nil
+}
+
+// decodeArray decodes n items into a slice, which is reset to them, or an
+// array, whose remaining items are zeroed.
+func (d *msgpackDecoder) decodeArray(n int, v reflect.Value, depth int) error {
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() || v.Cap() < n {
+			v.Set(reflect.MakeSlice(v.Type(), n, n))
+		} else {
+			v.SetLen(n)
+		}
+		for i := 0; i < n; i++ {
+			elem := v.Index(i)
+			elem.SetZero()
+			if err := d.decodeInto(elem, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Array:
+		for i := 0; i < n; i++ {
+			if i >= v.Len() {
+				if _, err := d.decode(true, depth+1); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decodeInto(v.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+		for i := n; i < v.Len(); i++ {
+			v.Index(i).SetZero()
+		}
+		return nil
+	}
+	return d.typeError(mpValue{kind: mpArray}, v.Type())
+}
+
+// decodeStruct decodes n map entries into the fields of struct v, matching
+// names exactly or else case-insensitively. Unknown names are skipped.
+func (d *msgpackDecoder) decodeStruct(n int, v reflect.Value, depth int) error {
+	fields := cachedFields(v.Type())
+	for i := 0; i < n; i++ {
+		name, err := d.mapKey()
+		if err != nil {
+			return err
+		}
+		f := fields.lookup(name)
+		if f == nil {
+			if _, err := d.decode(true, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		fv, err := allocFieldByIndex(v, f.index)
+		if err != nil {
+			return err
+		}
+		if f.quoted && d.pos < len(d.data) && d.data[d.pos] != 0xc0 {
+			h, err := d.header()
+			if err != nil {
+				return err
+			}
+			b, err := d.next(h.n)
+			if err != nil {
+				return err
+			}
+			if h.kind != mpString {
+				return d.typeError(h, fv.Type())
+			}
+			if err := json.Unmarshal(b, fv.Addr().Interface()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := d.decodeInto(fv, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookup returns the field named name, matched exactly or else
+// case-insensitively, or nil.
+func (fs *structFields) lookup(name string) *structField {
+	if i, ok := fs.byName[name]; ok {
+		return &fs.list[i]
+	}
+	for i := range fs.list {
+		if strings.EqualFold(fs.list[i].name, name) {
+			return &fs.list[i]
+		}
+	}
+	return nil
+}
+
+// allocFieldByIndex returns the field of struct v at index, allocating
+// nil embedded pointers on the way.
+func allocFieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("msgpack: cannot set embedded pointer to unexported struct %s", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+// decodeMap decodes n entries into map v, allocating it if nil. Keys are
+// converted from strings as encoding/json does.
+func (d *msgpackDecoder) decodeMap(n int, v reflect.Value, depth int) error {
+	t := v.Type()
+	kt := t.Key()
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(t, n))
+	}
+	for i := 0; i < n; i++ {
+		name, err := d.mapKey()
+		if err != nil {
+			return err
+		}
+
+		var key reflect.Value
+		switch {
+		case reflect.PointerTo(kt).Implements(textUnmarshalerType):
+			key = reflect.New(kt)
+			if err := key.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(name)); err != nil {
+				return err
+			}
+			key = key.Elem()
+		case kt.Kind() == reflect.String:
+			key = reflect.ValueOf(name).Convert(kt)
+		case kt.Kind() >= reflect.Int && kt.Kind() <= reflect.Int64:
+			n, err := strconv.ParseInt(name, 10, 64)
+			if err != nil || reflect.Zero(kt).OverflowInt(n) {
+				return &json.UnmarshalTypeError{Value: "number " + name, Type: kt, Offset: int64(d.pos)}
+			}
+			key = reflect.New(kt).Elem()
+			key.SetInt(n)
+		case kt.Kind() >= reflect.Uint && kt.Kind() <= reflect.Uintptr:
+			n, err := strconv.ParseUint(name, 10, 64)
+			if err != nil || reflect.Zero(kt).OverflowUint(n) {
+				return &json.UnmarshalTypeError{Value: "number " + name, Type: kt, Offset: int64(d.pos)}
+			}
+			key = reflect.New(kt).Elem()
+			key.SetUint(n)
+		default:
+			return fmt.Errorf("msgpack: unsupported map key type %s", kt)
+		}
+
+		elem := reflect.New(t.Elem()).Elem()
+		if err := d.decodeInto(elem, depth+1); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+	}
+	return nil
+}
+
+// indirect walks down v, allocating pointers as needed, until it reaches
+// a non-pointer. It stops early at a json.Unmarshaler or, unless decoding
+// null, an encoding.TextUnmarshaler. Decoding null stops at the last
+// settable pointer, so it can be set to nil.
+func indirect(v reflect.Value, decodingNull bool) (json.Unmarshaler, encoding.TextUnmarshaler, reflect.Value) {
+	// Start from the address of a named value, so methods with pointer
+	// receivers are found
+	v0 := v
+	haveAddr := false
+	if v.Kind() != reflect.Pointer && v.Type().Name() != "" && v.CanAddr() {
+		haveAddr = true
+		v = v.Addr()
+	}
+	for {
+		// Decode into the pointer an interface already holds
+		if v.Kind() == reflect.Interface && !v.IsNil() {
+			e := v.Elem()
+			if e.Kind() == reflect.Pointer && !e.IsNil() && (!decodingNull || e.Elem().Kind() == reflect.Pointer) {
+				haveAddr = false
+				v = e
+				continue
+			}
+		}
+		if v.Kind() != reflect.Pointer {
+			break
+		}
+		if decodingNull && v.CanSet() {
+			break
+		}
+		// A pointer to an interface holding the pointer itself would loop
+		if v.Elem().Kind() == reflect.Interface && v.Elem().Elem().Equal(v) {
+			v = v.Elem()
+			break
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		if v.Type().NumMethod() > 0 && v.CanInterface() {
+			if u, ok := v.Interface().(json.Unmarshaler); ok {
+				return u, nil, reflect.Value{}
+			}
+			if !decodingNull {
+				if u, ok := v.Interface().(encoding.TextUnmarshaler); ok {
+					return nil, u, reflect.Value{}
+				}
+			}
+		}
+		if haveAddr {
+			v = v0
+			haveAddr = false
+		} else {
+			v = v.Elem()
+		}
+	}
+	return nil, nil, v
+}
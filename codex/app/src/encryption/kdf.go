@@ -0,0 +1,71 @@
+package encryption
+
+import (
+	"cmp"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Key derivation functions for passphrases.
+const (
+	Argon2id = "argon2id" // The default
+	Scrypt   = "scrypt"
+)
+
+// KDFParams tunes the derivation of a key from a passphrase. Zero fields
+// take the defaults: Argon2id with 3 passes over 64 MiB in 4 threads, or
+// scrypt with N=32768, r=8, p=1.
+type KDFParams struct {
+	Algorithm string `json:"algorithm"`
+
+	// Argon2id only
+	Time    uint32 `json:"time,omitempty"`    // Passes over the memory
+	Memory  uint32 `json:"memory,omitempty"`  // Memory in KiB
+	Threads uint8  `json:"threads,omitempty"` // Degree of parallelism
+
+	// scrypt only
+	N int `json:"n,omitempty"` // CPU and memory cost, a power of two
+	R int `json:"r,omitempty"` // Block size
+	P int `json:"p,omitempty"` // Parallelism
+}
+
+// WithDefaults returns p with its zero fields set to the defaults and the
+// fields of the other algorithm cleared.
+func (p KDFParams) WithDefaults() KDFParams {
+	switch p.Algorithm {
+	case "", Argon2id:
+		return KDFParams{
+			Algorithm: Argon2id,
+			Time:      cmp.Or(p.Time, 3),
+			Memory:    cmp.Or(p.Memory, 64*1024),
+			Threads:   cmp.Or(p.Threads, 4),
+		}
+	case Scrypt:
+		return KDFParams{
+			Algorithm: Scrypt,
+			N:         cmp.Or(p.N, 32768),
+			R:         cmp.Or(p.R, 8),
+			P:         cmp.Or(p.P, 1),
+		}
+	}
+	return p
+}
+
+// DeriveKey derives a 256-bit key from passphrase and salt with the
+// function and parameters in p, applying the defaults.
+func DeriveKey(passphrase, salt []byte, p KDFParams) ([]byte, error) {
+	p = p.WithDefaults()
+	switch p.Algorithm {
+	case Argon2id:
+		return argon2.IDKey(passphrase, salt, p.Time, p.Memory, p.Threads, 32), nil
+	case Scrypt:
+		key, err := scrypt.Key(passphrase, salt, p.N, p.R, p.P, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scrypt parameters: %w", err)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key derivation function %q", p.Algorithm)
+}
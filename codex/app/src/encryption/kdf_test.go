@@ -0,0 +1,59 @@
+package encryption
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeriveKey(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+	salt := []byte("0123456789abcdef")
+
+	params := map[string]KDFParams{
+		"argon2id": {Algorithm: Argon2id, Time: 1, Memory: 1024, Threads: 1},
+		"scrypt":   {Algorithm: Scrypt, N: 1024},
+	}
+	for name, p := range params {
+		t.Run(name, func(t *testing.T) {
+			key, err := DeriveKey(passphrase, salt, p)
+			if err != nil {
+				t.Fatalf("DeriveKey() failed: %v", err)
+			}
+			if len(key) != 32 {
+				t.Fatalf("expected a 32-byte key, got %d bytes", len(key))
+			}
+
+			again, _ := DeriveKey(passphrase, salt, p)
+			if !bytes.Equal(key, again) {
+				t.Error("expected the same key for the same passphrase and salt")
+			}
+			other, _ := DeriveKey(passphrase, []byte("fedcba9876543210"), p)
+			if bytes.Equal(key, other) {
+				t.Error("expected a different key for a different salt")
+			}
+			other, _ = DeriveKey([]byte("wrong"), salt, p)
+			if bytes.Equal(key, other) {
+				t.Error("expected a different key for a different passphrase")
+			}
+		})
+	}
+}
+
+func TestKDFParamsWithDefaults(t *testing.T) {
+	p := KDFParams{Memory: 1024, N: 1024}.WithDefaults()
+	if p != (KDFParams{Algorithm: Argon2id, Time: 3, Memory: 1024, Threads: 4}) {
+		t.Errorf("unexpected Argon2id defaults: %+v", p)
+	}
+	p = KDFParams{Algorithm: Scrypt, Time: 1}.WithDefaults()
+	if p != (KDFParams{Algorithm: Scrypt, N: 32768, R: 8, P: 1}) {
+		t.Errorf("unexpected scrypt defaults: %+v", p)
+	}
+}
+
+func TestDeriveKeyInvalidParams(t *testing.T) {
+	for _, p := range []KDFParams{{Algorithm: "pbkdf2"}, {Algorithm: Scrypt, N: 1000}} {
+		if _, err := DeriveKey([]byte("passphrase"), []byte("salt"), p); err == nil {
+			t.Errorf("expected an error for %+v", p)
+		}
+	}
+}
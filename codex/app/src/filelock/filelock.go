@@ -3,9 +3,16 @@
 //
 // File Lock Behavior:
 //   - Uses OS-level advisory locks (flock on Unix, LockFileEx on Windows)
-//   - Locks are exclusive - only one process can hold a write lock
+//   - Exclusive locks (Lock) are held by one process at a time
+//   - Shared locks (RLock) can be held by many processes at once, but not
+//     while another process holds an exclusive lock
 //   - Locks are automatically released when the file is closed or process exits
-//   - Non-blocking mode returns error immediately if lock cannot be acquired
+//   - Lock and RLock return an error immediately if the lock cannot be acquired;
+//     LockWait and RLockWait block until it can, and LockTimeout retries
+//     until a deadline
+//   - The holder of an exclusive lock can record itself in the lock file
+//     (WriteHolder), so others can name it (ReadHolder) and tell whether
+//     it is still running (Holder.Stale)
 //
 // Usage:
 //
@@ -27,12 +34,51 @@ package filelock
 import (
 	"fmt"
 	"os"
+	"time"
 )
 
 // Lock acquires an exclusive lock on the file.
 // Returns an error if the lock cannot be acquired (e.g., already locked by another process).
 func Lock(file *os.File) error {
-	return lock(file)
+	return lock(file, true, false)
+}
+
+// LockTimeout acquires an exclusive lock on the file, retrying until timeout
+// has elapsed. Returns ErrLocked if the lock is still held by then. A
+// timeout of zero or less tries once, like Lock.
+func LockTimeout(file *os.File, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	delay := 10 * time.Millisecond
+	for {
+		err := Lock(file)
+		if err != ErrLocked {
+			return err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return err
+		}
+		time.Sleep(min(delay, remaining))
+		delay = min(delay*2, 250*time.Millisecond)
+	}
+}
+
+// RLock acquires a shared lock on the file.
+// Returns ErrLocked if another process holds an exclusive lock.
+func RLock(file *os.File) error {
+	return lock(file, false, false)
+}
+
+// LockWait acquires an exclusive lock on the file, waiting until all other
+// locks on it are released.
+func LockWait(file *os.File) error {
+	return lock(file, true, true)
+}
+
+// RLockWait acquires a shared lock on the file, waiting until no other
+// process holds an exclusive lock on it.
+func RLockWait(file *os.File) error {
+	return lock(file, false, true)
 }
 
 // Unlock releases the lock on the file.
@@ -1,9 +1,13 @@
 package filelock
 
 import (
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLockAndUnlock(t *testing.T) {
@@ -165,3 +169,160 @@ func TestErrLockedValue(t *testing.T) {
 		}
 	})
 }
+
+func TestSharedLock(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "test.lock")
+	open := func() *os.File {
+		file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			t.Fatalf("failed to open test file: %v", err)
+		}
+		t.Cleanup(func() { file.Close() })
+		return file
+	}
+
+	// Each open file holds its own lock, as a separate process would
+	reader1, reader2, writer := open(), open(), open()
+
+	t.Run("many shared locks coexist", func(t *testing.T) {
+		if err := RLock(reader1); err != nil {
+			t.Fatalf("first RLock() failed: %v", err)
+		}
+		if err := RLock(reader2); err != nil {
+			t.Fatalf("second RLock() failed: %v", err)
+		}
+	})
+
+	t.Run("exclusive lock fails while shared locks are held", func(t *testing.T) {
+		if err := Lock(writer); err != ErrLocked {
+			t.Fatalf("expected ErrLocked, got %v", err)
+		}
+	})
+
+	t.Run("exclusive lock waits for shared locks", func(t *testing.T) {
+		acquired := make(chan error, 1)
+		go func() { acquired <- LockWait(writer) }()
+
+		Unlock(reader1)
+		select {
+		case err := <-acquired:
+			t.Fatalf("LockWait() returned while a shared lock was held: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		Unlock(reader2)
+		select {
+		case err := <-acquired:
+			if err != nil {
+				t.Fatalf("LockWait() failed: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("LockWait() did not return after shared locks were released")
+		}
+	})
+
+	t.Run("shared lock fails while exclusive lock is held", func(t *testing.T) {
+		if err := RLock(reader1); err != ErrLocked {
+			t.Fatalf("expected ErrLocked, got %v", err)
+		}
+
+		acquired := make(chan error, 1)
+		go func() { acquired <- RLockWait(reader1) }()
+		Unlock(writer)
+		select {
+		case err := <-acquired:
+			if err != nil {
+				t.Fatalf("RLockWait() failed: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("RLockWait() did not return after exclusive lock was released")
+		}
+	})
+}
+
+func TestLockTimeout(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "test.lock")
+	holder, _ := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0600)
+	defer holder.Close()
+	waiter, _ := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0600)
+	defer waiter.Close()
+
+	if err := Lock(holder); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+
+	t.Run("gives up at the deadline", func(t *testing.T) {
+		start := time.Now()
+		if err := LockTimeout(waiter, 100*time.Millisecond); err != ErrLocked {
+			t.Fatalf("expected ErrLocked, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("LockTimeout() returned after %v, before its timeout", elapsed)
+		}
+	})
+
+	t.Run("acquires the lock once released", func(t *testing.T) {
+		released := make(chan struct{})
+		go func() {
+			defer close(released)
+			time.Sleep(100 * time.Millisecond)
+			Unlock(holder)
+		}()
+		err := LockTimeout(waiter, 5*time.Second)
+		<-released
+		if err != nil {
+			t.Fatalf("LockTimeout() failed: %v", err)
+		}
+	})
+}
+
+func TestHolder(t *testing.T) {
+	file, err := os.OpenFile(filepath.Join(t.TempDir(), "test.lock"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	defer file.Close()
+
+	if h := ReadHolder(file); h != nil {
+		t.Errorf("expected no holder in an empty file, got %v", h)
+	}
+
+	// Records replace each other rather than accumulate
+	for i := 0; i < 2; i++ {
+		if err := WriteHolder(file); err != nil {
+			t.Fatalf("WriteHolder() failed: %v", err)
+		}
+	}
+	h := ReadHolder(file)
+	if h == nil || h.PID != os.Getpid() {
+		t.Fatalf("expected holder with pid %d, got %v", os.Getpid(), h)
+	}
+	if h.Stale() {
+		t.Error("expected the current process not to be stale")
+	}
+	if !strings.Contains(h.String(), fmt.Sprintf("pid %d", os.Getpid())) {
+		t.Errorf("unexpected holder description %q", h.String())
+	}
+
+	// A process that has exited leaves a stale record
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to run child process: %v", err)
+	}
+	dead := *h
+	dead.PID = cmd.Process.Pid
+	if !dead.Stale() || !strings.Contains(dead.String(), "stale") {
+		t.Errorf("expected exited process to be stale: %s", dead.String())
+	}
+	dead.Hostname = "elsewhere.invalid"
+	if dead.Stale() {
+		t.Error("expected holders on other hosts never to be stale")
+	}
+
+	if err := ClearHolder(file); err != nil {
+		t.Fatalf("ClearHolder() failed: %v", err)
+	}
+	if h := ReadHolder(file); h != nil {
+		t.Errorf("expected no holder after ClearHolder, got %v", h)
+	}
+}
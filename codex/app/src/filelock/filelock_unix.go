@@ -9,10 +9,20 @@ import (
 )
 
 // lock implements file locking for Unix-like systems using flock(2)
-func lock(file *os.File) error {
-	// LOCK_EX: exclusive lock
+func lock(file *os.File, exclusive, wait bool) error {
+	// LOCK_EX: exclusive lock, LOCK_SH: shared lock
 	// LOCK_NB: non-blocking mode (return error immediately if already locked)
-	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	err := syscall.Flock(int(file.Fd()), how)
+	for err == syscall.EINTR {
+		err = syscall.Flock(int(file.Fd()), how)
+	}
 	if err != nil {
 		if err == syscall.EWOULDBLOCK {
 			return ErrLocked
@@ -30,3 +40,11 @@ func unlock(file *os.File) error {
 	}
 	return nil
 }
+
+// processAlive reports whether a process with the given PID exists.
+func processAlive(pid int) bool {
+	// Signal 0 checks for existence without sending anything; EPERM means
+	// the process exists but belongs to another user
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
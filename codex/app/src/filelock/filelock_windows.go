@@ -16,6 +16,14 @@ var (
 )
 
 const (
+	// lockOffsetHigh places the locked byte far beyond the end of the file.
+	// Windows locks are mandatory, so locking the file contents would stop
+	// other processes from reading the lock holder record.
+	lockOffsetHigh = 0x40000000
+
+	// PROCESS_QUERY_LIMITED_INFORMATION: enough access to check a process exists
+	processQueryLimitedInformation = 0x00001000
+
 	// LOCKFILE_EXCLUSIVE_LOCK: exclusive lock
 	lockfileExclusiveLock = 0x00000002
 	// LOCKFILE_FAIL_IMMEDIATELY: non-blocking mode
@@ -23,16 +31,24 @@ const (
 )
 
 // lock implements file locking for Windows using LockFileEx
-func lock(file *os.File) error {
-	// Lock the entire file (from byte 0 to max)
-	var overlapped syscall.Overlapped
+func lock(file *os.File, exclusive, wait bool) error {
+	// Lock a single byte past any real file contents
+	overlapped := syscall.Overlapped{OffsetHigh: lockOffsetHigh}
+
+	var flags uintptr
+	if exclusive {
+		flags |= lockfileExclusiveLock
+	}
+	if !wait {
+		flags |= lockfileFailImmediately
+	}
 
 	r1, _, err := procLockFileEx.Call(
 		uintptr(file.Fd()),
-		uintptr(lockfileExclusiveLock|lockfileFailImmediately),
-		uintptr(0),          // reserved, must be 0
-		uintptr(0xFFFFFFFF), // lock low 32 bits (entire file)
-		uintptr(0xFFFFFFFF), // lock high 32 bits (entire file)
+		flags,
+		uintptr(0), // reserved, must be 0
+		uintptr(1), // lock length low 32 bits
+		uintptr(0), // lock length high 32 bits
 		uintptr(unsafe.Pointer(&overlapped)),
 	)
 
@@ -48,13 +64,13 @@ func lock(file *os.File) error {
 
 // unlock releases the file lock
 func unlock(file *os.File) error {
-	var overlapped syscall.Overlapped
+	overlapped := syscall.Overlapped{OffsetHigh: lockOffsetHigh}
 
 	r1, _, err := procUnlockFileEx.Call(
 		uintptr(file.Fd()),
-		uintptr(0),          // reserved, must be 0
-		uintptr(0xFFFFFFFF), // unlock low 32 bits
-		uintptr(0xFFFFFFFF), // unlock high 32 bits
+		uintptr(0), // reserved, must be 0
+		uintptr(1), // unlock length low 32 bits
+		uintptr(0), // unlock length high 32 bits
 		uintptr(unsafe.Pointer(&overlapped)),
 	)
 
@@ -64,3 +80,14 @@ func unlock(file *os.File) error {
 
 	return nil
 }
+
+// processAlive reports whether a process with the given PID exists.
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		// Access denied means the process exists but belongs to another user
+		return err == syscall.ERROR_ACCESS_DENIED
+	}
+	syscall.CloseHandle(h)
+	return true
+}
@@ -0,0 +1,81 @@
+package filelock
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Holder identifies the process that holds an exclusive lock.
+type Holder struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Acquired time.Time `json:"acquired"`
+}
+
+// String describes the holder, noting when it is stale.
+func (h *Holder) String() string {
+	s := fmt.Sprintf("pid %d on %s since %s", h.PID, h.Hostname, h.Acquired.Format(time.RFC3339))
+	if h.Stale() {
+		s += " (stale: process is not running)"
+	}
+	return s
+}
+
+// Stale reports whether the holder is a process on this host that is no
+// longer running. Holders on other hosts are never reported stale, since
+// their processes cannot be checked.
+func (h *Holder) Stale() bool {
+	hostname, err := os.Hostname()
+	if err != nil || hostname != h.Hostname {
+		return false
+	}
+	return !processAlive(h.PID)
+}
+
+// WriteHolder records the current process as the holder of the lock on
+// file, replacing any previous record. The caller must hold the lock.
+func WriteHolder(file *os.File) error {
+	hostname, _ := os.Hostname()
+	data, err := json.Marshal(Holder{
+		PID:      os.Getpid(),
+		Hostname: hostname,
+		Acquired: time.Now().UTC().Truncate(time.Second),
+	})
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to record lock holder: %w", err)
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		return fmt.Errorf("failed to record lock holder: %w", err)
+	}
+	return nil
+}
+
+// ClearHolder removes the holder record from file before its lock is
+// released, so a record left behind means the holder exited without
+// releasing it.
+func ClearHolder(file *os.File) error {
+	return file.Truncate(0)
+}
+
+// ReadHolder returns the holder recorded in file, or nil if there is no
+// complete record.
+func ReadHolder(file *os.File) *Holder {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 || info.Size() > 4096 {
+		return nil
+	}
+	data := make([]byte, info.Size())
+	if _, err := file.ReadAt(data, 0); err != nil {
+		return nil
+	}
+	var h Holder
+	if err := json.Unmarshal(data, &h); err != nil || h.PID == 0 {
+		return nil
+	}
+	return &h
+}
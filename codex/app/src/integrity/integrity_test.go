@@ -1,6 +1,8 @@
 package integrity
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"reflect"
 	"testing"
@@ -64,3 +66,53 @@ func TestSignAndVerify(t *testing.T) {
 		}
 	})
 }
+
+func TestSignAndVerifyBinary(t *testing.T) {
+	rawData := []byte{0x00, 0xff, '{', 0x10}
+
+	signed := SignBinary(rawData)
+	if !IsBinary(signed) {
+		t.Fatal("expected signed data to be detected as binary")
+	}
+	verified, err := VerifyBinary(signed)
+	if err != nil {
+		t.Fatalf("VerifyBinary() failed: %v", err)
+	}
+	if !reflect.DeepEqual(verified, rawData) {
+		t.Errorf("mismatch: expected %v, got %v", rawData, verified)
+	}
+
+	signed[len(signed)-1] ^= 0xff
+	if _, err := VerifyBinary(signed); err == nil {
+		t.Error("expected error on corrupted data")
+	}
+	if _, err := VerifyBinary([]byte(`{"checksum":""}`)); err == nil {
+		t.Error("expected error on JSON content")
+	}
+}
+
+func TestMerkleRoot(t *testing.T) {
+	leaf := func(b byte) []byte {
+		sum := sha256.Sum256([]byte{b})
+		return sum[:]
+	}
+	hash := func(parts ...[]byte) []byte {
+		sum := sha256.Sum256(bytes.Join(parts, nil))
+		return sum[:]
+	}
+	a, b, c := leaf('a'), leaf('b'), leaf('c')
+
+	// Three leaves split as two on the left and one on the right
+	left := hash([]byte{0x01}, hash([]byte{0x00}, a), hash([]byte{0x00}, b))
+	expected := hash([]byte{0x01}, left, hash([]byte{0x00}, c))
+	if root := MerkleRoot([][]byte{a, b, c}); !bytes.Equal(root, expected) {
+		t.Errorf("unexpected root %x, expected %x", root, expected)
+	}
+
+	if bytes.Equal(MerkleRoot([][]byte{a, b, c}), MerkleRoot([][]byte{b, a, c})) {
+		t.Error("expected reordered leaves to change the root")
+	}
+	if bytes.Equal(MerkleRoot([][]byte{a, b}), MerkleRoot([][]byte{a, b, c})) {
+		t.Error("expected an extra leaf to change the root")
+	}
+}
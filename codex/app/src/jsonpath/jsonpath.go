@@ -0,0 +1,130 @@
+// Package jsonpath evaluates simple paths against JSON documents, used to
+// pick the value fields that secondary indexes and queries operate on.
+//
+// Supported Syntax:
+//   - An optional leading "$" for the document root
+//   - Object fields separated by dots: "$.address.city", "profile.email"
+//   - Array elements by position: "tags[0]", "$.items[2].sku"
+//   - "$" alone selects the whole document
+//
+// Usage:
+//
+//	p, err := jsonpath.Parse("$.address.city")
+//	city, ok := p.Lookup([]byte(`{"address":{"city":"Lisbon"}}`)) // "Lisbon", true
+//
+// Note: Values are decoded with encoding/json, so numbers are float64,
+// objects are map[string]interface{} and arrays are []interface{}.
+package jsonpath
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path is a parsed JSON path. The zero value selects the whole document.
+type Path struct {
+	expr  string
+	steps []step
+}
+
+// step selects one object field or one array element.
+type step struct {
+	field   string
+	index   int
+	isIndex bool
+}
+
+// Parse compiles a path expression.
+func Parse(expr string) (Path, error) {
+	rest := strings.TrimPrefix(strings.TrimSpace(expr), "$")
+	p := Path{expr: expr}
+
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return Path{}, fmt.Errorf("invalid path %q: empty field name", expr)
+			}
+			p.steps = append(p.steps, step{field: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return Path{}, fmt.Errorf("invalid path %q: unterminated index", expr)
+			}
+			n, err := strconv.Atoi(rest[1:end])
+			if err != nil || n < 0 {
+				return Path{}, fmt.Errorf("invalid path %q: bad array index %q", expr, rest[1:end])
+			}
+			p.steps = append(p.steps, step{index: n, isIndex: true})
+			rest = rest[end+1:]
+		default:
+			// A bare leading field name, as in "address.city"
+			if len(p.steps) > 0 {
+				return Path{}, fmt.Errorf("invalid path %q: unexpected %q", expr, rest[0])
+			}
+			rest = "." + rest
+		}
+	}
+
+	return p, nil
+}
+
+// String returns the expression the path was parsed from.
+func (p Path) String() string {
+	return p.expr
+}
+
+// Equal reports whether p and other select the same value, regardless of
+// how they were written ("email" and "$.email" are equal).
+func (p Path) Equal(other Path) bool {
+	if len(p.steps) != len(other.steps) {
+		return false
+	}
+	for i := range p.steps {
+		if p.steps[i] != other.steps[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Lookup decodes doc and returns the value the path selects.
+// It reports false if doc is not valid JSON or the path does not exist.
+func (p Path) Lookup(doc []byte) (interface{}, bool) {
+	var v interface{}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return nil, false
+	}
+	return p.Eval(v)
+}
+
+// Eval returns the value the path selects from an already decoded document.
+// It reports false if the path does not exist.
+func (p Path) Eval(v interface{}) (interface{}, bool) {
+	for _, s := range p.steps {
+		if s.isIndex {
+			arr, ok := v.([]interface{})
+			if !ok || s.index >= len(arr) {
+				return nil, false
+			}
+			v = arr[s.index]
+			continue
+		}
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[s.field]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
@@ -0,0 +1,64 @@
+package jsonpath
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	doc := []byte(`{"name":"alice","address":{"city":"Lisbon"},"tags":["a","b"],"items":[{"sku":"x1"}],"age":30}`)
+
+	testCases := []struct {
+		path     string
+		expected interface{}
+		found    bool
+	}{
+		{"$.name", "alice", true},
+		{"name", "alice", true},
+		{"address.city", "Lisbon", true},
+		{"$.tags[1]", "b", true},
+		{"$.items[0].sku", "x1", true},
+		{"$.age", float64(30), true},
+		{"$.missing", nil, false},
+		{"$.tags[5]", nil, false},
+		{"$.name.first", nil, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			p, err := Parse(tc.path)
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			got, ok := p.Lookup(doc)
+			if ok != tc.found || !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected (%v, %v), got (%v, %v)", tc.expected, tc.found, got, ok)
+			}
+		})
+	}
+
+	root, _ := Parse("$")
+	if v, ok := root.Lookup([]byte(`"scalar"`)); !ok || v != "scalar" {
+		t.Errorf("expected root path to select the document, got %v", v)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{"$..name", "tags[", "tags[-1]", "tags[x]", "a.[0]"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("expected error for %q", expr)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	a, _ := Parse("$.address.city")
+	b, _ := Parse("address.city")
+	c, _ := Parse("$.address")
+	if !a.Equal(b) {
+		t.Error("expected equivalent paths to be equal")
+	}
+	if a.Equal(c) {
+		t.Error("expected different paths not to be equal")
+	}
+}
@@ -0,0 +1,140 @@
+// Package keyindex provides a sorted key index used to serve ordered
+// listings, prefix scans, and range scans without walking a Go map.
+//
+// Index Behavior:
+//   - Keys are kept in ascending byte-wise order in a sorted slice
+//   - Lookups use binary search (O(log n))
+//   - Inserting keys in ascending order (timestamps, sequences) is O(1) amortized
+//   - Scans return copies, so callers may hold results without locking
+//
+// Usage:
+//
+//	idx := keyindex.New("user:2", "user:1")
+//	idx.Insert("user:3")
+//	keys := idx.Prefix("user:")                // [user:1 user:2 user:3]
+//	page := idx.Range("user:2", "", 10, false) // [user:2 user:3]
+//
+// Note: Index is not safe for concurrent use. The store guards it with the
+// same lock that protects its data map.
+package keyindex
+
+import (
+	"sort"
+	"strings"
+)
+
+// Index is an ordered set of keys.
+type Index struct {
+	keys []string
+}
+
+// New creates an index containing the given keys.
+func New(keys ...string) *Index {
+	idx := &Index{keys: make([]string, len(keys))}
+	copy(idx.keys, keys)
+	sort.Strings(idx.keys)
+
+	// Drop duplicates so the index behaves as a set
+	out := idx.keys[:0]
+	for i, k := range idx.keys {
+		if i == 0 || k != idx.keys[i-1] {
+			out = append(out, k)
+		}
+	}
+	idx.keys = out
+	return idx
+}
+
+// Len returns the number of keys in the index.
+func (idx *Index) Len() int {
+	return len(idx.keys)
+}
+
+// Has reports whether the key is present in the index.
+func (idx *Index) Has(key string) bool {
+	i := sort.SearchStrings(idx.keys, key)
+	return i < len(idx.keys) && idx.keys[i] == key
+}
+
+// Insert adds a key to the index. Inserting an existing key is a no-op.
+func (idx *Index) Insert(key string) {
+	n := len(idx.keys)
+	// Fast path for keys arriving in ascending order
+	if n == 0 || idx.keys[n-1] < key {
+		idx.keys = append(idx.keys, key)
+		return
+	}
+
+	i := sort.SearchStrings(idx.keys, key)
+	if idx.keys[i] == key {
+		return
+	}
+	idx.keys = append(idx.keys, "")
+	copy(idx.keys[i+1:], idx.keys[i:])
+	idx.keys[i] = key
+}
+
+// Remove deletes a key from the index. Removing a missing key is a no-op.
+func (idx *Index) Remove(key string) {
+	i := sort.SearchStrings(idx.keys, key)
+	if i >= len(idx.keys) || idx.keys[i] != key {
+		return
+	}
+	copy(idx.keys[i:], idx.keys[i+1:])
+	idx.keys[len(idx.keys)-1] = ""
+	idx.keys = idx.keys[:len(idx.keys)-1]
+}
+
+// Keys returns a copy of all keys in ascending order.
+func (idx *Index) Keys() []string {
+	keys := make([]string, len(idx.keys))
+	copy(keys, idx.keys)
+	return keys
+}
+
+// Prefix returns all keys starting with prefix in ascending order.
+// An empty prefix returns every key.
+func (idx *Index) Prefix(prefix string) []string {
+	lo := sort.SearchStrings(idx.keys, prefix)
+	hi := lo
+	for hi < len(idx.keys) && strings.HasPrefix(idx.keys[hi], prefix) {
+		hi++
+	}
+	keys := make([]string, hi-lo)
+	copy(keys, idx.keys[lo:hi])
+	return keys
+}
+
+// Range returns keys in the half-open interval [start, end).
+// An empty start means "from the first key" and an empty end means
+// "through the last key". A limit <= 0 returns every matching key.
+// When reverse is true, keys are returned in descending order and the
+// limit applies from the end of the interval.
+func (idx *Index) Range(start, end string, limit int, reverse bool) []string {
+	lo := 0
+	if start != "" {
+		lo = sort.SearchStrings(idx.keys, start)
+	}
+	hi := len(idx.keys)
+	if end != "" {
+		hi = sort.SearchStrings(idx.keys, end)
+	}
+	if hi <= lo {
+		return []string{}
+	}
+
+	n := hi - lo
+	if limit > 0 && limit < n {
+		n = limit
+	}
+
+	keys := make([]string, n)
+	if reverse {
+		for i := 0; i < n; i++ {
+			keys[i] = idx.keys[hi-1-i]
+		}
+	} else {
+		copy(keys, idx.keys[lo:lo+n])
+	}
+	return keys
+}
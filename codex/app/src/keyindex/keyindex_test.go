@@ -0,0 +1,83 @@
+package keyindex
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIndex_InsertAndRemove(t *testing.T) {
+	idx := New("b", "a", "c", "a")
+
+	if idx.Len() != 3 {
+		t.Fatalf("Expected 3 keys, got %d", idx.Len())
+	}
+
+	idx.Insert("bb")
+	idx.Insert("d")
+	idx.Insert("a") // duplicate
+
+	expected := []string{"a", "b", "bb", "c", "d"}
+	if !reflect.DeepEqual(idx.Keys(), expected) {
+		t.Errorf("Expected %v, got %v", expected, idx.Keys())
+	}
+
+	idx.Remove("bb")
+	idx.Remove("missing")
+
+	expected = []string{"a", "b", "c", "d"}
+	if !reflect.DeepEqual(idx.Keys(), expected) {
+		t.Errorf("Expected %v after remove, got %v", expected, idx.Keys())
+	}
+
+	if !idx.Has("c") || idx.Has("bb") {
+		t.Error("Has() returned wrong membership")
+	}
+}
+
+func TestIndex_Prefix(t *testing.T) {
+	idx := New("user:1", "user:2", "order:1", "user:10", "users")
+
+	got := idx.Prefix("user:")
+	expected := []string{"user:1", "user:10", "user:2"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	if len(idx.Prefix("missing:")) != 0 {
+		t.Error("Expected no keys for unknown prefix")
+	}
+
+	if len(idx.Prefix("")) != 5 {
+		t.Errorf("Expected empty prefix to return all keys, got %d", len(idx.Prefix("")))
+	}
+}
+
+func TestIndex_Range(t *testing.T) {
+	idx := New("a", "b", "c", "d", "e")
+
+	testCases := []struct {
+		name     string
+		start    string
+		end      string
+		limit    int
+		reverse  bool
+		expected []string
+	}{
+		{"full range", "", "", 0, false, []string{"a", "b", "c", "d", "e"}},
+		{"half open", "b", "d", 0, false, []string{"b", "c"}},
+		{"with limit", "b", "", 2, false, []string{"b", "c"}},
+		{"reverse", "b", "e", 0, true, []string{"d", "c", "b"}},
+		{"reverse with limit", "", "", 2, true, []string{"e", "d"}},
+		{"empty interval", "d", "b", 0, false, []string{}},
+		{"start between keys", "bb", "", 0, false, []string{"c", "d", "e"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := idx.Range(tc.start, tc.end, tc.limit, tc.reverse)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
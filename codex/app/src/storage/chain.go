@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/evertonmj/codex/codex/app/src/integrity"
+)
+
+// Every ledger entry records the hash of the entry before it, so entries
+// cannot be dropped, reordered, or rewritten without breaking a link. The
+// hash of an entry is the SHA256 of its body, before compression and
+// encryption, and therefore covers the whole chain up to it. With
+// Options.MerkleInterval set, a meta entry holding the Merkle root of the
+// entries since the previous root is written every so many entries, which
+// can be recorded elsewhere to vouch for a prefix of the ledger.
+//
+// A new file starts a new chain: compactions and checkpoints relink the
+// entries they carry over. Entries of ledgers written before chaining have
+// no link and are only reported, unless none of the entries is linked.
+
+// ErrBrokenChain is wrapped by the *ChainError returned for a ledger whose
+// hash chain is broken.
+var ErrBrokenChain = errors.New("ledger hash chain is broken")
+
+// ErrUnchained is returned for a ledger of several entries none of which
+// is linked, so nothing was verified.
+var ErrUnchained = errors.New("ledger has no hash chain")
+
+// ChainError describes the first broken link of a ledger's hash chain.
+type ChainError struct {
+	Entry  int64  // Index of the entry, from 0
+	Offset int64  // Byte offset of the entry in the file
+	Seq    uint64 // Sequence number of the last operation before the entry
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("entry %d at offset %d (after seq %d): %s", e.Entry, e.Offset, e.Seq, e.Reason)
+}
+
+func (e *ChainError) Unwrap() error {
+	return ErrBrokenChain
+}
+
+// ChainReport describes a verified hash chain.
+type ChainReport struct {
+	Entries   int64  // Entries in the ledger
+	Unchained int64  // Leading entries written before entries were chained, not verified
+	Roots     int64  // Merkle roots verified
+	Head      []byte // Hash of the last entry, to compare with a copy kept elsewhere
+}
+
+// chain tracks the last link of a ledger's hash chain.
+type chain struct {
+	prev    []byte   // Hash of the last entry (nil before the first)
+	pending [][]byte // Hashes of the entries since the last Merkle root
+}
+
+// add makes the entry with the given body the last link.
+func (c *chain) add(entry ledgerEntry, body []byte) {
+	sum := sha256.Sum256(body)
+	c.prev = sum[:]
+	if entry.Op == OpMeta && entry.Root != nil {
+		c.pending = nil
+		return
+	}
+	c.pending = append(c.pending, c.prev)
+}
+
+// rootDue reports whether a Merkle root must be written next.
+func (c *chain) rootDue(interval int) bool {
+	return interval > 0 && len(c.pending) >= interval
+}
+
+// splitEntry parses an entry body into its header and the raw bytes that
+// follow it: the value, or the operations of a batch. Entries of the JSON
+// layout are split the same way, so they can be written in the binary one.
+func splitEntry(body []byte) (ledgerEntry, []byte, error) {
+	_, _, body, _ = splitSigned(body)
+	if len(body) == 0 || body[0] != entryBinary {
+		entry, err := decodeEntry(body)
+		value := []byte(entry.Value)
+		entry.Value, entry.Raw = nil, nil
+		return entry, value, err
+	}
+
+	var entry ledgerEntry
+	r := bytes.NewReader(body[1:])
+	header, err := readChunk(r)
+	if err != nil {
+		return entry, nil, err
+	}
+	if err := json.Unmarshal(header, &entry); err != nil {
+		return entry, nil, err
+	}
+	return entry, body[len(body)-r.Len():], nil
+}
+
+// copyEntries appends the entries of src between offset and end to the
+// ledger, linking them into its chain and signing them with its Signer.
+// Merkle roots are left out, since the ledger writes its own.
+func (l *Ledger) copyEntries(src *Ledger, offset, end int64) error {
+	reader := bufio.NewReader(io.NewSectionReader(src.file, offset, end-offset))
+	for {
+		body, err := src.readEntry(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		entry, value, err := splitEntry(body)
+		if err != nil {
+			return err
+		}
+		if entry.Op == OpMeta && entry.Root != nil {
+			continue
+		}
+		if err := l.writeEntry(entry, value); err != nil {
+			return err
+		}
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// VerifyChain checks the hash chain of the history from its first entry
+// to its last. It returns a *ChainError for the first broken link: an
+// entry that cannot be read, one whose link does not match the entry
+// before it, or a Merkle root that does not match the entries it covers.
+// If signatures are required, an entry not signed by a trusted key returns
+// a *SignatureError instead. A ledger whose entries all predate chaining
+// returns its report with ErrUnchained.
+//
+// The chain shows that no entry was changed without rewriting every entry
+// after it. Comparing the report's Head, or a Merkle root, with a copy
+// kept elsewhere also rules out that.
+func (h *History) VerifyChain() (ChainReport, error) {
+	counter := &countingReader{r: io.NewSectionReader(h.r.file, h.r.start, h.size-h.r.start)}
+	reader := bufio.NewReader(counter)
+	var report ChainReport
+	var links chain
+	var c clock
+	chained := false
+	for {
+		offset := h.r.start + counter.n - int64(reader.Buffered())
+		broken := func(format string, args ...interface{}) (ChainReport, error) {
+			return report, &ChainError{Entry: report.Entries, Offset: offset, Seq: c.seq, Reason: fmt.Sprintf(format, args...)}
+		}
+
+		body, err := h.r.readEntry(reader)
+		if err == io.EOF {
+			report.Head = links.prev
+			if report.Unchained > 0 && !chained {
+				return report, ErrUnchained
+			}
+			return report, nil
+		}
+		if err != nil {
+			return broken("unreadable entry: %v", err)
+		}
+		if err := h.r.opts.checkSignature(body, report.Entries, offset, c.seq); err != nil {
+			return report, err
+		}
+		entry, err := decodeEntry(body)
+		if err != nil {
+			return broken("undecodable entry: %v", err)
+		}
+
+		switch {
+		case entry.Prev != nil && links.prev == nil:
+			return broken("first entry links to a missing one")
+		case entry.Prev != nil && !bytes.Equal(entry.Prev, links.prev):
+			return broken("link does not match the entry before it")
+		case entry.Prev != nil:
+			chained = true
+		case report.Entries > 0 && chained:
+			return broken("entry is not linked to the one before it")
+		case report.Entries > 0:
+			report.Unchained++
+		}
+		if entry.Op == OpMeta && entry.Root != nil {
+			if !bytes.Equal(entry.Root, integrity.MerkleRoot(links.pending)) {
+				return broken("Merkle root does not match the %d entries before it", len(links.pending))
+			}
+			report.Roots++
+		}
+
+		links.add(entry, body)
+		c.observe(&entry)
+		report.Entries++
+	}
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ledgerFrames splits the contents of a ledger file into its header and
+// frames.
+func ledgerFrames(t *testing.T, data []byte) ([]byte, [][]byte) {
+	t.Helper()
+	_, size, err := readFileHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("readFileHeader() failed: %v", err)
+	}
+	header, data := data[:size:size], data[size:]
+	var frames [][]byte
+	for len(data) > 0 {
+		n := 4 + int(binary.BigEndian.Uint32(data))
+		frames = append(frames, data[:n])
+		data = data[n:]
+	}
+	return header, frames
+}
+
+// verifyFile verifies the chain of the ledger file written with opts.
+func verifyFile(t *testing.T, opts Options) (ChainReport, error) {
+	t.Helper()
+	opts.ReadOnly = true
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	defer l.Close()
+	if _, err := l.LoadState(); err != nil {
+		t.Fatalf("LoadState() failed: %v", err)
+	}
+	return l.History().VerifyChain()
+}
+
+func TestLedgerChain(t *testing.T) {
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db"), EncryptionKey: make([]byte, 32), MerkleInterval: 2}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	l.PersistBatch([]PersistRequest{
+		{Op: OpSet, Key: "b", Value: []byte(`2`)},
+		{Op: OpSet, Key: "c", Value: []byte(`3`)},
+	})
+	l.Close()
+
+	// Writes after reopening continue the chain and the Merkle roots
+	l, err = NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	l.LoadState()
+	l.Persist(PersistRequest{Op: OpDelete, Key: "a"})
+	l.Persist(PersistRequest{Op: OpSet, Key: "d", Value: []byte(`4`)})
+	l.Close()
+
+	report, err := verifyFile(t, opts)
+	if err != nil {
+		t.Fatalf("VerifyChain() failed: %v", err)
+	}
+	if report.Entries != 6 || report.Roots != 2 || report.Unchained != 0 || len(report.Head) != sha256.Size {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
+
+func TestLedgerChainTampering(t *testing.T) {
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db")}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	for _, v := range []string{`1`, `2`, `3`, `4`} {
+		l.Persist(PersistRequest{Op: OpSet, Key: "k" + v, Value: []byte(v)})
+	}
+	l.Close()
+	original, _ := os.ReadFile(opts.Path)
+	header, frames := ledgerFrames(t, original)
+
+	// A rewritten entry with a valid checksum breaks the link of the next one
+	body := append([]byte{}, frames[1][36:]...)
+	body[len(body)-1] = '9' // The value is last
+	sum := sha256.Sum256(body)
+	rewritten := append(append(append([]byte{}, frames[1][:4]...), sum[:]...), body...)
+
+	tests := []struct {
+		name   string
+		frames [][]byte
+		entry  int64
+	}{
+		{"dropped", [][]byte{frames[0], frames[2], frames[3]}, 1},
+		{"reordered", [][]byte{frames[0], frames[2], frames[1], frames[3]}, 1},
+		{"rewritten", [][]byte{frames[0], rewritten, frames[2], frames[3]}, 2},
+		{"dropped first", [][]byte{frames[1], frames[2], frames[3]}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.WriteFile(opts.Path, append(header, bytes.Join(tt.frames, nil)...), 0600)
+			_, err := verifyFile(t, opts)
+			var broken *ChainError
+			if !errors.As(err, &broken) || !errors.Is(err, ErrBrokenChain) {
+				t.Fatalf("expected a ChainError, got %v", err)
+			}
+			if broken.Entry != tt.entry {
+				t.Errorf("expected the break at entry %d, got %v", tt.entry, broken)
+			}
+			offset := int64(len(header))
+			for _, f := range tt.frames[:tt.entry] {
+				offset += int64(len(f))
+			}
+			if broken.Offset != offset {
+				t.Errorf("expected offset %d, got %d", offset, broken.Offset)
+			}
+		})
+	}
+
+	// Dropping the tail is only caught by comparing the head
+	os.WriteFile(opts.Path, original, 0600)
+	full, _ := verifyFile(t, opts)
+	os.WriteFile(opts.Path, append(header, bytes.Join(frames[:3], nil)...), 0600)
+	truncated, err := verifyFile(t, opts)
+	if err != nil || bytes.Equal(full.Head, truncated.Head) {
+		t.Errorf("expected a valid chain with a different head, got %x (err=%v)", truncated.Head, err)
+	}
+}
+
+func TestLedgerChainUnchained(t *testing.T) {
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db")}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	// Entries written before chaining carry no link
+	for _, key := range []string{"a", "b"} {
+		l.chain = chain{}
+		l.writeEntry(ledgerEntry{Op: OpSet, Key: key}, []byte(`1`))
+	}
+	l.Persist(PersistRequest{Op: OpSet, Key: "c", Value: []byte(`1`)})
+	l.Close()
+
+	report, err := verifyFile(t, opts)
+	if err != nil || report.Entries != 3 || report.Unchained != 1 {
+		t.Errorf("unexpected report %+v (err=%v)", report, err)
+	}
+}
+
+func TestLedgerChainNone(t *testing.T) {
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db")}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	// A ledger written entirely before chaining has nothing to verify
+	for _, key := range []string{"a", "b", "c"} {
+		l.chain = chain{}
+		l.writeEntry(ledgerEntry{Op: OpSet, Key: key}, []byte(`1`))
+	}
+	l.Close()
+
+	report, err := verifyFile(t, opts)
+	if !errors.Is(err, ErrUnchained) || report.Entries != 3 || report.Unchained != 2 {
+		t.Errorf("expected ErrUnchained, got %+v (err=%v)", report, err)
+	}
+}
+
+func TestCompactionChain(t *testing.T) {
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db"), MerkleInterval: 3}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	defer l.Close()
+	for i := 0; i < 5; i++ {
+		l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	}
+
+	state, _ := l.LoadState()
+	c, _ := l.StartCompaction(state)
+	c.Write()
+	// Entries written meanwhile are relinked into the compacted file
+	l.Persist(PersistRequest{Op: OpSet, Key: "b", Value: []byte(`2`)})
+	l.Persist(PersistRequest{Op: OpSet, Key: "c", Value: []byte(`3`)})
+	if err := c.Finish(); err != nil {
+		t.Fatalf("Finish() failed: %v", err)
+	}
+	l.Persist(PersistRequest{Op: OpSet, Key: "d", Value: []byte(`4`)})
+
+	report, err := l.History().VerifyChain()
+	if err != nil {
+		t.Fatalf("VerifyChain() failed: %v", err)
+	}
+	// meta, a, b, c, root, d
+	if report.Entries != 6 || report.Roots != 1 {
+		t.Errorf("unexpected report after compaction: %+v", report)
+	}
+	if _, err := verifyFile(t, opts); err != nil {
+		t.Errorf("VerifyChain() of the file failed: %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/evertonmj/codex/codex/app/src/atomic"
+)
+
+// LedgerStats describes the size of a ledger file.
+type LedgerStats struct {
+	Size    int64 // Bytes in the file
+	Entries int64 // Entries in the file, live or not
+}
+
+// Stats returns the current size of the ledger.
+func (l *Ledger) Stats() LedgerStats {
+	return LedgerStats{Size: l.size, Entries: l.entries}
+}
+
+// Stamp identifies the entries loaded or written so far by the size of the
+// ledger and the hash of its last entry, which is chained to every other.
+// Unlike a hash of the data, it costs nothing to compute.
+func (l *Ledger) Stamp() string {
+	return fmt.Sprintf("%d-%x", l.size, l.chain.prev)
+}
+
+// Compaction rewrites a ledger as the minimal set of entries that rebuild
+// its state, while the ledger stays open for writes.
+//
+// A compaction runs in three steps. StartCompaction and Finish must not
+// run concurrently with Persist or PersistBatch; Write, which does the bulk
+// of the work, may:
+//
+//	c, err := ledger.StartCompaction(state) // state must match the ledger
+//	err = c.Write()                         // writes may continue meanwhile
+//	err = c.Finish()                        // replaces the ledger file
+type Compaction struct {
+	l       *Ledger
+	state   *State
+	offset  int64 // Ledger size when the compaction started
+	entries int64 // Ledger entries when the compaction started
+	seq     uint64
+	time    int64 // Time of operation seq
+	tmp     *os.File
+	w       *Ledger // Writer of tmp
+}
+
+// StartCompaction begins a compaction that rewrites the ledger as state,
+// which must be the state of the ledger as of this call. The compaction
+// takes ownership of state.
+func (l *Ledger) StartCompaction(state *State) (*Compaction, error) {
+	if l.opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if err := l.opts.checkRewrite(); err != nil {
+		return nil, err
+	}
+	return &Compaction{l: l, state: state, offset: l.size, entries: l.entries, seq: l.clock.seq, time: l.clock.time}, nil
+}
+
+// Write writes the compacted entries to a temporary file next to the
+// ledger. Keys that have already expired are left out.
+func (c *Compaction) Write() error {
+	tmp, err := os.OpenFile(c.l.opts.Path+".compact", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create compaction file: %w", err)
+	}
+	c.tmp = tmp
+
+	// Entries are framed by the ledger's own writer, pointed at tmp
+	w := &Ledger{opts: c.l.opts, file: tmp, hybrid: c.l.hybrid}
+	write := func(entry ledgerEntry, value []byte) error {
+		if err := w.writeEntry(entry, value); err != nil {
+			return fmt.Errorf("failed to write compaction file: %w", err)
+		}
+		return nil
+	}
+
+	// The meta entry records where the history now starts, and the
+	// rebuilt operations all stand at that position
+	meta := ledgerEntry{Op: OpMeta, Seq: c.seq, Time: c.time}
+	if codec := c.l.opts.codec(); codec != DefaultCodec {
+		meta.Codec = codec
+	}
+	if meta.Codec != "" || meta.Seq != 0 {
+		if err := write(meta, nil); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedNames(c.state.Indexes) {
+		def := c.state.Indexes[name]
+		if err := write(ledgerEntry{Op: OpCreateIndex, Index: &def, Seq: c.seq, Time: c.time}, nil); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UnixNano()
+	writeKeys := func(bucket string, st *State) error {
+		// The floor keeps versions increasing for keys deleted before now
+		floor := st.Floor
+		for _, key := range sortedNames(st.Data) {
+			expiresAt := st.Expiry[key]
+			if expiresAt != 0 && expiresAt <= now {
+				floor = max(floor, st.Versions[key])
+				continue
+			}
+			entry := ledgerEntry{Op: OpSet, Bucket: bucket, Key: key, ExpiresAt: expiresAt, Version: st.Versions[key], Seq: c.seq, Time: c.time}
+			if err := write(entry, st.Data[key]); err != nil {
+				return err
+			}
+		}
+		if floor != 0 {
+			return write(ledgerEntry{Op: OpMeta, Bucket: bucket, Floor: floor}, nil)
+		}
+		return nil
+	}
+	if err := writeKeys("", c.state); err != nil {
+		return err
+	}
+	for _, name := range sortedNames(c.state.Buckets) {
+		// A clear creates the bucket, so empty buckets survive
+		if err := write(ledgerEntry{Op: OpClear, Bucket: name, Seq: c.seq, Time: c.time}, nil); err != nil {
+			return err
+		}
+		if err := writeKeys(name, c.state.Buckets[name]); err != nil {
+			return err
+		}
+	}
+
+	c.w = w
+	c.state = nil
+	return nil
+}
+
+// Finish appends the entries persisted since StartCompaction to the
+// compacted file, linked into its hash chain, makes it durable, and
+// atomically replaces the ledger with it. On error, the ledger is left
+// unchanged.
+func (c *Compaction) Finish() error {
+	if c.tmp == nil {
+		return fmt.Errorf("compaction was not written")
+	}
+	l, w := c.l, c.w
+	written := w.entries
+	// A ledger that was empty at the start has since had its header written
+	if err := w.copyEntries(l, max(c.offset, l.start), l.size); err != nil {
+		c.Abort()
+		return fmt.Errorf("failed to copy new entries to compaction file: %w", err)
+	}
+	if err := c.tmp.Sync(); err != nil {
+		c.Abort()
+		return fmt.Errorf("failed to sync compaction file: %w", err)
+	}
+
+	if err := l.locks.lockData(); err != nil {
+		c.Abort()
+		return err
+	}
+	defer l.locks.unlockData()
+	if err := atomic.Replace(c.tmp.Name(), l.opts.Path); err != nil {
+		c.Abort()
+		return err
+	}
+
+	l.file.Close()
+	l.file = c.tmp
+	l.size = w.size
+	l.entries = written + l.entries - c.entries
+	l.chain = w.chain
+	l.start = w.start
+	l.meta = l.size == 0 && l.opts.codec() != DefaultCodec
+	l.clock.origin = c.seq
+	c.tmp = nil
+	return nil
+}
+
+// Abort discards the compaction, leaving the ledger unchanged.
+func (c *Compaction) Abort() {
+	if c.tmp != nil {
+		c.tmp.Close()
+		os.Remove(c.tmp.Name())
+		c.tmp = nil
+	}
+}
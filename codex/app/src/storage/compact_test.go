@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLedgerCompaction(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		t.Run(fmt.Sprintf("encrypted=%v", encrypted), func(t *testing.T) {
+			opts := Options{Path: filepath.Join(t.TempDir(), "test.db"), Codec: "msgpack"}
+			if encrypted {
+				opts.EncryptionKey = make([]byte, 32)
+			}
+			l, err := NewLedger(opts)
+			if err != nil {
+				t.Fatalf("NewLedger() failed: %v", err)
+			}
+			defer func() { l.Close() }()
+
+			for i := 0; i < 100; i++ {
+				l.Persist(PersistRequest{Op: OpSet, Key: "hot", Value: []byte(fmt.Sprint(i)), Version: uint64(i + 1)})
+			}
+			l.Persist(PersistRequest{Op: OpSet, Key: "gone", Value: []byte(`1`)})
+			l.Persist(PersistRequest{Op: OpDelete, Key: "gone"})
+			l.Persist(PersistRequest{Op: OpSet, Key: "expired", Value: []byte(`1`), ExpiresAt: time.Now().Add(-time.Hour).UnixNano()})
+			l.Persist(PersistRequest{Op: OpSet, Key: "later", Value: []byte(`1`), ExpiresAt: time.Now().Add(time.Hour).UnixNano()})
+			l.Persist(PersistRequest{Op: OpSet, Bucket: "b", Key: "k", Value: []byte(`2`)})
+			l.Persist(PersistRequest{Op: OpClear, Bucket: "empty"})
+			l.Persist(PersistRequest{Op: OpCreateIndex, Index: &IndexDef{Name: "idx", Path: "name"}})
+
+			state, err := l.LoadState()
+			if err != nil {
+				t.Fatalf("LoadState() failed: %v", err)
+			}
+			before := l.Stats()
+
+			c, err := l.StartCompaction(state)
+			if err != nil {
+				t.Fatalf("StartCompaction() failed: %v", err)
+			}
+			if err := c.Write(); err != nil {
+				t.Fatalf("Write() failed: %v", err)
+			}
+
+			// Writes during the rewrite are carried over
+			l.Persist(PersistRequest{Op: OpSet, Key: "hot", Value: []byte(`"final"`), Version: 101})
+			l.Persist(PersistRequest{Op: OpSet, Bucket: "b", Key: "k2", Value: []byte(`3`)})
+
+			if err := c.Finish(); err != nil {
+				t.Fatalf("Finish() failed: %v", err)
+			}
+			after := l.Stats()
+			if after.Entries >= before.Entries || after.Size >= before.Size {
+				t.Errorf("expected compaction to shrink the ledger: before %+v, after %+v", before, after)
+			}
+			if info, _ := os.Stat(opts.Path); info.Size() != after.Size {
+				t.Errorf("expected file size %d, got %d", after.Size, info.Size())
+			}
+
+			// The open ledger keeps appending to the compacted file
+			l.Persist(PersistRequest{Op: OpDelete, Bucket: "b", Key: "k"})
+			l.Close()
+
+			l, err = NewLedger(opts)
+			if err != nil {
+				t.Fatalf("NewLedger() for reload failed: %v", err)
+			}
+			state, err = l.LoadState()
+			if err != nil {
+				t.Fatalf("LoadState() after compaction failed: %v", err)
+			}
+			if got := l.Stats().Entries; got != after.Entries+1 {
+				t.Errorf("expected %d entries after reload, got %d", after.Entries+1, got)
+			}
+
+			expected := map[string]string{"hot": `"final"`, "later": `1`}
+			if len(state.Data) != len(expected) {
+				t.Errorf("expected keys %v, got %d keys", expected, len(state.Data))
+			}
+			for k, v := range expected {
+				if string(state.Data[k]) != v {
+					t.Errorf("expected %s=%s, got %s", k, v, state.Data[k])
+				}
+			}
+			if state.Versions["hot"] != 101 || state.Expiry["later"] == 0 {
+				t.Errorf("versions or expiry lost: %v %v", state.Versions, state.Expiry)
+			}
+			if b := state.Buckets["b"]; b == nil || !reflect.DeepEqual(b.Data, map[string][]byte{"k2": []byte(`3`)}) {
+				t.Errorf("unexpected bucket b: %+v", b)
+			}
+			if _, ok := state.Buckets["empty"]; !ok {
+				t.Error("expected empty bucket to survive compaction")
+			}
+			if _, ok := state.Indexes["idx"]; !ok || state.Codec != "msgpack" {
+				t.Errorf("expected index and codec to survive, got %v %q", state.Indexes, state.Codec)
+			}
+		})
+	}
+}
+
+func TestLedgerCompactionAbort(t *testing.T) {
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db")}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	defer l.Close()
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`2`)})
+	before, _ := os.ReadFile(opts.Path)
+
+	state, _ := l.LoadState()
+	c, _ := l.StartCompaction(state)
+	if err := c.Write(); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	c.Abort()
+
+	if after, _ := os.ReadFile(opts.Path); string(after) != string(before) {
+		t.Error("expected Abort to leave the ledger unchanged")
+	}
+	if _, err := os.Stat(opts.Path + ".compact"); !os.IsNotExist(err) {
+		t.Error("expected Abort to remove the compaction file")
+	}
+	if err := c.Finish(); err == nil {
+		t.Error("expected Finish after Abort to fail")
+	}
+}
+
+func TestLedgerCompactionEmpty(t *testing.T) {
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db")}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	state, _ := l.LoadState()
+	c, _ := l.StartCompaction(state)
+	if err := c.Write(); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+
+	// The first write also writes the file header, which is not an entry
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	if err := c.Finish(); err != nil {
+		t.Fatalf("Finish() failed: %v", err)
+	}
+	l.Close()
+
+	l, err = NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	defer l.Close()
+	if state, err := l.LoadState(); err != nil || len(state.Data) != 1 {
+		t.Errorf("expected the write to be carried over, got %v (err=%v)", state, err)
+	}
+}
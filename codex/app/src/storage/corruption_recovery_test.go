@@ -1,10 +1,14 @@
 package storage
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestLedgerCorruptionRecovery tests that the ledger can gracefully recover from corrupted entries
@@ -100,9 +104,10 @@ func TestLedgerChecksumValidation(t *testing.T) {
 		t.Fatalf("Failed to read file: %v", err)
 	}
 
-	// Flip a byte in the payload (after length + checksum = 4 + 32 = 36 bytes)
-	if len(fileData) > 40 {
-		fileData[40] ^= 0xFF // Flip bits
+	// Flip a byte in the payload (after header + length + checksum = 4 + 32 = 36 bytes)
+	_, start, _ := readFileHeader(bytes.NewReader(fileData))
+	if len(fileData) > int(start)+40 {
+		fileData[start+40] ^= 0xFF // Flip bits
 		if err := os.WriteFile(storePath, fileData, 0600); err != nil {
 			t.Fatalf("Failed to write corrupted file: %v", err)
 		}
@@ -183,3 +188,226 @@ func TestSnapshotLocking(t *testing.T) {
 
 	t.Log("Snapshot file locking correctly prevented concurrent access")
 }
+
+// TestReadOnlyLocking tests that read-only storers share a file with its writer
+func TestReadOnlyLocking(t *testing.T) {
+	storers := []struct {
+		name string
+		open func(Options) (Storer, error)
+	}{
+		{"ledger", func(o Options) (Storer, error) { return NewLedger(o) }},
+		{"snapshot", func(o Options) (Storer, error) { return NewSnapshot(o) }},
+	}
+
+	for _, tc := range storers {
+		t.Run(tc.name, func(t *testing.T) {
+			storePath := filepath.Join(t.TempDir(), "test.db")
+
+			writer, err := tc.open(Options{Path: storePath})
+			if err != nil {
+				t.Fatalf("failed to open writer: %v", err)
+			}
+			defer writer.Close()
+			data := map[string][]byte{"key1": []byte(`"value1"`)}
+			if err := writer.Persist(PersistRequest{Op: OpSet, Key: "key1", Value: data["key1"], Data: data}); err != nil {
+				t.Fatalf("Persist() failed: %v", err)
+			}
+
+			// Many readers open alongside the writer
+			var readers []Storer
+			for i := 0; i < 2; i++ {
+				r, err := tc.open(Options{Path: storePath, ReadOnly: true})
+				if err != nil {
+					t.Fatalf("failed to open reader %d: %v", i, err)
+				}
+				defer r.Close()
+				readers = append(readers, r)
+			}
+			for i, r := range readers {
+				state, err := r.LoadState()
+				if err != nil {
+					t.Fatalf("reader %d LoadState() failed: %v", i, err)
+				}
+				if string(state.Data["key1"]) != `"value1"` {
+					t.Errorf("reader %d: expected key1 to be loaded, got %q", i, state.Data["key1"])
+				}
+			}
+
+			// The writer keeps writing, and readers see it on their next load
+			data["key2"] = []byte(`"value2"`)
+			if err := writer.Persist(PersistRequest{Op: OpSet, Key: "key2", Value: data["key2"], Data: data}); err != nil {
+				t.Fatalf("Persist() with readers open failed: %v", err)
+			}
+			state, err := readers[0].LoadState()
+			if err != nil || len(state.Data) != 2 {
+				t.Errorf("expected reader to load 2 keys, got %d (err=%v)", len(state.Data), err)
+			}
+
+			if err := readers[0].Persist(PersistRequest{Op: OpSet, Key: "key3", Data: data}); !errors.Is(err, ErrReadOnly) {
+				t.Errorf("expected ErrReadOnly from a reader, got %v", err)
+			}
+			if err := readers[0].PersistBatch([]PersistRequest{{Op: OpSet, Key: "key3", Data: data}}); !errors.Is(err, ErrReadOnly) {
+				t.Errorf("expected ErrReadOnly from a reader batch, got %v", err)
+			}
+
+			// Readers do not make room for a second writer
+			if w2, err := tc.open(Options{Path: storePath}); !errors.Is(err, ErrLocked) {
+				if err == nil {
+					w2.Close()
+				}
+				t.Errorf("expected ErrLocked for a second writer, got %v", err)
+			}
+		})
+	}
+}
+
+// TestReadOnlyLedgerCorruption tests that a read-only ledger recovers valid
+// entries without truncating the file
+func TestReadOnlyLedgerCorruption(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+
+	l, err := NewLedger(Options{Path: storePath})
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	l.Persist(PersistRequest{Op: OpSet, Key: "key1", Value: []byte(`"value1"`)})
+	l.Close()
+
+	f, _ := os.OpenFile(storePath, os.O_APPEND|os.O_WRONLY, 0600)
+	f.Write([]byte("garbage"))
+	f.Close()
+	before, _ := os.Stat(storePath)
+
+	r, err := NewLedger(Options{Path: storePath, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("NewLedger(ReadOnly) failed: %v", err)
+	}
+	defer r.Close()
+	state, err := r.LoadState()
+	if err != nil || len(state.Data) != 1 {
+		t.Fatalf("expected 1 recovered key, got %d (err=%v)", len(state.Data), err)
+	}
+
+	after, _ := os.Stat(storePath)
+	if after.Size() != before.Size() {
+		t.Errorf("read-only ledger changed the file size from %d to %d", before.Size(), after.Size())
+	}
+}
+
+// TestReadOnlyMissingLedger tests that a read-only ledger is not created
+func TestReadOnlyMissingLedger(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+	if _, err := NewLedger(Options{Path: storePath, ReadOnly: true}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist, got %v", err)
+	}
+	if _, err := os.Stat(storePath); !os.IsNotExist(err) {
+		t.Error("read-only ledger created the data file")
+	}
+}
+
+// TestLockHolderAndTimeout tests that lock errors name the holder and that
+// writers can wait for the lock
+func TestLockHolderAndTimeout(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+
+	l1, err := NewLedger(Options{Path: storePath})
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+
+	_, err = NewSnapshot(Options{Path: storePath, LockTimeout: 50 * time.Millisecond})
+	var locked *LockedError
+	if !errors.As(err, &locked) || !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected LockedError wrapping ErrLocked, got %v", err)
+	}
+	if locked.Holder == nil || locked.Holder.PID != os.Getpid() || locked.Holder.Stale() {
+		t.Errorf("expected the live current process as holder, got %v", locked.Holder)
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("pid %d", os.Getpid())) {
+		t.Errorf("expected error to name the holder, got %q", err)
+	}
+
+	released := make(chan struct{})
+	go func() {
+		defer close(released)
+		time.Sleep(100 * time.Millisecond)
+		l1.Close()
+	}()
+	l2, err := NewLedger(Options{Path: storePath, LockTimeout: 5 * time.Second})
+	<-released
+	if err != nil {
+		t.Fatalf("NewLedger() with LockTimeout failed: %v", err)
+	}
+	l2.Close()
+
+	// A clean close leaves no holder behind
+	if data, _ := os.ReadFile(storePath + ".lock"); len(data) != 0 {
+		t.Errorf("expected an empty lock file after close, got %q", data)
+	}
+}
+
+// TestLedgerBatchCrash simulates a crash in the middle of writing a batch:
+// after recovery, none of the batch may be visible.
+func TestLedgerBatchCrash(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		t.Run(fmt.Sprintf("encrypted=%v", encrypted), func(t *testing.T) {
+			opts := Options{Path: filepath.Join(t.TempDir(), "test.db")}
+			if encrypted {
+				opts.EncryptionKey = make([]byte, 32)
+			}
+			l, err := NewLedger(opts)
+			if err != nil {
+				t.Fatalf("NewLedger() failed: %v", err)
+			}
+			l.Persist(PersistRequest{Op: OpSet, Key: "before", Value: []byte(`1`)})
+			start := l.Stats().Size
+			err = l.PersistBatch([]PersistRequest{
+				{Op: OpSet, Key: "a", Value: []byte(`"a"`)},
+				{Op: OpDelete, Key: "before"},
+				{Op: OpSet, Bucket: "b", Key: "c", Value: []byte(`"c"`)},
+			})
+			if err != nil {
+				t.Fatalf("PersistBatch() failed: %v", err)
+			}
+			end := l.Stats().Size
+			l.Close()
+			full, _ := os.ReadFile(opts.Path)
+
+			for cut := start + 1; cut < end; cut += max(1, (end-start)/16) {
+				if err := os.WriteFile(opts.Path, full[:cut], 0600); err != nil {
+					t.Fatal(err)
+				}
+				l, err := NewLedger(opts)
+				if err != nil {
+					t.Fatalf("NewLedger() failed: %v", err)
+				}
+				state, err := l.LoadState()
+				l.Close()
+				if err != nil {
+					t.Fatalf("LoadState() cut at %d failed: %v", cut, err)
+				}
+				if len(state.Data) != 1 || state.Data["before"] == nil || len(state.Buckets) != 0 {
+					t.Fatalf("cut at %d of [%d, %d): expected none of the batch, got %v %v", cut, start, end, state.Data, state.Buckets)
+				}
+			}
+
+			// The whole batch replays as a unit
+			os.WriteFile(opts.Path, full, 0600)
+			l, err = NewLedger(opts)
+			if err != nil {
+				t.Fatalf("NewLedger() failed: %v", err)
+			}
+			defer l.Close()
+			state, err := l.LoadState()
+			if err != nil {
+				t.Fatalf("LoadState() failed: %v", err)
+			}
+			if len(state.Data) != 1 || state.Data["a"] == nil || state.Buckets["b"] == nil {
+				t.Errorf("expected the whole batch, got %v %v", state.Data, state.Buckets)
+			}
+			if got := l.Stats().Entries; got != 4 {
+				t.Errorf("expected 4 entries, got %d", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/evertonmj/codex/codex/app/src/compression"
+)
+
+// Every file written by this version starts with a header describing how
+// the rest of it is stored: the storage mode, compression, cipher, codec,
+// a key-check value that tells a wrong encryption key apart from
+// corruption, and how the key is derived when it comes from a passphrase.
+// Files of older versions have no header and are read as before.
+
+var (
+	// ErrWrongKey is returned when opening an encrypted file with a key
+	// other than the one it was written with.
+	ErrWrongKey = errors.New("wrong encryption key")
+
+	// ErrOptionsMismatch is returned when opening a file with options that
+	// cannot read it, such as the wrong storage mode or a missing key.
+	ErrOptionsMismatch = errors.New("options do not match the database file")
+)
+
+// Storage modes recorded in file headers.
+const (
+	modeSnapshot = "snapshot"
+	modeLedger   = "ledger"
+	modeHybrid   = "hybrid" // Both the checkpoint and the write-ahead log
+)
+
+// fileMagic starts every file with a header. Read as the length of a
+// ledger frame, it would be over 2 GiB, so it is never mistaken for the
+// start of an older ledger.
+const fileMagic = "\x89CDX\r\n\x1a\n"
+
+// fileVersion is the current version of the file layout.
+const fileVersion = 1
+
+// maxHeaderSize bounds the length of a header, which is read from disk
+// before anything else can be checked. Real headers are a few hundred bytes.
+const maxHeaderSize = 64 << 10
+
+// fileHeader describes how a file is stored. On disk it is fileMagic, a
+// 4-byte big-endian length, and the header as JSON.
+type fileHeader struct {
+	Version     int      `json:"version"`
+	Mode        string   `json:"mode"`
+	Compression string   `json:"compression"`
+	Cipher      string   `json:"cipher,omitempty"`    // "" if not encrypted
+	Codec       string   `json:"codec"`               // ID of the value codec
+	KeyCheck    []byte   `json:"key_check,omitempty"` // Identifies the encryption key
+	KDF         *fileKDF `json:"kdf,omitempty"`       // How the key is derived from a passphrase
+}
+
+// newFileHeader returns the header of a file written with opts in mode.
+func newFileHeader(opts Options, mode string) fileHeader {
+	h := fileHeader{
+		Version:     fileVersion,
+		Mode:        mode,
+		Compression: opts.Compression.String(),
+		Codec:       opts.codec(),
+	}
+	if opts.EncryptionKey != nil {
+		h.Cipher = fmt.Sprintf("aes-%d-gcm", len(opts.EncryptionKey)*8)
+		h.KeyCheck = keyCheck(opts.EncryptionKey)
+		h.KDF = opts.kdf
+	}
+	return h
+}
+
+// keyCheck returns a value that identifies key without revealing it.
+func keyCheck(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("codex key check"))
+	return mac.Sum(nil)[:16]
+}
+
+// encode returns the header as written at the start of a file.
+func (h fileHeader) encode() []byte {
+	data, _ := json.Marshal(h)
+	out := make([]byte, 0, len(fileMagic)+4+len(data))
+	out = append(out, fileMagic...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(data)))
+	return append(out, data...)
+}
+
+// readFileHeader reads the header at the start of r and returns it with
+// its size in bytes, or nil if the file has no header. A header cut short
+// returns io.ErrUnexpectedEOF; one longer than maxHeaderSize is corrupt.
+func readFileHeader(r io.ReaderAt) (*fileHeader, int64, error) {
+	prefix := make([]byte, len(fileMagic)+4)
+	n, err := r.ReadAt(prefix, 0)
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	switch {
+	case n == 0:
+		return nil, 0, nil
+	case n < len(fileMagic):
+		if bytes.HasPrefix([]byte(fileMagic), prefix[:n]) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		return nil, 0, nil
+	case !bytes.HasPrefix(prefix, []byte(fileMagic)):
+		return nil, 0, nil
+	case n < len(prefix):
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	length := binary.BigEndian.Uint32(prefix[len(fileMagic):])
+	if length > maxHeaderSize {
+		return nil, 0, fmt.Errorf("invalid file header: length %d exceeds %d bytes", length, maxHeaderSize)
+	}
+	data := make([]byte, length)
+	if _, err := r.ReadAt(data, int64(len(prefix))); err != nil {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	var h fileHeader
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, 0, fmt.Errorf("invalid file header: %w", err)
+	}
+	if h.Version > fileVersion {
+		return nil, 0, fmt.Errorf("unsupported file version %d", h.Version)
+	}
+	return &h, int64(len(prefix) + len(data)), nil
+}
+
+// check returns an error if opts cannot read a file with header h in
+// mode, and otherwise opts adjusted to the file: its compression is used
+// whatever opts set.
+func (h *fileHeader) check(opts Options, mode string) (Options, error) {
+	if h.Mode != mode {
+		return opts, fmt.Errorf("%w: file was written in %s mode, opened in %s mode", ErrOptionsMismatch, h.Mode, mode)
+	}
+	switch {
+	case h.Cipher != "" && opts.EncryptionKey == nil && opts.Passphrase == nil:
+		return opts, fmt.Errorf("%w: file is encrypted (%s) but no encryption key was given", ErrOptionsMismatch, h.Cipher)
+	case h.Cipher == "" && (opts.EncryptionKey != nil || opts.Passphrase != nil):
+		return opts, fmt.Errorf("%w: file is not encrypted but an encryption key was given", ErrOptionsMismatch)
+	case h.KDF != nil && opts.Passphrase == nil:
+		return opts, fmt.Errorf("%w: file is encrypted with a passphrase but an encryption key was given", ErrOptionsMismatch)
+	case h.Cipher != "" && h.KDF == nil && opts.Passphrase != nil:
+		return opts, fmt.Errorf("%w: file is encrypted with a raw key (%s), not a passphrase", ErrOptionsMismatch, h.Cipher)
+	}
+	// Another writer may have created the file with its own salt
+	if h.KDF != nil && !h.KDF.equal(opts.kdf) {
+		var err error
+		if opts, err = opts.derive(h.KDF); err != nil {
+			return opts, err
+		}
+	}
+	if h.Cipher != "" && !hmac.Equal(h.KeyCheck, keyCheck(opts.EncryptionKey)) {
+		return opts, ErrWrongKey
+	}
+
+	algo, ok := parseCompression(h.Compression)
+	if !ok {
+		return opts, fmt.Errorf("%w: unknown compression %q", ErrOptionsMismatch, h.Compression)
+	}
+	opts.Compression = algo
+	return opts, nil
+}
+
+// parseCompression returns the algorithm with the given name.
+func parseCompression(name string) (compression.Algorithm, bool) {
+	for _, algo := range []compression.Algorithm{compression.None, compression.Gzip, compression.Zstd, compression.Snappy} {
+		if algo.String() == name {
+			return algo, true
+		}
+	}
+	return 0, false
+}
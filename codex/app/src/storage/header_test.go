@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/evertonmj/codex/codex/app/src/compression"
+)
+
+func TestFileHeaderMismatch(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	wrongKey := bytes.Repeat([]byte{2}, 32)
+	dir := t.TempDir()
+
+	// One encrypted file of each mode
+	ledgerPath := filepath.Join(dir, "ledger.db")
+	l, err := NewLedger(Options{Path: ledgerPath, EncryptionKey: key})
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	l.Close()
+	snapshotPath := filepath.Join(dir, "snapshot.db")
+	s, err := NewSnapshot(Options{Path: snapshotPath, EncryptionKey: key})
+	if err != nil {
+		t.Fatalf("NewSnapshot() failed: %v", err)
+	}
+	s.Persist(PersistRequest{Data: map[string][]byte{"a": []byte(`1`)}})
+	s.Close()
+
+	load := func(path string, ledger bool, opts Options) error {
+		opts.Path, opts.ReadOnly = path, true
+		var storer Storer
+		if ledger {
+			storer, err = NewLedger(opts)
+		} else {
+			storer, err = NewSnapshot(opts)
+		}
+		if err != nil {
+			t.Fatalf("failed to open storer: %v", err)
+		}
+		defer storer.Close()
+		_, err := storer.LoadState()
+		return err
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		ledger bool
+		key    []byte
+		err    error
+	}{
+		{"ledger", ledgerPath, true, key, nil},
+		{"ledger wrong key", ledgerPath, true, wrongKey, ErrWrongKey},
+		{"ledger short key", ledgerPath, true, key[:16], ErrWrongKey},
+		{"ledger no key", ledgerPath, true, nil, ErrOptionsMismatch},
+		{"snapshot", snapshotPath, false, key, nil},
+		{"snapshot wrong key", snapshotPath, false, wrongKey, ErrWrongKey},
+		{"snapshot no key", snapshotPath, false, nil, ErrOptionsMismatch},
+		{"ledger as snapshot", ledgerPath, false, key, ErrOptionsMismatch},
+		{"snapshot as ledger", snapshotPath, true, key, ErrOptionsMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := load(tt.path, tt.ledger, Options{EncryptionKey: tt.key})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestLedgerWrongKeyWithoutHeader(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db"), EncryptionKey: key}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	l.Close()
+
+	// Ledgers written before headers start with their first entry
+	data, _ := os.ReadFile(opts.Path)
+	_, start, _ := readFileHeader(bytes.NewReader(data))
+	os.WriteFile(opts.Path, data[start:], 0600)
+
+	opts.EncryptionKey = bytes.Repeat([]byte{2}, 32)
+	l, err = NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	defer l.Close()
+	if _, err := l.LoadState(); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("expected ErrWrongKey, got %v", err)
+	}
+	if info, _ := os.Stat(opts.Path); info.Size() != int64(len(data))-start {
+		t.Error("expected the ledger to be left as it was")
+	}
+}
+
+func TestFileHeaderCompression(t *testing.T) {
+	dir := t.TempDir()
+
+	// A ledger keeps appending with the compression it was written with
+	opts := Options{Path: filepath.Join(dir, "ledger.db"), Compression: compression.Gzip}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	l.Close()
+	for _, algo := range []compression.Algorithm{compression.None, compression.Snappy} {
+		opts.Compression = algo
+		l, err = NewLedger(opts)
+		if err != nil {
+			t.Fatalf("NewLedger() failed: %v", err)
+		}
+		state, err := l.LoadState()
+		if err != nil || string(state.Data["a"]) != "1" {
+			t.Fatalf("expected the ledger to load with %v, got %v (err=%v)", algo, state, err)
+		}
+		l.Persist(PersistRequest{Op: OpSet, Key: algo.String(), Value: []byte(`2`)})
+		l.Close()
+	}
+	l, _ = NewLedger(opts)
+	state, err := l.LoadState()
+	l.Close()
+	if err != nil || len(state.Data) != 3 {
+		t.Errorf("expected 3 keys, got %v (err=%v)", state, err)
+	}
+
+	// A snapshot is read as written and rewritten with the options
+	opts = Options{Path: filepath.Join(dir, "snapshot.db"), Compression: compression.Zstd}
+	s, _ := NewSnapshot(opts)
+	s.Persist(PersistRequest{Data: map[string][]byte{"a": []byte(`1`)}})
+	s.Close()
+	s, _ = NewSnapshot(Options{Path: opts.Path})
+	defer s.Close()
+	if state, err := s.LoadState(); err != nil || string(state.Data["a"]) != "1" {
+		t.Errorf("expected the snapshot to load without compression set, got %v (err=%v)", state, err)
+	}
+}
+
+func TestLedgerTornHeader(t *testing.T) {
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db"), Codec: "msgpack"}
+	os.WriteFile(opts.Path, newFileHeader(opts, modeLedger).encode()[:20], 0600)
+
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	defer l.Close()
+	state, err := l.LoadState()
+	if err != nil || len(state.Data) != 0 {
+		t.Fatalf("expected an empty ledger, got %v (err=%v)", state, err)
+	}
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte{0x01}})
+	if state, err = l.LoadState(); err != nil || len(state.Data) != 1 || state.Codec != "msgpack" {
+		t.Errorf("expected the ledger to be rewritten, got %v (err=%v)", state, err)
+	}
+}
+
+func TestOversizedHeader(t *testing.T) {
+	data := append([]byte(fileMagic), 0xff, 0xff, 0xff, 0xf0)
+	data = append(data, `{"version":1}`...)
+	if _, _, err := readFileHeader(bytes.NewReader(data)); err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected a corrupt header error, got %v", err)
+	}
+
+	// The file is reported as corrupt, not dropped as a torn header
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db")}
+	os.WriteFile(opts.Path, data, 0600)
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	if _, err := l.LoadState(); err == nil {
+		t.Error("expected ledger LoadState() to fail")
+	}
+	l.Close()
+	if info, _ := os.Stat(opts.Path); info.Size() != int64(len(data)) {
+		t.Errorf("expected the file to be left unchanged, got %d bytes", info.Size())
+	}
+
+	s, err := NewSnapshot(opts)
+	if err != nil {
+		t.Fatalf("NewSnapshot() failed: %v", err)
+	}
+	defer s.Close()
+	if _, err := s.LoadState(); err == nil {
+		t.Error("expected snapshot LoadState() to fail")
+	}
+}
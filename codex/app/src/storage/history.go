@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrCompacted is returned when a point in a ledger's history was
+// discarded by a compaction.
+var ErrCompacted = errors.New("history was compacted")
+
+// clock numbers the operations of a ledger. Every operation gets the next
+// sequence number and a timestamp no earlier than the previous one's.
+type clock struct {
+	seq    uint64
+	time   int64  // Unix nanoseconds
+	origin uint64 // Sequence number history starts at after a compaction (0 = complete)
+}
+
+// stamp assigns the next sequence number and time to a new operation.
+func (c *clock) stamp(e *ledgerEntry) {
+	c.seq++
+	c.time = max(c.time, time.Now().UnixNano())
+	e.Seq, e.Time = c.seq, c.time
+}
+
+// observe advances the clock past an entry read back from the ledger,
+// numbering operations written before sequence numbers were recorded.
+func (c *clock) observe(e *ledgerEntry) {
+	switch e.Op {
+	case OpBatch:
+		for i := range e.Batch {
+			c.observe(&e.Batch[i])
+		}
+		return
+	case OpMeta:
+		// A compacted ledger starts with the position it was compacted at
+		if e.Seq != 0 {
+			c.seq, c.time, c.origin = e.Seq, e.Time, e.Seq
+		}
+		return
+	}
+	if e.Seq == 0 {
+		e.Seq = c.seq + 1
+	}
+	c.seq = e.Seq
+	c.time = max(c.time, e.Time)
+}
+
+// Seq returns the sequence number of the last operation in the ledger.
+func (l *Ledger) Seq() uint64 {
+	return l.clock.seq
+}
+
+// Record is an operation read back from a ledger's history.
+type Record struct {
+	Seq       uint64    // Position of the operation in the ledger, from 1
+	Time      time.Time // When it was written (zero for ledgers written before times were recorded)
+	Op        PersistOp
+	Bucket    string
+	Key       string
+	Value     []byte
+	ExpiresAt int64     // Expiry deadline in Unix nanoseconds (0 = never)
+	Version   uint64    // Version of the key after the operation (0 = previous + 1)
+	Index     *IndexDef // For OpCreateIndex and OpDropIndex
+
+	// Compacted is set for operations rebuilt by a compaction: the value
+	// of the key as of Seq rather than the operation that wrote it.
+	Compacted bool
+}
+
+// History reads the operations of a ledger as of when it was opened.
+type History struct {
+	r    *Ledger // Reader sharing the ledger's file
+	size int64
+}
+
+// History opens the history of the ledger up to its last operation. It
+// must not run concurrently with Persist or PersistBatch, and the history
+// must not be read concurrently with Compaction.Finish or LoadState.
+func (l *Ledger) History() *History {
+	return &History{r: &Ledger{opts: l.opts, file: l.file, start: l.start}, size: l.size}
+}
+
+// each calls fn for every entry in order until fn returns false, passing
+// the operations of a batch together and meta entries on their own.
+func (h *History) each(fn func(entries []ledgerEntry, c *clock) bool) error {
+	reader := bufio.NewReader(io.NewSectionReader(h.r.file, h.r.start, h.size-h.r.start))
+	var c clock
+	for {
+		body, err := h.r.readEntry(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read ledger history: %w", err)
+		}
+		entry, err := decodeEntry(body)
+		if err != nil {
+			return fmt.Errorf("failed to decode ledger history: %w", err)
+		}
+		c.observe(&entry)
+
+		entries := []ledgerEntry{entry}
+		if entry.Op == OpBatch {
+			entries = entry.Batch
+		}
+		if !fn(entries, &c) {
+			return nil
+		}
+	}
+}
+
+// record converts an operation read back with clock c to a Record.
+func record(e ledgerEntry, c *clock) Record {
+	r := Record{
+		Seq:       e.Seq,
+		Op:        e.Op,
+		Bucket:    e.Bucket,
+		Key:       e.Key,
+		Value:     e.Value,
+		ExpiresAt: e.ExpiresAt,
+		Version:   e.Version,
+		Index:     e.Index,
+		Compacted: c.origin != 0 && e.Seq <= c.origin,
+	}
+	if e.Time != 0 {
+		r.Time = time.Unix(0, e.Time)
+	}
+	return r
+}
+
+// Records calls fn for every operation in the history, in order, until fn
+// returns false.
+func (h *History) Records(fn func(Record) bool) error {
+	return h.each(func(entries []ledgerEntry, c *clock) bool {
+		for _, e := range entries {
+			if e.Op != OpMeta && !fn(record(e, c)) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// State replays the operations for which include returns true, stopping at
+// the first one for which it returns false. Batches are replayed whole or
+// not at all, as decided by their first operation. It returns ErrCompacted
+// if the state before the stopping point was discarded by a compaction.
+func (h *History) State(include func(Record) bool) (*State, error) {
+	state := newState()
+	var compacted bool
+	err := h.each(func(entries []ledgerEntry, c *clock) bool {
+		e := entries[0]
+		if e.Op == OpMeta {
+			// The start of a compacted ledger stands for every operation before it
+			if e.Seq != 0 && !include(Record{Seq: e.Seq, Time: time.Unix(0, e.Time), Op: OpMeta, Compacted: true}) {
+				compacted = true
+				return false
+			}
+			state.apply(e)
+			return true
+		}
+		r := record(e, c)
+		if !include(r) {
+			compacted = r.Compacted
+			return false
+		}
+		for _, e := range entries {
+			state.apply(e)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if compacted {
+		return nil, ErrCompacted
+	}
+	return state, nil
+}
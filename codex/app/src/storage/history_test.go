@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestLedgerHistory(t *testing.T) {
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db")}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	l.PersistBatch([]PersistRequest{
+		{Op: OpSet, Key: "a", Value: []byte(`2`)},
+		{Op: OpSet, Key: "b", Value: []byte(`3`)},
+	})
+	l.Persist(PersistRequest{Op: OpDelete, Key: "a"})
+	l.Close()
+
+	// Sequence numbers continue after reopening
+	l, err = NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	defer l.Close()
+	if _, err := l.LoadState(); err != nil {
+		t.Fatalf("LoadState() failed: %v", err)
+	}
+	if l.Seq() != 4 {
+		t.Fatalf("expected seq 4 after reload, got %d", l.Seq())
+	}
+	l.Persist(PersistRequest{Op: OpSet, Key: "c", Value: []byte(`4`)})
+
+	var records []Record
+	l.History().Records(func(r Record) bool {
+		records = append(records, r)
+		return true
+	})
+	if len(records) != 5 {
+		t.Fatalf("expected 5 records, got %d", len(records))
+	}
+	for i, r := range records {
+		if r.Seq != uint64(i+1) || r.Time.IsZero() || r.Compacted {
+			t.Errorf("unexpected record %d: %+v", i, r)
+		}
+		if i > 0 && r.Time.Before(records[i-1].Time) {
+			t.Errorf("record %d is older than the one before it", i)
+		}
+	}
+
+	// Replaying up to seq 3 includes the whole batch
+	state, err := l.History().State(func(r Record) bool { return r.Seq <= 3 })
+	if err != nil {
+		t.Fatalf("State() failed: %v", err)
+	}
+	if string(state.Data["a"]) != "2" || state.Versions["a"] != 2 || string(state.Data["b"]) != "3" {
+		t.Errorf("unexpected state at seq 3: %v %v", state.Data, state.Versions)
+	}
+	// Operations are stamped in order, so a time selects a prefix too
+	at := records[3].Time
+	state, _ = l.History().State(func(r Record) bool { return !r.Time.After(at) })
+	if _, ok := state.Data["a"]; ok || len(state.Data) != 1 {
+		t.Errorf("unexpected state at %v: %v", at, state.Data)
+	}
+}
+
+func TestLedgerHistoryCompacted(t *testing.T) {
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db")}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	defer l.Close()
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`2`)})
+	l.Persist(PersistRequest{Op: OpSet, Key: "b", Value: []byte(`3`)})
+
+	state, _ := l.LoadState()
+	c, _ := l.StartCompaction(state)
+	c.Write()
+	if err := c.Finish(); err != nil {
+		t.Fatalf("Finish() failed: %v", err)
+	}
+	l.Persist(PersistRequest{Op: OpDelete, Key: "b"})
+	if l.Seq() != 4 {
+		t.Errorf("expected seq 4 after compaction, got %d", l.Seq())
+	}
+
+	var records []Record
+	l.History().Records(func(r Record) bool {
+		records = append(records, r)
+		return true
+	})
+	if len(records) != 3 || !records[0].Compacted || records[0].Seq != 3 || records[2].Compacted || records[2].Seq != 4 {
+		t.Errorf("unexpected records after compaction: %+v", records)
+	}
+
+	if _, err := l.History().State(func(r Record) bool { return r.Seq <= 2 }); !errors.Is(err, ErrCompacted) {
+		t.Errorf("expected ErrCompacted before the compaction point, got %v", err)
+	}
+	state, err = l.History().State(func(r Record) bool { return r.Seq <= 3 })
+	if err != nil || len(state.Data) != 2 {
+		t.Errorf("expected the compacted state at seq 3, got %v (err=%v)", state, err)
+	}
+
+	// Reloading the compacted ledger keeps numbering from where it was
+	if _, err := l.LoadState(); err != nil || l.Seq() != 4 {
+		t.Errorf("expected seq 4 after reload, got %d (err=%v)", l.Seq(), err)
+	}
+}
@@ -0,0 +1,403 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/evertonmj/codex/codex/app/src/atomic"
+)
+
+// checkpointInfo records where a checkpoint stands in the write-ahead log.
+// The checkpoint of generation N holds the state replayed from the log of
+// generation N-1 up to WALOffset; the log of generation N holds only the
+// entries written after that.
+type checkpointInfo struct {
+	Generation uint64 `json:"generation"`
+	WALOffset  int64  `json:"wal_offset"`
+}
+
+// WALPath returns the path of the write-ahead log of a hybrid storer
+// whose checkpoint is at path.
+func WALPath(path string) string {
+	return path + ".wal"
+}
+
+// HybridStats describes the size of a hybrid storer's files.
+type HybridStats struct {
+	LogSize        int64 // Bytes in the write-ahead log
+	CheckpointSize int64 // Bytes in the last checkpoint
+}
+
+// Hybrid implements the Storer interface with a write-ahead log and
+// periodic checkpoints. Writes are appended to the log at WALPath(path),
+// like a ledger. A checkpoint writes the whole state to path, in the
+// snapshot format, and starts a new log holding only the entries written
+// since, so loading replays just the log tail.
+type Hybrid struct {
+	opts           Options
+	locks          *fileLocks
+	wal            *Ledger // Write-ahead log, guarded by locks
+	generation     uint64  // Generation of the checkpoint on disk
+	base           uint64  // Generation of the checkpoint the open log applies to
+	offset         int64   // Where the checkpoint starts in the log if base lags behind
+	checkpointSize int64
+}
+
+// NewHybrid creates a new Hybrid storer. Writers lock the checkpoint and
+// log exclusively; read-only storers share them with the writer and each
+// other.
+func NewHybrid(opts Options) (*Hybrid, error) {
+	opts, err := DeriveKey(opts, opts.Path, WALPath(opts.Path))
+	if err != nil {
+		return nil, err
+	}
+	locks, err := acquireLocks(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock checkpoint file: %w", err)
+	}
+
+	walOpts := opts
+	walOpts.Path = WALPath(opts.Path)
+	flag := os.O_RDWR | os.O_CREATE
+	if opts.ReadOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(walOpts.Path, flag, 0600)
+	if err != nil {
+		locks.release()
+		return nil, fmt.Errorf("failed to open write-ahead log: %w", err)
+	}
+
+	return &Hybrid{opts: opts, locks: locks, wal: &Ledger{opts: walOpts, file: file, hybrid: true}}, nil
+}
+
+// Load loads the checkpoint and log and returns only the key-value data.
+func (h *Hybrid) Load() (map[string][]byte, error) {
+	state, err := h.LoadState()
+	if err != nil {
+		return nil, err
+	}
+	return state.Data, nil
+}
+
+// LoadState loads the latest checkpoint and replays the entries of the
+// write-ahead log written after it. Corruption in the log is recovered as
+// in a ledger.
+func (h *Hybrid) LoadState() (*State, error) {
+	if err := h.locks.lockData(); err != nil {
+		return nil, err
+	}
+	defer h.locks.unlockData()
+
+	// A checkpoint may have replaced the log since it was opened
+	if h.opts.ReadOnly {
+		file, err := os.Open(h.wal.opts.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open write-ahead log: %w", err)
+		}
+		h.wal.file.Close()
+		h.wal.file = file
+	}
+
+	state := newState()
+	var info checkpointInfo
+	fileData, err := os.ReadFile(h.opts.Path)
+	switch {
+	case err == nil:
+		if state, err = decodeSnapshotFile(h.opts, modeHybrid, fileData); err != nil {
+			return nil, fmt.Errorf("failed to load checkpoint: %w", err)
+		}
+		if state.checkpoint != nil {
+			info = *state.checkpoint
+			state.checkpoint = nil
+		}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	h.generation = info.Generation
+	h.checkpointSize = int64(len(fileData))
+
+	if err := h.loadLog(state, info); err != nil {
+		// A writer that stopped while rotating the key leaves the log under
+		// the old key, but only once the new checkpoint holds all of it
+		size, serr := h.wal.file.Seek(0, io.SeekEnd)
+		if !errors.Is(err, ErrWrongKey) || serr != nil || info.Generation == 0 || size != info.WALOffset {
+			return nil, err
+		}
+		h.base, h.offset = info.Generation-1, size
+	}
+	if h.wal.size, err = h.wal.file.Seek(0, io.SeekEnd); err != nil {
+		return nil, fmt.Errorf("failed to seek in write-ahead log: %w", err)
+	}
+
+	if h.opts.ReadOnly {
+		return state, nil
+	}
+	if h.wal.size == 0 {
+		// Every log starts with the generation it applies to
+		if err := h.wal.writeEntry(h.header(h.generation), nil); err != nil {
+			return nil, err
+		}
+		if err := h.wal.Sync(); err != nil {
+			return nil, err
+		}
+	}
+	if err := h.catchUp(); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// loadLog replays the entries of the log that are not in the checkpoint
+// described by info onto state.
+func (h *Hybrid) loadLog(state *State, info checkpointInfo) error {
+	// A log that still applies to the previous checkpoint was left behind
+	// by a writer that stopped while checkpointing; only its entries after
+	// the checkpoint's offset are new
+	if err := h.wal.loadHeader(state); err != nil {
+		return err
+	}
+	h.base, h.offset = info.Generation, 0
+	if header, ok := h.wal.readHeader(); ok && header.Op == OpMeta {
+		h.base = header.Checkpoint
+	}
+	switch {
+	case h.base == h.generation:
+	case h.base+1 == h.generation:
+		h.offset = info.WALOffset
+	default:
+		return fmt.Errorf("write-ahead log of checkpoint %d does not apply to checkpoint %d", h.base, h.generation)
+	}
+
+	return h.wal.replay(state, h.offset)
+}
+
+// header returns the first entry of the log of the given generation.
+func (h *Hybrid) header(generation uint64) ledgerEntry {
+	return ledgerEntry{Op: OpMeta, Codec: h.opts.codec(), Checkpoint: generation}
+}
+
+// prepareLog writes the log of the given generation next to the current
+// one, with the storer's options: its header followed by the entries of
+// the current log from offset on, relinked into the new log's hash chain.
+// The caller must prevent concurrent writes to the log.
+func (h *Hybrid) prepareLog(generation uint64, offset int64) (*Ledger, error) {
+	opts := h.opts
+	opts.Path = WALPath(h.opts.Path)
+	file, err := os.OpenFile(opts.Path+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create write-ahead log: %w", err)
+	}
+	w := &Ledger{opts: opts, file: file, hybrid: true}
+	err = w.writeEntry(h.header(generation), nil)
+	if err == nil {
+		err = w.copyEntries(h.wal, offset, h.wal.size)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("failed to write write-ahead log: %w", err)
+	}
+	return w, nil
+}
+
+// catchUp replaces a log that applies to the previous checkpoint with one
+// that applies to the current checkpoint. The caller must hold the data lock.
+func (h *Hybrid) catchUp() error {
+	if h.base == h.generation {
+		return nil
+	}
+	w, err := h.prepareLog(h.generation, h.offset)
+	if err != nil {
+		return err
+	}
+	if err := atomic.Replace(w.file.Name(), h.wal.opts.Path); err != nil {
+		w.file.Close()
+		os.Remove(w.file.Name())
+		return err
+	}
+	h.wal.file.Close()
+	h.wal = w
+	h.base, h.offset = h.generation, 0
+	return nil
+}
+
+// Persist appends a single operation to the write-ahead log.
+func (h *Hybrid) Persist(req PersistRequest) error {
+	return h.append([]PersistRequest{req})
+}
+
+// PersistBatch appends multiple operations to the write-ahead log as one
+// entry, which is replayed all-or-nothing.
+func (h *Hybrid) PersistBatch(reqs []PersistRequest) error {
+	if len(reqs) == 0 {
+		return nil
+	}
+	return h.append(reqs)
+}
+
+// append writes reqs to the log and syncs it unless DeferSync is set.
+func (h *Hybrid) append(reqs []PersistRequest) error {
+	if h.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := h.locks.lockData(); err != nil {
+		return err
+	}
+	defer h.locks.unlockData()
+
+	// Nothing is appended under the key a rotation replaced
+	if !bytes.Equal(h.wal.opts.EncryptionKey, h.opts.EncryptionKey) {
+		if err := h.catchUp(); err != nil {
+			return err
+		}
+	}
+	if err := h.wal.write(reqs...); err != nil {
+		return err
+	}
+	if h.opts.DeferSync {
+		return nil
+	}
+	return h.wal.Sync()
+}
+
+// Sync flushes appended entries to stable storage.
+func (h *Hybrid) Sync() error {
+	return h.wal.Sync()
+}
+
+// Stats returns the current size of the log and of the last checkpoint.
+func (h *Hybrid) Stats() HybridStats {
+	return HybridStats{LogSize: h.wal.size, CheckpointSize: h.checkpointSize}
+}
+
+// Stamp identifies the data loaded or written so far by the checkpoint
+// generation and the position of the log.
+func (h *Hybrid) Stamp() string {
+	return fmt.Sprintf("%d-%s", h.generation, h.wal.Stamp())
+}
+
+// Close closes the write-ahead log and releases the file locks.
+func (h *Hybrid) Close() error {
+	if h.wal.file == nil {
+		return nil
+	}
+	err := h.wal.file.Close()
+	h.wal.file = nil
+	if lerr := h.locks.release(); err == nil {
+		err = lerr
+	}
+	return err
+}
+
+// Checkpoint writes the state of a hybrid storer to its checkpoint file
+// and starts a new write-ahead log, while the storer stays open for writes.
+//
+// Like a Compaction, a checkpoint runs in three steps. StartCheckpoint and
+// Finish must not run concurrently with Persist or PersistBatch; Write,
+// which does the bulk of the work, may:
+//
+//	c, err := hybrid.StartCheckpoint(state) // state must match the storer
+//	err = c.Write()                         // writes may continue meanwhile
+//	err = c.Finish()                        // replaces the checkpoint and log
+type Checkpoint struct {
+	h     *Hybrid
+	state *State
+	info  checkpointInfo
+	tmp   string // Written checkpoint file
+	size  int64
+}
+
+// StartCheckpoint begins a checkpoint of state, which must be the state of
+// the storer as of this call. The checkpoint takes ownership of state.
+func (h *Hybrid) StartCheckpoint(state *State) (*Checkpoint, error) {
+	if h.opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if err := h.opts.checkRewrite(); err != nil {
+		return nil, err
+	}
+	if err := h.locks.lockData(); err != nil {
+		return nil, err
+	}
+	defer h.locks.unlockData()
+
+	// Offsets are only recorded in a log that applies to the current checkpoint
+	if err := h.catchUp(); err != nil {
+		return nil, err
+	}
+	info := checkpointInfo{Generation: h.generation + 1, WALOffset: h.wal.size}
+	return &Checkpoint{h: h, state: state, info: info}, nil
+}
+
+// Write writes the checkpoint to a temporary file next to the storer's.
+func (c *Checkpoint) Write() error {
+	data, _, err := encodeSnapshot(c.h.opts, c.state.request(), &c.info)
+	if err != nil {
+		return err
+	}
+
+	tmp := c.h.opts.Path + ".checkpoint"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint file: %w", err)
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write checkpoint file: %w", err)
+	}
+
+	c.tmp = tmp
+	c.size = int64(len(data))
+	c.state = nil
+	return nil
+}
+
+// Finish replaces the checkpoint file and then starts a log holding the
+// entries persisted since StartCheckpoint. If the process stops between
+// the two, loading replays the old log from the checkpoint's offset. On
+// error before the checkpoint is replaced, the storer is left unchanged.
+func (c *Checkpoint) Finish() error {
+	if c.tmp == "" {
+		return fmt.Errorf("checkpoint was not written")
+	}
+	h := c.h
+	if err := h.locks.lockData(); err != nil {
+		c.Abort()
+		return err
+	}
+	defer h.locks.unlockData()
+
+	if err := atomic.Replace(c.tmp, h.opts.Path); err != nil {
+		c.Abort()
+		return err
+	}
+	c.tmp = ""
+	h.generation = c.info.Generation
+	h.offset = c.info.WALOffset
+	h.checkpointSize = c.size
+
+	// On error, the old log keeps working and is replaced by the next checkpoint
+	return h.catchUp()
+}
+
+// Abort discards the checkpoint, leaving the storer unchanged.
+func (c *Checkpoint) Abort() {
+	if c.tmp != "" {
+		os.Remove(c.tmp)
+		c.tmp = ""
+	}
+}
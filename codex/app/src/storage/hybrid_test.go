@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/evertonmj/codex/codex/app/src/compression"
+)
+
+// checkpoint runs a full checkpoint of h, persisting reqs while it is written.
+func checkpoint(t *testing.T, h *Hybrid, reqs ...PersistRequest) {
+	t.Helper()
+	state, err := h.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() failed: %v", err)
+	}
+	c, err := h.StartCheckpoint(state)
+	if err != nil {
+		t.Fatalf("StartCheckpoint() failed: %v", err)
+	}
+	if err := c.Write(); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	for _, req := range reqs {
+		if err := h.Persist(req); err != nil {
+			t.Fatalf("Persist() failed: %v", err)
+		}
+	}
+	if err := c.Finish(); err != nil {
+		t.Fatalf("Finish() failed: %v", err)
+	}
+}
+
+func TestHybrid(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		t.Run(fmt.Sprintf("encrypted=%v", encrypted), func(t *testing.T) {
+			opts := Options{Path: filepath.Join(t.TempDir(), "test.db"), Codec: "msgpack", Compression: compression.Gzip}
+			if encrypted {
+				opts.EncryptionKey = make([]byte, 32)
+			}
+			h, err := NewHybrid(opts)
+			if err != nil {
+				t.Fatalf("NewHybrid() failed: %v", err)
+			}
+			defer func() { h.Close() }()
+			if _, err := h.LoadState(); err != nil {
+				t.Fatalf("LoadState() of a new storer failed: %v", err)
+			}
+
+			for i := 0; i < 100; i++ {
+				h.Persist(PersistRequest{Op: OpSet, Key: "hot", Value: []byte(fmt.Sprint(i)), Version: uint64(i + 1)})
+			}
+			h.PersistBatch([]PersistRequest{
+				{Op: OpSet, Bucket: "b", Key: "k", Value: []byte(`1`)},
+				{Op: OpSet, Key: "gone", Value: []byte(`1`)},
+			})
+			h.Persist(PersistRequest{Op: OpCreateIndex, Index: &IndexDef{Name: "idx", Path: "name"}})
+			before := h.Stats().LogSize
+
+			// Writes during the checkpoint stay in the new log
+			checkpoint(t, h,
+				PersistRequest{Op: OpDelete, Key: "gone"},
+				PersistRequest{Op: OpSet, Key: "during", Value: []byte(`2`)},
+			)
+			if after := h.Stats().LogSize; after >= before || h.Stats().CheckpointSize == 0 {
+				t.Errorf("expected a checkpoint and a shorter log: before %d, after %+v", before, h.Stats())
+			}
+			h.Persist(PersistRequest{Op: OpSet, Bucket: "b", Key: "k", Value: []byte(`3`)})
+			h.Close()
+
+			h, err = NewHybrid(opts)
+			if err != nil {
+				t.Fatalf("NewHybrid() for reload failed: %v", err)
+			}
+			state, err := h.LoadState()
+			if err != nil {
+				t.Fatalf("LoadState() after checkpoint failed: %v", err)
+			}
+			if h.wal.entries != 4 {
+				t.Errorf("expected only the log tail to be replayed, got %d entries", h.wal.entries)
+			}
+			if string(state.Data["hot"]) != "99" || state.Versions["hot"] != 100 {
+				t.Errorf("unexpected hot key: %s (version %d)", state.Data["hot"], state.Versions["hot"])
+			}
+			if _, ok := state.Data["gone"]; ok || string(state.Data["during"]) != "2" {
+				t.Errorf("writes during the checkpoint were lost: %v", state.Data)
+			}
+			if b := state.Buckets["b"]; b == nil || string(b.Data["k"]) != "3" {
+				t.Errorf("unexpected bucket b: %+v", b)
+			}
+			if _, ok := state.Indexes["idx"]; !ok || state.Codec != "msgpack" {
+				t.Errorf("expected index and codec to survive, got %v %q", state.Indexes, state.Codec)
+			}
+		})
+	}
+}
+
+func TestHybridInterruptedCheckpoint(t *testing.T) {
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db")}
+	h, err := NewHybrid(opts)
+	if err != nil {
+		t.Fatalf("NewHybrid() failed: %v", err)
+	}
+	h.LoadState()
+	h.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	checkpoint(t, h)
+	h.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`2`)})
+
+	// Stop after the checkpoint is replaced but before the log is: the
+	// old log is put back in place of the new one
+	walPath := WALPath(opts.Path)
+	oldLog, _ := os.ReadFile(walPath)
+	checkpoint(t, h, PersistRequest{Op: OpSet, Key: "b", Value: []byte(`3`)})
+	h.Close()
+	if err := os.WriteFile(walPath, oldLog, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// The old log ends before the write made during the checkpoint
+	h, err = NewHybrid(opts)
+	if err != nil {
+		t.Fatalf("NewHybrid() for reload failed: %v", err)
+	}
+	state, err := h.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() with the old log failed: %v", err)
+	}
+	if string(state.Data["a"]) != "2" || state.Versions["a"] != 2 {
+		t.Errorf("expected a=2 at version 2, got %s at version %d", state.Data["a"], state.Versions["a"])
+	}
+
+	// The writer brings the log up to date and keeps checkpointing
+	if h.base != h.generation {
+		t.Errorf("expected the log to be caught up, base %d generation %d", h.base, h.generation)
+	}
+	h.Persist(PersistRequest{Op: OpSet, Key: "c", Value: []byte(`4`)})
+	checkpoint(t, h)
+	h.Close()
+
+	h, err = NewHybrid(opts)
+	if err != nil {
+		t.Fatalf("NewHybrid() for reload failed: %v", err)
+	}
+	defer h.Close()
+	state, err = h.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() failed: %v", err)
+	}
+	if len(state.Data) != 2 || string(state.Data["c"]) != "4" {
+		t.Errorf("unexpected data: %v", state.Data)
+	}
+}
+
+func TestHybridReadOnly(t *testing.T) {
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db")}
+	if _, err := NewHybrid(Options{Path: opts.Path, ReadOnly: true}); err == nil {
+		t.Fatal("expected a read-only storer without a log to fail")
+	}
+
+	w, err := NewHybrid(opts)
+	if err != nil {
+		t.Fatalf("NewHybrid() failed: %v", err)
+	}
+	defer w.Close()
+	w.LoadState()
+	w.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+
+	r, err := NewHybrid(Options{Path: opts.Path, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("NewHybrid() for reader failed: %v", err)
+	}
+	defer r.Close()
+	if err := r.Persist(PersistRequest{Op: OpSet, Key: "b"}); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+
+	// Readers follow the writer across checkpoints
+	checkpoint(t, w, PersistRequest{Op: OpSet, Key: "b", Value: []byte(`2`)})
+	state, err := r.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() failed: %v", err)
+	}
+	if len(state.Data) != 2 {
+		t.Errorf("expected both keys, got %v", state.Data)
+	}
+}
@@ -2,107 +2,220 @@ package storage
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 
 	"github.com/evertonmj/codex/codex/app/src/compression"
 	"github.com/evertonmj/codex/codex/app/src/encryption"
-	"github.com/evertonmj/codex/codex/app/src/filelock"
+	"github.com/evertonmj/codex/codex/app/src/integrity"
 )
 
+// errDecryption is wrapped by errors reading entries that do not decrypt.
+var errDecryption = errors.New("decryption failed")
+
 // Ledger implements the Storer interface for append-only ledger persistence.
 type Ledger struct {
-	opts Options
-	file *os.File
+	opts    Options
+	file    *os.File
+	locks   *fileLocks
+	meta    bool  // The codec record must be written before the next entry
+	size    int64 // Bytes of valid entries in the file
+	entries int64 // Number of entries in the file
+	clock   clock // Numbers the operations written
+	chain   chain // Links the entries written
+	start   int64 // Size of the file header (0 for files written without one)
+	hybrid  bool  // Write-ahead log of a Hybrid storer
 }
 
-// NewLedger creates a new Ledger storer with exclusive file locking.
+// NewLedger creates a new Ledger storer. Writers lock the ledger
+// exclusively; read-only storers share it with the writer and each other.
 func NewLedger(opts Options) (*Ledger, error) {
-	file, err := os.OpenFile(opts.Path, os.O_RDWR|os.O_CREATE, 0600)
+	opts, err := DeriveKey(opts, opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	locks, err := acquireLocks(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock ledger file: %w", err)
+	}
+
+	flag := os.O_RDWR | os.O_CREATE
+	if opts.ReadOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(opts.Path, flag, 0600)
 	if err != nil {
+		locks.release()
 		return nil, fmt.Errorf("failed to open ledger file: %w", err)
 	}
 
-	// Acquire exclusive lock to prevent concurrent writes from multiple processes
-	if err := filelock.Lock(file); err != nil {
+	// A new ledger records its codec first, unless it is the default that
+	// files without a record are assumed to use
+	info, err := file.Stat()
+	if err != nil {
 		file.Close()
-		return nil, fmt.Errorf("failed to lock ledger file: %w", err)
+		locks.release()
+		return nil, fmt.Errorf("failed to stat ledger file: %w", err)
 	}
+	meta := info.Size() == 0 && opts.codec() != DefaultCodec
 
-	return &Ledger{opts: opts, file: file}, nil
+	return &Ledger{opts: opts, file: file, locks: locks, meta: meta}, nil
 }
 
-// Load reads and replays the ledger from disk with graceful corruption recovery.
-// If corruption is detected, it recovers data up to the last valid entry and truncates the file.
+// Load replays the ledger and returns only its key-value data.
 func (l *Ledger) Load() (map[string][]byte, error) {
-	data := make(map[string][]byte)
+	state, err := l.LoadState()
+	if err != nil {
+		return nil, err
+	}
+	return state.Data, nil
+}
 
-	if _, err := l.file.Seek(0, 0); err != nil {
+// LoadState reads and replays the ledger from disk with graceful corruption recovery.
+// If corruption is detected, it recovers data up to the last valid entry and truncates the file.
+// Read-only ledgers leave the file as it is.
+func (l *Ledger) LoadState() (*State, error) {
+	if err := l.locks.lockData(); err != nil {
+		return nil, err
+	}
+	defer l.locks.unlockData()
+
+	// A compaction may have replaced the file since it was opened
+	if l.opts.ReadOnly {
+		file, err := os.Open(l.opts.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open ledger file: %w", err)
+		}
+		l.file.Close()
+		l.file = file
+	}
+
+	state := newState()
+	if err := l.loadHeader(state); err != nil {
+		return nil, err
+	}
+	if err := l.replay(state, 0); err != nil {
+		return nil, err
+	}
+
+	// Append after the last valid entry
+	var err error
+	if l.size, err = l.file.Seek(0, io.SeekEnd); err != nil {
 		return nil, fmt.Errorf("failed to seek in ledger file: %w", err)
 	}
+	return state, nil
+}
+
+// mode returns the storage mode recorded in the ledger's file header.
+func (l *Ledger) mode() string {
+	if l.hybrid {
+		return modeHybrid
+	}
+	return modeLedger
+}
+
+// loadHeader reads the file header, if any, checks the ledger's options
+// against it, and adopts the file's compression. The header is written
+// with the first entry, so a writer drops one cut short by a crash.
+func (l *Ledger) loadHeader(state *State) error {
+	header, size, err := readFileHeader(l.file)
+	if err == io.ErrUnexpectedEOF && !l.opts.ReadOnly {
+		if err := l.file.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate ledger header: %w", err)
+		}
+		header, err = nil, nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read ledger header: %w", err)
+	}
+	l.start = size
+	if header == nil {
+		return nil
+	}
+	if l.opts, err = header.check(l.opts, l.mode()); err != nil {
+		return err
+	}
+	if state.Codec == "" {
+		state.Codec = header.Codec
+	}
+	return nil
+}
+
+// replay applies every valid entry of the ledger file from offset on to
+// state, truncating an invalid tail unless the ledger is read-only. The
+// file header must have been loaded.
+func (l *Ledger) replay(state *State, offset int64) error {
+	l.entries = 0
+	l.clock = clock{}
+	l.chain = chain{}
+	offset = max(offset, l.start)
+
+	if _, err := l.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek in ledger file: %w", err)
+	}
 
 	reader := bufio.NewReader(l.file)
-	var lastValidOffset int64 = 0
+	lastValidOffset := offset
 	entryCount := 0
 
 	for {
 		// Record position before reading this entry
 		currentOffset, err := l.file.Seek(0, io.SeekCurrent)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get current file offset: %w", err)
+			return fmt.Errorf("failed to get current file offset: %w", err)
 		}
 		// Adjust for buffered data
 		currentOffset -= int64(reader.Buffered())
 
-		var entryBytes []byte
-		var readErr error
-
-		if l.opts.EncryptionKey != nil {
-			entryBytes, readErr = l.readEncryptedEntry(reader)
-		} else {
-			entryBytes, readErr = l.readPlaintextEntry(reader)
-		}
-
+		entryBytes, readErr := l.readEntry(reader)
 		if readErr == io.EOF {
 			break
 		}
+		// Without a header to check the key against, a first entry that
+		// is whole but does not decrypt was written with another key
+		if entryCount == 0 && l.start == 0 && errors.Is(readErr, errDecryption) {
+			return ErrWrongKey
+		}
 		if readErr != nil {
 			// Corruption detected - truncate at last valid offset
-			if entryCount > 0 {
+			if entryCount > 0 && !l.opts.ReadOnly {
 				if err := l.file.Truncate(lastValidOffset); err != nil {
-					return nil, fmt.Errorf("failed to truncate corrupted ledger: %w", err)
+					return fmt.Errorf("failed to truncate corrupted ledger: %w", err)
 				}
 			}
-			// Return data up to last valid entry
-			return data, nil
+			// Keep data up to last valid entry
+			return nil
 		}
 
-		var entry ledgerEntry
-		if err := json.Unmarshal(entryBytes, &entry); err != nil {
+		// An intact entry with a bad signature was tampered with, not torn
+		if err := l.opts.checkSignature(entryBytes, int64(entryCount), currentOffset, l.clock.seq); err != nil {
+			return err
+		}
+
+		entry, err := decodeEntry(entryBytes)
+		if err != nil {
 			// Corruption in entry JSON - truncate at last valid offset
-			if entryCount > 0 {
+			if entryCount > 0 && !l.opts.ReadOnly {
 				if err := l.file.Truncate(lastValidOffset); err != nil {
-					return nil, fmt.Errorf("failed to truncate corrupted ledger: %w", err)
+					return fmt.Errorf("failed to truncate corrupted ledger: %w", err)
 				}
 			}
-			// Return data up to last valid entry
-			return data, nil
+			// Keep data up to last valid entry
+			return nil
 		}
 
 		// Entry is valid - apply operation
-		switch entry.Op {
-		case OpSet:
-			data[entry.Key] = entry.Value
-		case OpDelete:
-			delete(data, entry.Key)
-		case OpClear:
-			data = make(map[string][]byte)
-		}
+		l.chain.add(entry, entryBytes)
+		l.clock.observe(&entry)
+		state.apply(entry)
+		l.entries += max(1, int64(len(entry.Batch)))
 
 		// Update last valid offset
 		newOffset, err := l.file.Seek(0, io.SeekCurrent)
@@ -112,16 +225,267 @@ func (l *Ledger) Load() (map[string][]byte, error) {
 		entryCount++
 	}
 
-	return data, nil
+	return nil
+}
+
+// readHeader reads the first entry of the ledger file, reporting false if
+// the file is empty or the entry is invalid. The file header must have
+// been loaded.
+func (l *Ledger) readHeader() (ledgerEntry, bool) {
+	reader := bufio.NewReader(io.NewSectionReader(l.file, l.start, 1<<62))
+	entryBytes, err := l.readEntry(reader)
+	if err != nil {
+		return ledgerEntry{}, false
+	}
+	entry, err := decodeEntry(entryBytes)
+	return entry, err == nil
+}
+
+// retire drops the version of a deleted key, raising the floor to it, so
+// the key starts above its old versions if it is created again.
+func (st *State) retire(key string) {
+	st.Floor = max(st.Floor, st.Versions[key])
+	delete(st.Versions, key)
+}
+
+// apply replays a single ledger entry onto the state.
+func (st *State) apply(entry ledgerEntry) {
+	// The codec record always comes first; ledgers without one use the default
+	if st.Codec == "" && entry.Op != OpMeta {
+		st.Codec = DefaultCodec
+	}
+
+	switch entry.Op {
+	case OpBatch:
+		for _, e := range entry.Batch {
+			st.apply(e)
+		}
+		return
+	case OpMeta:
+		if entry.Codec != "" {
+			st.Codec = entry.Codec
+		}
+		if entry.Floor != 0 {
+			b := st.bucket(entry.Bucket, true)
+			b.Floor = max(b.Floor, entry.Floor)
+		}
+		return
+	case OpDropBucket:
+		delete(st.Buckets, entry.Bucket)
+		return
+	case OpCreateIndex:
+		if entry.Index != nil {
+			st.Indexes[entry.Index.Name] = *entry.Index
+		}
+		return
+	case OpDropIndex:
+		if entry.Index != nil {
+			delete(st.Indexes, entry.Index.Name)
+		}
+		return
+	}
+
+	// Only writes create a bucket; deletes against a missing one are no-ops
+	b := st.bucket(entry.Bucket, entry.Op == OpSet || entry.Op == OpClear)
+	if b == nil {
+		return
+	}
+	switch entry.Op {
+	case OpSet:
+		b.Data[entry.Key] = entry.Value
+		// Entries of older ledgers have no explicit version
+		switch version, ok := b.Versions[entry.Key]; {
+		case entry.Version != 0:
+			b.Versions[entry.Key] = entry.Version
+		case ok:
+			b.Versions[entry.Key] = version + 1
+		default:
+			b.Versions[entry.Key] = b.Floor + 1
+		}
+		if entry.ExpiresAt != 0 {
+			b.Expiry[entry.Key] = entry.ExpiresAt
+		} else {
+			delete(b.Expiry, entry.Key)
+		}
+	case OpDelete:
+		delete(b.Data, entry.Key)
+		delete(b.Expiry, entry.Key)
+		b.retire(entry.Key)
+	case OpClear:
+		b.Data = make(map[string][]byte)
+		b.Expiry = make(map[string]int64)
+		for key := range b.Versions {
+			b.retire(key)
+		}
+	case OpExpire:
+		if _, exists := b.Data[entry.Key]; !exists {
+			return
+		}
+		if entry.ExpiresAt != 0 {
+			b.Expiry[entry.Key] = entry.ExpiresAt
+		} else {
+			delete(b.Expiry, entry.Key)
+		}
+	}
 }
 
 // Persist appends a single operation to the ledger file with checksum for corruption detection.
 func (l *Ledger) Persist(req PersistRequest) error {
-	entry := ledgerEntry{Op: req.Op, Key: req.Key, Value: req.Value}
-	entryBytes, err := json.Marshal(entry)
+	if l.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := l.locks.lockData(); err != nil {
+		return err
+	}
+	defer l.locks.unlockData()
+
+	if err := l.write(req); err != nil {
+		return err
+	}
+
+	// Sync to disk for durability (prevent data loss on crash)
+	if l.opts.DeferSync {
+		return nil
+	}
+	return l.Sync()
+}
+
+// Sync flushes appended entries to stable storage.
+func (l *Ledger) Sync() error {
+	if l.opts.ReadOnly {
+		return nil
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync ledger entry: %w", err)
+	}
+	return nil
+}
+
+// write appends operations to the ledger file without syncing, preceded
+// by the codec record if the ledger does not have one yet. Several
+// operations are written as a single OpBatch entry, so a crash never
+// leaves part of them in the file.
+func (l *Ledger) write(reqs ...PersistRequest) error {
+	if l.meta {
+		if err := l.writeEntry(ledgerEntry{Op: OpMeta, Codec: l.opts.codec()}, nil); err != nil {
+			return err
+		}
+		l.meta = false
+	}
+
+	if len(reqs) == 1 {
+		entry := requestEntry(reqs[0])
+		l.clock.stamp(&entry)
+		return l.writeEntry(entry, reqs[0].Value)
+	}
+
+	// The value of a batch entry is the body of every operation, in order
+	var buf bytes.Buffer
+	for _, req := range reqs {
+		entry := requestEntry(req)
+		l.clock.stamp(&entry)
+		body, err := encodeEntry(entry, req.Value)
+		if err != nil {
+			return fmt.Errorf("failed to marshal ledger entry: %w", err)
+		}
+		writeChunk(&buf, body)
+	}
+	if err := l.writeEntry(ledgerEntry{Op: OpBatch}, buf.Bytes()); err != nil {
+		return err
+	}
+	l.entries += int64(len(reqs) - 1)
+	return nil
+}
+
+// requestEntry returns the ledger entry of req, without its value.
+func requestEntry(req PersistRequest) ledgerEntry {
+	return ledgerEntry{
+		Op:        req.Op,
+		Bucket:    req.Bucket,
+		Key:       req.Key,
+		ExpiresAt: req.ExpiresAt,
+		Version:   req.Version,
+		Index:     req.Index,
+	}
+}
+
+// entryBinary marks an entry body holding a length-prefixed JSON header
+// followed by the raw value. Bodies of older entries are a single JSON
+// object with the value embedded, and always start with '{'.
+const entryBinary = 0x01
+
+// encodeEntry returns the body of an entry with the given value.
+func encodeEntry(entry ledgerEntry, value []byte) ([]byte, error) {
+	header, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(1 + binary.MaxVarintLen64 + len(header) + len(value))
+	buf.WriteByte(entryBinary)
+	writeChunk(&buf, header)
+	buf.Write(value)
+	return buf.Bytes(), nil
+}
+
+// decodeEntry parses an entry body in either layout, signed or not.
+func decodeEntry(body []byte) (ledgerEntry, error) {
+	_, _, body, _ = splitSigned(body)
+	var entry ledgerEntry
+	if len(body) == 0 || body[0] != entryBinary {
+		err := json.Unmarshal(body, &entry)
+		if entry.Raw != nil {
+			entry.Value = json.RawMessage(entry.Raw)
+		}
+		return entry, err
+	}
+
+	entry, rest, err := splitEntry(body)
+	if err != nil {
+		return entry, err
+	}
+	entry.Value = rest
+
+	if entry.Op == OpBatch {
+		r := bytes.NewReader(rest)
+		for r.Len() > 0 {
+			chunk, err := readChunk(r)
+			if err != nil {
+				return entry, err
+			}
+			e, err := decodeEntry(chunk)
+			if err != nil {
+				return entry, err
+			}
+			entry.Batch = append(entry.Batch, e)
+		}
+		entry.Value = nil
+	}
+	return entry, nil
+}
+
+// writeEntry frames and appends a single entry to the ledger file, linked
+// to the entry before it and signed if a Signer is set, followed by a
+// Merkle root if one is due. The first entry of a file is preceded by the
+// file header.
+func (l *Ledger) writeEntry(entry ledgerEntry, value []byte) error {
+	if l.size == 0 {
+		header := newFileHeader(l.opts, l.mode()).encode()
+		if _, err := l.file.Write(header); err != nil {
+			return fmt.Errorf("failed to write ledger header: %w", err)
+		}
+		l.size, l.start = int64(len(header)), int64(len(header))
+	}
+
+	entry.Prev = l.chain.prev
+	entryBytes, err := encodeEntry(entry, value)
 	if err != nil {
 		return fmt.Errorf("failed to marshal ledger entry: %w", err)
 	}
+	if l.opts.Signer != nil {
+		entryBytes = signEntry(l.opts.Signer, entryBytes)
+	}
+	body := entryBytes
 
 	// Compress if compression is enabled
 	if l.opts.Compression != compression.None {
@@ -156,15 +520,25 @@ func (l *Ledger) Persist(req PersistRequest) error {
 	if _, err = l.file.Write(finalBytes); err != nil {
 		return fmt.Errorf("failed to write ledger entry: %w", err)
 	}
+	l.size += int64(len(finalBytes))
+	l.entries++
 
-	// Sync to disk for durability (prevent data loss on crash)
-	if err = l.file.Sync(); err != nil {
-		return fmt.Errorf("failed to sync ledger entry: %w", err)
+	l.chain.add(entry, body)
+	if l.chain.rootDue(l.opts.MerkleInterval) {
+		return l.writeEntry(ledgerEntry{Op: OpMeta, Root: integrity.MerkleRoot(l.chain.pending)}, nil)
 	}
-
 	return nil
 }
 
+// readEntry reads and verifies the next entry body, returning io.EOF at
+// the end of the file.
+func (l *Ledger) readEntry(r *bufio.Reader) ([]byte, error) {
+	if l.opts.EncryptionKey != nil {
+		return l.readEncryptedEntry(r)
+	}
+	return l.readPlaintextEntry(r)
+}
+
 func (l *Ledger) readEncryptedEntry(r *bufio.Reader) ([]byte, error) {
 	lenBuf := make([]byte, 4)
 	if _, err := io.ReadFull(r, lenBuf); err != nil {
@@ -190,7 +564,7 @@ func (l *Ledger) readEncryptedEntry(r *bufio.Reader) ([]byte, error) {
 
 	decrypted, err := encryption.Decrypt(encryptedData, l.opts.EncryptionKey)
 	if err != nil {
-		return nil, fmt.Errorf("decryption failed: %w", err)
+		return nil, fmt.Errorf("%w: %w", errDecryption, err)
 	}
 
 	// Verify checksum
@@ -244,29 +618,40 @@ func (l *Ledger) readPlaintextEntry(r *bufio.Reader) ([]byte, error) {
 	return entryBytes, nil
 }
 
-// PersistBatch appends multiple operations to the ledger atomically
+// PersistBatch appends multiple operations to the ledger atomically: after
+// a crash, either all of them or none are replayed.
 func (l *Ledger) PersistBatch(reqs []PersistRequest) error {
 	if len(reqs) == 0 {
 		return nil
 	}
+	if l.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := l.locks.lockData(); err != nil {
+		return err
+	}
+	defer l.locks.unlockData()
 
-	// Write all operations sequentially
-	for _, req := range reqs {
-		if err := l.Persist(req); err != nil {
-			return fmt.Errorf("failed to persist batch operation: %w", err)
-		}
+	// Write all operations as one entry
+	if err := l.write(reqs...); err != nil {
+		return fmt.Errorf("failed to persist batch: %w", err)
 	}
 
 	// Sync to disk for durability
-	return l.file.Sync()
+	if l.opts.DeferSync {
+		return nil
+	}
+	return l.Sync()
 }
 
-// Close releases the file lock and closes the ledger file handle.
+// Close closes the ledger file handle and releases the file locks.
 func (l *Ledger) Close() error {
-	if l.file != nil {
-		// Release the lock before closing
-		filelock.Unlock(l.file) // Ignore error as file is being closed anyway
-		return l.file.Close()
+	if l.file == nil {
+		return nil
 	}
-	return nil
+	err := l.file.Close()
+	if lerr := l.locks.release(); err == nil {
+		err = lerr
+	}
+	return err
 }
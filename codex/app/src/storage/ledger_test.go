@@ -1,6 +1,11 @@
 package storage
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"os"
 	"path/filepath"
 	"reflect"
 	"testing"
@@ -97,3 +102,276 @@ func TestLedger(t *testing.T) {
 		})
 	}
 }
+
+func TestLedgerExpiry(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+	opts := Options{Path: storePath}
+
+	l1, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	l1.Persist(PersistRequest{Op: OpSet, Key: "session", Value: []byte(`"s"`), ExpiresAt: 1000})
+	l1.Persist(PersistRequest{Op: OpSet, Key: "config", Value: []byte(`"c"`)})
+	l1.Persist(PersistRequest{Op: OpExpire, Key: "config", ExpiresAt: 2000})
+	l1.Persist(PersistRequest{Op: OpExpire, Key: "missing", ExpiresAt: 3000})
+	l1.Close()
+
+	l2, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() for reload failed: %v", err)
+	}
+	defer l2.Close()
+
+	state, err := l2.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() failed: %v", err)
+	}
+
+	expected := map[string]int64{"session": 1000, "config": 2000}
+	if !reflect.DeepEqual(expected, state.Expiry) {
+		t.Errorf("expiry mismatch: expected %v, got %v", expected, state.Expiry)
+	}
+}
+
+func TestLedgerVersions(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+	opts := Options{Path: storePath}
+
+	l1, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	l1.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`), Version: 1})
+	l1.PersistBatch([]PersistRequest{
+		{Op: OpSet, Key: "a", Value: []byte(`2`)},
+		{Op: OpSet, Key: "b", Value: []byte(`1`)},
+	})
+	l1.Persist(PersistRequest{Op: OpSet, Key: "b", Value: []byte(`2`), Version: 7})
+	l1.Persist(PersistRequest{Op: OpSet, Key: "c", Value: []byte(`1`)})
+	l1.Persist(PersistRequest{Op: OpDelete, Key: "c"})
+	l1.Persist(PersistRequest{Op: OpSet, Key: "d", Value: []byte(`1`)})
+	l1.Close()
+
+	l2, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() for reload failed: %v", err)
+	}
+	defer l2.Close()
+
+	state, err := l2.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() failed: %v", err)
+	}
+
+	// Deleted keys raise the floor, and new keys start above it
+	expected := map[string]uint64{"a": 2, "b": 7, "d": 2}
+	if !reflect.DeepEqual(expected, state.Versions) || state.Floor != 1 {
+		t.Errorf("versions mismatch: expected %v above floor 1, got %v above %d", expected, state.Versions, state.Floor)
+	}
+}
+
+func TestLedgerBuckets(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+	opts := Options{Path: storePath}
+
+	l1, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	l1.Persist(PersistRequest{Op: OpSet, Key: "k", Value: []byte(`"root"`)})
+	l1.Persist(PersistRequest{Op: OpSet, Bucket: "users", Key: "k", Value: []byte(`"user"`)})
+	l1.Persist(PersistRequest{Op: OpSet, Bucket: "tmp", Key: "k", Value: []byte(`"tmp"`)})
+	l1.Persist(PersistRequest{Op: OpClear, Bucket: "users"})
+	l1.Persist(PersistRequest{Op: OpSet, Bucket: "users", Key: "j", Value: []byte(`"again"`)})
+	l1.Persist(PersistRequest{Op: OpDropBucket, Bucket: "tmp"})
+	l1.Persist(PersistRequest{Op: OpDelete, Bucket: "missing", Key: "k"})
+	l1.Close()
+
+	l2, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() for reload failed: %v", err)
+	}
+	defer l2.Close()
+
+	state, err := l2.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(map[string][]byte{"k": []byte(`"root"`)}, state.Data) {
+		t.Errorf("bucket clear touched the default keyspace: %v", state.Data)
+	}
+	if len(state.Buckets) != 1 || state.Buckets["users"] == nil {
+		t.Fatalf("expected only the users bucket, got %v", state.Buckets)
+	}
+	if !reflect.DeepEqual(map[string][]byte{"j": []byte(`"again"`)}, state.Buckets["users"].Data) {
+		t.Errorf("unexpected users bucket: %v", state.Buckets["users"].Data)
+	}
+}
+
+func TestLedgerIndexDefinitions(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+	opts := Options{Path: storePath}
+
+	l1, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	byEmail := IndexDef{Name: "by_email", Prefix: "user:", Path: "$.email"}
+	byAge := IndexDef{Name: "by_age", Path: "$.age"}
+	l1.Persist(PersistRequest{Op: OpCreateIndex, Index: &byEmail})
+	l1.Persist(PersistRequest{Op: OpCreateIndex, Index: &byAge})
+	l1.Persist(PersistRequest{Op: OpClear})
+	l1.Persist(PersistRequest{Op: OpDropIndex, Index: &byAge})
+	l1.Close()
+
+	l2, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() for reload failed: %v", err)
+	}
+	defer l2.Close()
+
+	state, err := l2.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() failed: %v", err)
+	}
+
+	expected := map[string]IndexDef{"by_email": byEmail}
+	if !reflect.DeepEqual(expected, state.Indexes) {
+		t.Errorf("index definitions mismatch: expected %v, got %v", expected, state.Indexes)
+	}
+}
+
+func TestLedgerCodec(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+	opts := Options{Path: storePath, Codec: "gob"}
+
+	l1, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	// Not valid JSON, so it must not be stored as a raw JSON value
+	if err := l1.Persist(PersistRequest{Op: OpSet, Key: "key1", Value: []byte{0xff, 0x00}}); err != nil {
+		t.Fatalf("Persist(Set) failed: %v", err)
+	}
+	l1.Close()
+
+	l2, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() for reload failed: %v", err)
+	}
+	state, err := l2.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() failed: %v", err)
+	}
+	if state.Codec != "gob" {
+		t.Errorf("expected codec gob, got %q", state.Codec)
+	}
+	if !reflect.DeepEqual(state.Data["key1"], []byte{0xff, 0x00}) {
+		t.Errorf("unexpected value: %v", state.Data["key1"])
+	}
+	// Appending to an existing ledger does not repeat the codec record
+	l2.Persist(PersistRequest{Op: OpDelete, Key: "key1"})
+	l2.Close()
+
+	l3, _ := NewLedger(Options{Path: storePath})
+	defer l3.Close()
+	if state, _ := l3.LoadState(); state.Codec != "gob" || len(state.Data) != 0 {
+		t.Errorf("unexpected state after reload: codec %q, data %v", state.Codec, state.Data)
+	}
+
+	// Ledgers without a codec record use the default, empty ones have none
+	emptyPath := filepath.Join(t.TempDir(), "empty.db")
+	l4, _ := NewLedger(Options{Path: emptyPath})
+	if state, _ := l4.LoadState(); state.Codec != "" {
+		t.Errorf("expected no codec for empty ledger, got %q", state.Codec)
+	}
+	l4.Persist(PersistRequest{Op: OpSet, Key: "k", Value: []byte(`1`)})
+	if state, _ := l4.LoadState(); state.Codec != DefaultCodec {
+		t.Errorf("expected default codec, got %q", state.Codec)
+	}
+	l4.Close()
+}
+
+func TestLedgerBinaryValues(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+	opts := Options{Path: storePath}
+
+	l1, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	// Entries written before values were stored as raw bytes
+	legacy := []ledgerEntry{
+		{Op: OpSet, Key: "json", Value: json.RawMessage(`{"a":1}`)},
+		{Op: OpSet, Key: "raw", Raw: []byte{0xff}},
+	}
+	for _, e := range legacy {
+		body, _ := json.Marshal(e)
+		checksum := sha256.Sum256(body)
+		frame := binary.BigEndian.AppendUint32(nil, uint32(len(body)+32))
+		frame = append(append(frame, checksum[:]...), body...)
+		l1.file.Write(frame)
+	}
+	l1.LoadState()
+	blob := bytes.Repeat([]byte{0x00, 0xff, '"'}, 1000)
+	if err := l1.Persist(PersistRequest{Op: OpSet, Key: "blob", Value: blob}); err != nil {
+		t.Fatalf("Persist(Set) failed: %v", err)
+	}
+	l1.Close()
+
+	info, _ := os.Stat(storePath)
+	if info.Size() > int64(len(blob))+300 {
+		t.Errorf("expected raw value to be stored without inflation, file is %d bytes", info.Size())
+	}
+
+	l2, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() for reload failed: %v", err)
+	}
+	defer l2.Close()
+	data, err := l2.Load()
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	expected := map[string][]byte{"json": []byte(`{"a":1}`), "raw": {0xff}, "blob": blob}
+	if len(data) != len(expected) {
+		t.Fatalf("unexpected keys: %v", data)
+	}
+	for k, v := range expected {
+		if !bytes.Equal(data[k], v) {
+			t.Errorf("unexpected value for %s: %q", k, data[k])
+		}
+	}
+}
+
+func TestLedgerDeferSync(t *testing.T) {
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db"), DeferSync: true}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err := l.Persist(PersistRequest{Op: OpSet, Key: key, Value: []byte(`1`)}); err != nil {
+			t.Fatalf("Persist() failed: %v", err)
+		}
+	}
+	if err := l.PersistBatch([]PersistRequest{{Op: OpSet, Key: "c", Value: []byte(`2`)}}); err != nil {
+		t.Fatalf("PersistBatch() failed: %v", err)
+	}
+	if err := l.Sync(); err != nil {
+		t.Fatalf("Sync() failed: %v", err)
+	}
+	l.Close()
+
+	l, err = NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() for reload failed: %v", err)
+	}
+	defer l.Close()
+	state, err := l.LoadState()
+	if err != nil || len(state.Data) != 3 {
+		t.Errorf("expected 3 keys after reload, got %d (err=%v)", len(state.Data), err)
+	}
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/evertonmj/codex/codex/app/src/filelock"
+)
+
+// ErrReadOnly is returned when a read-only storer is asked to persist.
+var ErrReadOnly = errors.New("storage is read-only")
+
+// LockedError is returned when another process holds the writer lock. It
+// wraps ErrLocked.
+type LockedError struct {
+	Holder *filelock.Holder // Recorded holder of the lock, nil if unknown
+}
+
+func (e *LockedError) Error() string {
+	if e.Holder == nil {
+		return ErrLocked.Error()
+	}
+	return "held by " + e.Holder.String()
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// fileLocks coordinates the processes that open the same data file.
+//
+// The writer holds path+".lock" exclusively for as long as it is open, so
+// there is at most one writer, and records itself in the file so others
+// can tell who holds it. path+".rlock" guards the contents of the
+// data file: the writer holds it exclusively only while it changes the
+// file, and read-only storers hold it shared while they read the file. A
+// reader therefore never sees a half-written update, and any number of
+// readers can load while the writer stays open.
+type fileLocks struct {
+	write *os.File // nil for read-only storers
+	read  *os.File
+}
+
+// acquireLocks opens the lock files of opts.Path and takes the writer lock,
+// waiting up to opts.LockTimeout for it, unless opts.ReadOnly is set.
+func acquireLocks(opts Options) (*fileLocks, error) {
+	locks := &fileLocks{}
+	if !opts.ReadOnly {
+		file, err := os.OpenFile(opts.Path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file: %w", err)
+		}
+		// Acquire exclusive lock to prevent concurrent writes from multiple processes
+		if err := filelock.LockTimeout(file, opts.LockTimeout); err != nil {
+			if errors.Is(err, filelock.ErrLocked) {
+				err = &LockedError{Holder: filelock.ReadHolder(file)}
+			}
+			file.Close()
+			return nil, err
+		}
+		locks.write = file
+		if err := filelock.WriteHolder(file); err != nil {
+			locks.release()
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(opts.Path+".rlock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		locks.release()
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	locks.read = file
+	return locks, nil
+}
+
+// readOnly reports whether the locks belong to a read-only storer.
+func (l *fileLocks) readOnly() bool {
+	return l.write == nil
+}
+
+// lockData waits until the data file can be used: exclusively by the
+// writer, or shared by readers.
+func (l *fileLocks) lockData() error {
+	var err error
+	if l.readOnly() {
+		err = filelock.RLockWait(l.read)
+	} else {
+		err = filelock.LockWait(l.read)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock data file: %w", err)
+	}
+	return nil
+}
+
+// unlockData releases the lock taken by lockData.
+func (l *fileLocks) unlockData() {
+	filelock.Unlock(l.read) // Ignore error as the lock is released on close anyway
+}
+
+// release unlocks and closes the lock files.
+func (l *fileLocks) release() error {
+	var err error
+	if l.write != nil {
+		// A record left behind means the writer did not exit cleanly
+		filelock.ClearHolder(l.write)
+	}
+	for _, file := range []*os.File{l.read, l.write} {
+		if file == nil {
+			continue
+		}
+		// Release the lock before closing
+		filelock.Unlock(file)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/evertonmj/codex/codex/app/src/encryption"
+)
+
+// With Options.Passphrase set instead of EncryptionKey, the key is derived
+// from the passphrase with Options.KDF and a random salt. The salt and the
+// parameters are recorded in the file header, so a file is always read
+// with the key it was written with, whatever KDF is set when opening it.
+
+// fileKDF records in a file header how its key was derived.
+type fileKDF struct {
+	encryption.KDFParams
+	Salt []byte `json:"salt"`
+}
+
+// equal reports whether k and o derive the same key from a passphrase.
+func (k *fileKDF) equal(o *fileKDF) bool {
+	return o != nil && k.KDFParams == o.KDFParams && bytes.Equal(k.Salt, o.Salt)
+}
+
+// DeriveKey returns opts with EncryptionKey derived from Passphrase. The
+// salt and parameters are read from the header of the first of paths that
+// has one; for a new database they are a random salt and opts.KDF. Options
+// without a passphrase, or whose key is already derived, are returned as
+// they are.
+//
+// Storers derive their key when created; call DeriveKey first to learn
+// the key, for example to encrypt other files with it.
+func DeriveKey(opts Options, paths ...string) (Options, error) {
+	if opts.Passphrase == nil || opts.kdf != nil {
+		return opts, nil
+	}
+	if opts.EncryptionKey != nil {
+		return opts, fmt.Errorf("%w: both an encryption key and a passphrase were given", ErrOptionsMismatch)
+	}
+
+	for _, path := range paths {
+		header, err := readPathHeader(path)
+		if err != nil {
+			return opts, err
+		}
+		switch {
+		case header == nil:
+			continue
+		case header.KDF != nil:
+			return opts.derive(header.KDF)
+		case header.Cipher == "":
+			return opts, fmt.Errorf("%w: file is not encrypted but a passphrase was given", ErrOptionsMismatch)
+		default:
+			return opts, fmt.Errorf("%w: file is encrypted with a raw key (%s), not a passphrase", ErrOptionsMismatch, header.Cipher)
+		}
+	}
+
+	kdf := &fileKDF{KDFParams: opts.KDF.WithDefaults(), Salt: make([]byte, 16)}
+	if _, err := rand.Read(kdf.Salt); err != nil {
+		return opts, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return opts.derive(kdf)
+}
+
+// readPathHeader returns the header of the file at path, or nil if it does
+// not exist, is empty, or has only a torn header that writers discard.
+func readPathHeader(path string) (*fileHeader, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	header, _, err := readFileHeader(file)
+	switch {
+	case err == io.ErrUnexpectedEOF:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case header != nil:
+		return header, nil
+	}
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		return nil, fmt.Errorf("%w: file was written before passphrases were supported; open it with an encryption key", ErrOptionsMismatch)
+	}
+	return nil, nil
+}
+
+// derive returns o with the key derived from its passphrase with kdf.
+func (o Options) derive(kdf *fileKDF) (Options, error) {
+	key, err := encryption.DeriveKey(o.Passphrase, kdf.Salt, kdf.KDFParams)
+	if err != nil {
+		return o, fmt.Errorf("failed to derive key from passphrase: %w", err)
+	}
+	o.EncryptionKey, o.kdf = key, kdf
+	return o, nil
+}
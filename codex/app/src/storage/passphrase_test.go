@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/evertonmj/codex/codex/app/src/encryption"
+)
+
+// testKDF keeps key derivation cheap in tests.
+var testKDF = encryption.KDFParams{Time: 1, Memory: 1024, Threads: 1}
+
+func TestPassphrase(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+	open := map[string]func(Options) (Storer, error){
+		"ledger":   func(o Options) (Storer, error) { return NewLedger(o) },
+		"snapshot": func(o Options) (Storer, error) { return NewSnapshot(o) },
+		"hybrid":   func(o Options) (Storer, error) { return NewHybrid(o) },
+	}
+	for name, newStorer := range open {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			s, err := newStorer(Options{Path: path, Passphrase: passphrase, KDF: testKDF})
+			if err != nil {
+				t.Fatalf("failed to open storer: %v", err)
+			}
+			s.LoadState()
+			s.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`), Data: map[string][]byte{"a": []byte(`1`)}})
+			s.Close()
+
+			load := func(opts Options) (*State, error) {
+				opts.Path = path
+				s, err := newStorer(opts)
+				if err != nil {
+					return nil, err
+				}
+				defer s.Close()
+				return s.LoadState()
+			}
+
+			// The salt and parameters come from the file, not the options
+			state, err := load(Options{Passphrase: passphrase, KDF: encryption.KDFParams{Algorithm: encryption.Scrypt, N: 2}})
+			if err != nil || string(state.Data["a"]) != "1" {
+				t.Fatalf("expected the data back, got %v (err=%v)", state, err)
+			}
+			if _, err := load(Options{Passphrase: []byte("wrong")}); !errors.Is(err, ErrWrongKey) {
+				t.Errorf("expected ErrWrongKey for a wrong passphrase, got %v", err)
+			}
+			if _, err := load(Options{EncryptionKey: bytes.Repeat([]byte{1}, 32)}); !errors.Is(err, ErrOptionsMismatch) {
+				t.Errorf("expected ErrOptionsMismatch for a raw key, got %v", err)
+			}
+			if _, err := load(Options{}); !errors.Is(err, ErrOptionsMismatch) {
+				t.Errorf("expected ErrOptionsMismatch without a passphrase, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPassphraseSalt(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{Passphrase: []byte("passphrase"), KDF: testKDF}
+
+	// Every database gets its own salt
+	var keys [][]byte
+	for _, name := range []string{"a.db", "b.db"} {
+		derived, err := DeriveKey(opts, filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("DeriveKey() failed: %v", err)
+		}
+		if len(derived.kdf.Salt) != 16 || derived.kdf.Algorithm != encryption.Argon2id {
+			t.Errorf("unexpected key derivation: %+v", derived.kdf)
+		}
+		keys = append(keys, derived.EncryptionKey)
+	}
+	if bytes.Equal(keys[0], keys[1]) {
+		t.Error("expected different keys for different salts")
+	}
+
+	// A checkpoint and its log share theirs
+	opts.Path = filepath.Join(dir, "hybrid.db")
+	h, err := NewHybrid(opts)
+	if err != nil {
+		t.Fatalf("NewHybrid() failed: %v", err)
+	}
+	defer h.Close()
+	h.LoadState()
+	h.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	checkpoint(t, h, PersistRequest{Op: OpSet, Key: "b", Value: []byte(`2`)})
+	var salts [][]byte
+	for _, path := range []string{opts.Path, WALPath(opts.Path)} {
+		header, err := readPathHeader(path)
+		if err != nil || header == nil || header.KDF == nil {
+			t.Fatalf("expected a passphrase header in %s, got %+v (err=%v)", path, header, err)
+		}
+		salts = append(salts, header.KDF.Salt)
+	}
+	if !bytes.Equal(salts[0], salts[1]) {
+		t.Error("expected the checkpoint and log to share a salt")
+	}
+}
+
+func TestPassphraseRawKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	l, err := NewLedger(Options{Path: path, EncryptionKey: bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	l.Close()
+
+	opts := Options{Path: path, Passphrase: []byte("passphrase"), KDF: testKDF}
+	if _, err := NewLedger(opts); !errors.Is(err, ErrOptionsMismatch) {
+		t.Errorf("expected ErrOptionsMismatch, got %v", err)
+	}
+
+	// Files without a header cannot carry a salt
+	data, _ := os.ReadFile(path)
+	_, start, _ := readFileHeader(bytes.NewReader(data))
+	os.WriteFile(path, data[start:], 0600)
+	if _, err := NewLedger(opts); !errors.Is(err, ErrOptionsMismatch) {
+		t.Errorf("expected ErrOptionsMismatch for a file without a header, got %v", err)
+	}
+}
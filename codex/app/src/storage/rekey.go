@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/evertonmj/codex/codex/app/src/atomic"
+)
+
+// Rekeying rewrites a storer's files under new encryption settings: the
+// EncryptionKey or the Passphrase and KDF of an Options value. Each file is
+// written in full next to the old one and swapped in atomically, so a
+// reader sees either the old file or the new one, never a mix. A new
+// passphrase is derived with a fresh salt unless it went through DeriveKey
+// already; pass the derived options to rekey several files with one salt.
+
+// rekeyed returns o encrypting with the key or passphrase of to in place
+// of its own.
+func (o Options) rekeyed(to Options) (Options, error) {
+	to, err := DeriveKey(to)
+	if err != nil {
+		return o, err
+	}
+	o.EncryptionKey, o.Passphrase, o.KDF, o.kdf = to.EncryptionKey, to.Passphrase, to.KDF, to.kdf
+	return o, nil
+}
+
+// Rekey rewrites the snapshot as state, which must be the storer's state,
+// encrypted as set by to.
+func (s *Snapshot) Rekey(to Options, state *State) error {
+	if s.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	opts, err := s.opts.rekeyed(to)
+	if err != nil {
+		return err
+	}
+	data, sum, err := encodeSnapshot(opts, state.request(), nil)
+	if err != nil {
+		return err
+	}
+
+	if err := s.locks.lockData(); err != nil {
+		return err
+	}
+	defer s.locks.unlockData()
+	if err := atomic.WriteFile(s.opts.Path, data, 0600); err != nil {
+		return err
+	}
+	s.opts, s.sum = opts, sum
+	return nil
+}
+
+// RekeyFile rewrites the snapshot file at path, such as a backup, which
+// opts reads, encrypted as set by to.
+func RekeyFile(path string, opts Options, to Options) error {
+	fileData, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	opts, err = DeriveKey(opts, path)
+	if err != nil {
+		return err
+	}
+	state, err := decodeSnapshotFile(opts, modeSnapshot, fileData)
+	if err != nil {
+		return err
+	}
+	if opts, err = opts.rekeyed(to); err != nil {
+		return err
+	}
+	data, _, err := encodeSnapshot(opts, state.request(), nil)
+	if err != nil {
+		return err
+	}
+	return atomic.WriteFile(path, data, 0600)
+}
+
+// Rekey rewrites the ledger encrypted as set by to, keeping its history. As
+// in a compaction, the entries are linked into a new hash chain and signed
+// with the current Signer. It must not run concurrently with Persist,
+// PersistBatch, or a compaction.
+func (l *Ledger) Rekey(to Options) error {
+	if l.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := l.opts.checkRewrite(); err != nil {
+		return err
+	}
+	opts, err := l.opts.rekeyed(to)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.OpenFile(l.opts.Path+".rekey", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create rekeyed ledger: %w", err)
+	}
+	w := &Ledger{opts: opts, file: tmp, hybrid: l.hybrid}
+	err = w.copyEntries(l, l.start, l.size)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		if err = l.locks.lockData(); err == nil {
+			defer l.locks.unlockData()
+			err = atomic.Replace(tmp.Name(), l.opts.Path)
+		}
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to rekey ledger: %w", err)
+	}
+
+	l.file.Close()
+	l.file = tmp
+	l.opts = w.opts
+	l.size = w.size
+	l.entries = w.entries
+	l.chain = w.chain
+	l.start = w.start
+	l.meta = l.size == 0 && l.opts.codec() != DefaultCodec
+	return nil
+}
+
+// Rekey writes a checkpoint of state, which must be the storer's state,
+// and starts a new log, both encrypted as set by to. If the process stops
+// between replacing the two, the old log is left with no entries the
+// checkpoint lacks, and loading discards it.
+func (h *Hybrid) Rekey(to Options, state *State) error {
+	if h.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := h.opts.checkRewrite(); err != nil {
+		return err
+	}
+	opts, err := h.opts.rekeyed(to)
+	if err != nil {
+		return err
+	}
+	if err := h.locks.lockData(); err != nil {
+		return err
+	}
+	defer h.locks.unlockData()
+
+	// The checkpoint holds the whole log, so the new log starts empty
+	if err := h.catchUp(); err != nil {
+		return err
+	}
+	prev := h.opts
+	h.opts = opts
+	info := checkpointInfo{Generation: h.generation + 1, WALOffset: h.wal.size}
+	data, _, err := encodeSnapshot(h.opts, state.request(), &info)
+	if err == nil {
+		err = atomic.WriteFile(h.opts.Path, data, 0600)
+	}
+	if err != nil {
+		h.opts = prev
+		return fmt.Errorf("failed to write rekeyed checkpoint: %w", err)
+	}
+	h.generation, h.offset = info.Generation, info.WALOffset
+	h.checkpointSize = int64(len(data))
+
+	// Until the log is replaced, appends retry it rather than use the old key
+	return h.catchUp()
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLedgerRekey(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16)
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db"), EncryptionKey: oldKey, Codec: "msgpack", MerkleInterval: 2}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	l.LoadState()
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte{1}})
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte{2}})
+	l.PersistBatch([]PersistRequest{{Op: OpSet, Key: "b", Value: []byte{3}}, {Op: OpDelete, Key: "a"}})
+
+	if err := l.Rekey(Options{EncryptionKey: newKey}); err != nil {
+		t.Fatalf("Rekey() failed: %v", err)
+	}
+	l.Persist(PersistRequest{Op: OpSet, Key: "c", Value: []byte{4}})
+	l.Close()
+
+	if _, err := os.Stat(opts.Path + ".rekey"); !os.IsNotExist(err) {
+		t.Error("expected the rekeyed file to be renamed into place")
+	}
+	opts.EncryptionKey = newKey
+	l, err = NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	defer l.Close()
+	state, err := l.LoadState()
+	if err != nil || len(state.Data) != 2 || state.Data["b"][0] != 3 || state.Codec != "msgpack" {
+		t.Fatalf("unexpected state after rekey: %v (err=%v)", state, err)
+	}
+
+	// The history is kept, under a new hash chain
+	var seqs []uint64
+	l.History().Records(func(r Record) bool {
+		seqs = append(seqs, r.Seq)
+		return true
+	})
+	if len(seqs) != 5 || seqs[4] != 5 {
+		t.Errorf("expected 5 operations in the history, got %v", seqs)
+	}
+	if report, err := l.History().VerifyChain(); err != nil || report.Roots == 0 {
+		t.Errorf("expected an intact chain, got %+v (err=%v)", report, err)
+	}
+
+	opts.EncryptionKey, opts.ReadOnly = oldKey, true
+	old, _ := NewLedger(opts)
+	defer old.Close()
+	if _, err := old.LoadState(); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("expected ErrWrongKey for the old key, got %v", err)
+	}
+}
+
+func TestSnapshotRekey(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{Path: filepath.Join(dir, "test.db"), Passphrase: []byte("passphrase"), KDF: testKDF}
+	s, err := NewSnapshot(opts)
+	if err != nil {
+		t.Fatalf("NewSnapshot() failed: %v", err)
+	}
+	defer s.Close()
+	state := newState()
+	state.Data["a"] = []byte(`1`)
+	s.Persist(state.request())
+
+	// A backup written with the passphrase is rewritten too
+	backup := filepath.Join(dir, "test.db.bak.1")
+	data, _ := os.ReadFile(opts.Path)
+	os.WriteFile(backup, data, 0600)
+
+	newKey := bytes.Repeat([]byte{2}, 32)
+	if err := s.Rekey(Options{EncryptionKey: newKey}, state); err != nil {
+		t.Fatalf("Rekey() failed: %v", err)
+	}
+	if err := RekeyFile(backup, opts, Options{EncryptionKey: newKey}); err != nil {
+		t.Fatalf("RekeyFile() failed: %v", err)
+	}
+	for _, path := range []string{opts.Path, backup} {
+		s, _ := NewSnapshot(Options{Path: path, EncryptionKey: newKey, ReadOnly: true})
+		loaded, err := s.LoadState()
+		s.Close()
+		if err != nil || string(loaded.Data["a"]) != "1" {
+			t.Errorf("expected %s to load with the new key, got %v (err=%v)", path, loaded, err)
+		}
+	}
+	if _, err := NewSnapshot(opts); !errors.Is(err, ErrOptionsMismatch) {
+		t.Errorf("expected the passphrase to be rejected, got %v", err)
+	}
+}
+
+func TestHybridRekey(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db"), EncryptionKey: oldKey}
+	h, err := NewHybrid(opts)
+	if err != nil {
+		t.Fatalf("NewHybrid() failed: %v", err)
+	}
+	state, _ := h.LoadState()
+	h.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	state.Data["a"] = []byte(`1`)
+	oldLog, _ := os.ReadFile(WALPath(opts.Path))
+
+	if err := h.Rekey(Options{EncryptionKey: newKey}, state); err != nil {
+		t.Fatalf("Rekey() failed: %v", err)
+	}
+	h.Persist(PersistRequest{Op: OpSet, Key: "b", Value: []byte(`2`)})
+	h.Close()
+
+	load := func(opts Options) (*State, error) {
+		h, err := NewHybrid(opts)
+		if err != nil {
+			t.Fatalf("NewHybrid() failed: %v", err)
+		}
+		defer h.Close()
+		return h.LoadState()
+	}
+	opts.EncryptionKey = newKey
+	if state, err := load(opts); err != nil || len(state.Data) != 2 {
+		t.Fatalf("unexpected state after rekey: %v (err=%v)", state, err)
+	}
+
+	// A writer that stopped before replacing the log left the old one
+	os.WriteFile(WALPath(opts.Path), oldLog, 0600)
+	if state, err := load(opts); err != nil || len(state.Data) != 1 {
+		t.Fatalf("expected the checkpoint alone, got %v (err=%v)", state, err)
+	}
+	header, err := readPathHeader(WALPath(opts.Path))
+	if err != nil || header == nil || !bytes.Equal(header.KeyCheck, keyCheck(newKey)) {
+		t.Errorf("expected the log to be replaced under the new key, got %+v (err=%v)", header, err)
+	}
+
+	// A log with entries the checkpoint lacks is not discarded
+	os.WriteFile(WALPath(opts.Path), append(oldLog, oldLog[len(oldLog)-10:]...), 0600)
+	if _, err := load(opts); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("expected ErrWrongKey, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+)
+
+// With Options.Signer set, every ledger entry is signed with Ed25519 by
+// the writer. With Options.TrustedKeys set, loading requires every entry
+// to carry a valid signature from one of those keys, or from the Signer's
+// own key; anything else fails with a *SignatureError rather than being
+// treated as a torn write. Compactions and checkpoints re-sign the entries
+// they rewrite with the current Signer: the original signatures cover the
+// links of the old chain, so they cannot be carried over. Without a Signer,
+// rewriting a ledger whose signatures are required fails instead.
+
+var (
+	// ErrInvalidSignature is wrapped by the *SignatureError returned for a
+	// ledger entry that is not signed by a trusted key.
+	ErrInvalidSignature = errors.New("ledger entry signature is invalid")
+
+	// ErrSignerRequired is returned when compacting, checkpointing, or
+	// rekeying a ledger whose signatures are required without a Signer to
+	// sign the rewritten entries.
+	ErrSignerRequired = errors.New("rewriting a signed ledger requires a Signer")
+)
+
+// SignatureError describes a ledger entry that failed signature checks.
+type SignatureError struct {
+	Entry  int64             // Index of the entry, from 0
+	Offset int64             // Byte offset of the entry in the file
+	Seq    uint64            // Sequence number of the last operation before the entry
+	Signer ed25519.PublicKey // Key the entry claims to be signed with (nil if unsigned)
+	Reason string
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("entry %d at offset %d (after seq %d): %s", e.Entry, e.Offset, e.Seq, e.Reason)
+}
+
+func (e *SignatureError) Unwrap() error {
+	return ErrInvalidSignature
+}
+
+// entrySigned marks a signed entry body: the signer's public key and the
+// signature of the entry body that follows them.
+const entrySigned = 0x02
+
+// signEntry returns body signed with key.
+func signEntry(key ed25519.PrivateKey, body []byte) []byte {
+	out := make([]byte, 0, 1+ed25519.PublicKeySize+ed25519.SignatureSize+len(body))
+	out = append(out, entrySigned)
+	out = append(out, key.Public().(ed25519.PublicKey)...)
+	out = append(out, ed25519.Sign(key, body)...)
+	return append(out, body...)
+}
+
+// splitSigned returns the signer, signature, and inner body of a signed
+// entry body, reporting false if body is not signed.
+func splitSigned(body []byte) (signer ed25519.PublicKey, sig, inner []byte, ok bool) {
+	const size = 1 + ed25519.PublicKeySize + ed25519.SignatureSize
+	if len(body) < size || body[0] != entrySigned {
+		return nil, nil, body, false
+	}
+	signer = body[1 : 1+ed25519.PublicKeySize]
+	return signer, body[1+ed25519.PublicKeySize : size], body[size:], true
+}
+
+// signaturesRequired reports whether entries must be signed on load.
+func (o Options) signaturesRequired() bool {
+	return len(o.TrustedKeys) > 0
+}
+
+// checkRewrite returns ErrSignerRequired if entries rewritten with o would
+// be unsigned while signatures are required.
+func (o Options) checkRewrite() error {
+	if o.signaturesRequired() && o.Signer == nil {
+		return ErrSignerRequired
+	}
+	return nil
+}
+
+// trusted reports whether entries signed with key are trusted.
+func (o Options) trusted(key ed25519.PublicKey) bool {
+	if o.Signer != nil && bytes.Equal(key, o.Signer.Public().(ed25519.PublicKey)) {
+		return true
+	}
+	for _, k := range o.TrustedKeys {
+		if bytes.Equal(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSignature returns a *SignatureError if body, the entry at the given
+// position, is not signed by a trusted key while signatures are required.
+func (o Options) checkSignature(body []byte, entry, offset int64, seq uint64) error {
+	if !o.signaturesRequired() {
+		return nil
+	}
+	signer, sig, inner, ok := splitSigned(body)
+	reason := ""
+	switch {
+	case !ok:
+		reason = "entry is not signed"
+	case !o.trusted(signer):
+		reason = fmt.Sprintf("entry is signed by untrusted key %x", []byte(signer))
+	case !ed25519.Verify(signer, inner, sig):
+		reason = "signature does not match the entry"
+	default:
+		return nil
+	}
+	return &SignatureError{Entry: entry, Offset: offset, Seq: seq, Signer: signer, Reason: reason}
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/evertonmj/codex/codex/app/src/compression"
+)
+
+func TestLedgerSignatures(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	otherPub, otherPriv, _ := ed25519.GenerateKey(nil)
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	l, err := NewLedger(Options{Path: path, Signer: priv, Compression: compression.Gzip})
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	l.PersistBatch([]PersistRequest{
+		{Op: OpSet, Key: "b", Value: []byte(`2`)},
+		{Op: OpDelete, Key: "a"},
+	})
+	l.Close()
+
+	load := func(opts Options) (*State, error) {
+		opts.Path, opts.Compression, opts.ReadOnly = path, compression.Gzip, true
+		l, err := NewLedger(opts)
+		if err != nil {
+			t.Fatalf("NewLedger() failed: %v", err)
+		}
+		defer l.Close()
+		return l.LoadState()
+	}
+
+	state, err := load(Options{TrustedKeys: []ed25519.PublicKey{otherPub, pub}})
+	if err != nil || len(state.Data) != 1 {
+		t.Fatalf("expected the signed ledger to load, got %v (err=%v)", state, err)
+	}
+	// Signatures are only checked when keys are trusted
+	if _, err := load(Options{}); err != nil {
+		t.Errorf("expected the signed ledger to load unchecked, got %v", err)
+	}
+
+	var sigErr *SignatureError
+	_, err = load(Options{Signer: otherPriv, TrustedKeys: []ed25519.PublicKey{otherPub}})
+	if !errors.As(err, &sigErr) || !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected a SignatureError for an untrusted signer, got %v", err)
+	}
+	if sigErr.Entry != 0 || sigErr.Offset == 0 || !sigErr.Signer.Equal(pub) {
+		t.Errorf("unexpected signature error: %+v", sigErr)
+	}
+}
+
+func TestLedgerSignatureTampering(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db"), Signer: priv, TrustedKeys: []ed25519.PublicKey{pub}}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	l.Persist(PersistRequest{Op: OpSet, Key: "b", Value: []byte(`2`)})
+	l.Close()
+
+	// Rewrite the second value and fix up its checksum
+	data, _ := os.ReadFile(opts.Path)
+	header, frames := ledgerFrames(t, data)
+	body := frames[1][36:]
+	body[len(body)-1] = '9'
+	sum := sha256.Sum256(body)
+	copy(frames[1][4:36], sum[:])
+	os.WriteFile(opts.Path, data, 0600)
+
+	l, err = NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	defer l.Close()
+	_, err = l.LoadState()
+	var sigErr *SignatureError
+	if !errors.As(err, &sigErr) || sigErr.Entry != 1 || sigErr.Offset != int64(len(header)+len(frames[0])) || sigErr.Seq != 1 {
+		t.Fatalf("expected a SignatureError at entry 1, got %v", err)
+	}
+
+	// The entry is reported, not truncated away as a torn write
+	if info, _ := os.Stat(opts.Path); info.Size() != int64(len(data)) {
+		t.Errorf("expected the ledger to be left as it was, got %d bytes", info.Size())
+	}
+	h := &History{r: &Ledger{opts: opts, file: l.file, start: int64(len(header))}, size: int64(len(data))}
+	if _, err := h.VerifyChain(); !errors.As(err, &sigErr) {
+		t.Errorf("expected VerifyChain to report the signature, got %v", err)
+	}
+}
+
+func TestLedgerSignatureRequired(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db")}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	l.Close()
+
+	opts.Signer, opts.TrustedKeys = priv, []ed25519.PublicKey{pub}
+	l, err = NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	defer l.Close()
+	var sigErr *SignatureError
+	if _, err := l.LoadState(); !errors.As(err, &sigErr) || sigErr.Signer != nil {
+		t.Errorf("expected a SignatureError for an unsigned entry, got %v", err)
+	}
+}
+
+func TestCompactionSignatures(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db"), Signer: priv, TrustedKeys: []ed25519.PublicKey{pub}}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	defer l.Close()
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`2`)})
+
+	state, _ := l.LoadState()
+	c, _ := l.StartCompaction(state)
+	c.Write()
+	l.Persist(PersistRequest{Op: OpSet, Key: "b", Value: []byte(`3`)})
+	if err := c.Finish(); err != nil {
+		t.Fatalf("Finish() failed: %v", err)
+	}
+
+	state, err = l.LoadState()
+	if err != nil || string(state.Data["a"]) != "2" || string(state.Data["b"]) != "3" {
+		t.Errorf("unexpected state after compaction: %v (err=%v)", state, err)
+	}
+}
+
+func TestRewriteRequiresSigner(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.db"), Signer: priv}
+	l, err := NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	l.Persist(PersistRequest{Op: OpSet, Key: "a", Value: []byte(`1`)})
+	l.Close()
+
+	opts.Signer, opts.TrustedKeys = nil, []ed25519.PublicKey{pub}
+	l, err = NewLedger(opts)
+	if err != nil {
+		t.Fatalf("NewLedger() failed: %v", err)
+	}
+	defer l.Close()
+	state, err := l.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() failed: %v", err)
+	}
+	if _, err := l.StartCompaction(state); !errors.Is(err, ErrSignerRequired) {
+		t.Errorf("expected StartCompaction() to fail with ErrSignerRequired, got %v", err)
+	}
+	if err := l.Rekey(Options{EncryptionKey: make([]byte, 32)}); !errors.Is(err, ErrSignerRequired) {
+		t.Errorf("expected Rekey() to fail with ErrSignerRequired, got %v", err)
+	}
+	if _, err := l.LoadState(); err != nil {
+		t.Errorf("expected the ledger to stay verifiable, got %v", err)
+	}
+}
@@ -1,109 +1,389 @@
 package storage
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 
 	"github.com/evertonmj/codex/codex/app/src/atomic"
 	"github.com/evertonmj/codex/codex/app/src/compression"
 	"github.com/evertonmj/codex/codex/app/src/encryption"
-	"github.com/evertonmj/codex/codex/app/src/filelock"
 	"github.com/evertonmj/codex/codex/app/src/integrity"
 )
 
 // Snapshot implements the Storer interface for snapshot-based persistence.
 type Snapshot struct {
-	opts     Options
-	lockFile *os.File
+	opts  Options
+	locks *fileLocks
+	sum   []byte // Payload checksum of the snapshot last loaded or written
 }
 
-// NewSnapshot creates a new Snapshot storer with exclusive file locking.
+// NewSnapshot creates a new Snapshot storer. Writers lock the snapshot
+// exclusively; read-only storers share it with the writer and each other.
 func NewSnapshot(opts Options) (*Snapshot, error) {
-	// Create a lock file to prevent concurrent access
-	lockPath := opts.Path + ".lock"
-	lockFile, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	opts, err := DeriveKey(opts, opts.Path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open lock file: %w", err)
+		return nil, err
 	}
-
-	// Acquire exclusive lock to prevent concurrent writes from multiple processes
-	if err := filelock.Lock(lockFile); err != nil {
-		lockFile.Close()
+	locks, err := acquireLocks(opts)
+	if err != nil {
 		return nil, fmt.Errorf("failed to lock snapshot file: %w", err)
 	}
 
-	return &Snapshot{opts: opts, lockFile: lockFile}, nil
+	return &Snapshot{opts: opts, locks: locks}, nil
 }
 
-// Load reads, decompresses, decrypts, and verifies a data snapshot from disk.
+// Load reads a snapshot from disk and returns only its key-value data.
 func (s *Snapshot) Load() (map[string][]byte, error) {
+	state, err := s.LoadState()
+	if err != nil {
+		return nil, err
+	}
+	return state.Data, nil
+}
+
+// LoadState reads, decompresses, decrypts, and verifies a data snapshot from disk.
+func (s *Snapshot) LoadState() (*State, error) {
+	if err := s.locks.lockData(); err != nil {
+		return nil, err
+	}
 	fileData, err := os.ReadFile(s.opts.Path)
+	s.locks.unlockData()
 	if err != nil {
 		return nil, err // Return error to be checked by caller (e.g., for os.IsNotExist)
 	}
+	state, err := decodeSnapshotFile(s.opts, modeSnapshot, fileData)
+	if err != nil {
+		return nil, err
+	}
+	s.sum = state.sum
+	return state, nil
+}
+
+// decodeSnapshotFile checks the header of a snapshot file written in mode
+// against opts, then decrypts, decompresses, and verifies its contents and
+// decodes its payload. Files without a header are read with opts as is.
+func decodeSnapshotFile(opts Options, mode string, fileData []byte) (*State, error) {
+	header, size, err := readFileHeader(bytes.NewReader(fileData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+	if header != nil {
+		if opts, err = header.check(opts, mode); err != nil {
+			return nil, err
+		}
+		fileData = fileData[size:]
+	}
 
 	// Decrypt if a key is provided
-	if s.opts.EncryptionKey != nil {
-		fileData, err = encryption.Decrypt(fileData, s.opts.EncryptionKey)
+	if opts.EncryptionKey != nil {
+		fileData, err = encryption.Decrypt(fileData, opts.EncryptionKey)
 		if err != nil {
 			return nil, fmt.Errorf("failed to decrypt snapshot: %w", err)
 		}
 	}
 
 	// Decompress if compression is enabled
-	if s.opts.Compression != compression.None {
+	if opts.Compression != compression.None {
 		fileData, err = compression.Decompress(fileData)
 		if err != nil {
 			return nil, fmt.Errorf("failed to decompress snapshot: %w", err)
 		}
 	}
 
+	// Current snapshots are binary; older ones are signed JSON
+	if integrity.IsBinary(fileData) {
+		rawData, err := integrity.VerifyBinary(fileData)
+		if err != nil {
+			return nil, fmt.Errorf("integrity verification failed: %w", err)
+		}
+		state, err := decodeBinarySnapshot(rawData)
+		if err != nil {
+			return nil, err
+		}
+		state.sum = integrity.Checksum(fileData)
+		return state, nil
+	}
+
 	// Verify checksum and get raw data
 	rawData, err := integrity.Verify(fileData)
 	if err != nil {
 		return nil, fmt.Errorf("integrity verification failed: %w", err)
 	}
 
-	data := make(map[string][]byte)
-	if err := json.Unmarshal(rawData, &data); err != nil {
+	return decodeSnapshot(rawData)
+}
+
+// decodeSnapshot parses a JSON snapshot payload, accepting both the
+// versioned layout and the legacy bare key-value object.
+func decodeSnapshot(rawData []byte) (*State, error) {
+	// Legacy values are base64 strings, so they never decode as a format number
+	var payload snapshotPayload
+	if err := json.Unmarshal(rawData, &payload); err == nil && payload.Format != 0 {
+		// Later versions are binary and never reach this point
+		if payload.Format > 2 {
+			return nil, fmt.Errorf("unsupported snapshot format version %d", payload.Format)
+		}
+		return payload.state(), nil
+	}
+
+	state := newState()
+	state.Codec = DefaultCodec
+	if err := json.Unmarshal(rawData, &state.Data); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal snapshot data: %w", err)
 	}
+	defaultVersions(state)
+	return state, nil
+}
+
+// decodeBinarySnapshot parses a binary snapshot payload: a length-prefixed
+// JSON header, then the key-value records of the default keyspace and of
+// every bucket in name order.
+func decodeBinarySnapshot(rawData []byte) (*State, error) {
+	r := bytes.NewReader(rawData)
+	header, err := readChunk(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+	var payload snapshotPayload
+	if err := json.Unmarshal(header, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal snapshot header: %w", err)
+	}
+	if payload.Format < 3 || payload.Format > snapshotFormat {
+		return nil, fmt.Errorf("unsupported snapshot format version %d", payload.Format)
+	}
+
+	if payload.Data, err = readRecords(r); err != nil {
+		return nil, fmt.Errorf("failed to read snapshot data: %w", err)
+	}
+	for _, name := range sortedNames(payload.Buckets) {
+		b := payload.Buckets[name]
+		if b.Data, err = readRecords(r); err != nil {
+			return nil, fmt.Errorf("failed to read data of bucket %s: %w", name, err)
+		}
+		payload.Buckets[name] = b
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("failed to read snapshot data: %d trailing bytes", r.Len())
+	}
+
+	state := payload.state()
+	state.checkpoint = payload.Checkpoint
+	return state, nil
+}
+
+// state converts a decoded payload to a State.
+func (p *snapshotPayload) state() *State {
+	state := newState()
+	state.Codec = DefaultCodec
+	if p.Data != nil {
+		state.Data = p.Data
+	}
+	if p.Expiry != nil {
+		state.Expiry = p.Expiry
+	}
+	if p.Versions != nil {
+		state.Versions = p.Versions
+	}
+	state.Floor = p.Floor
+	if p.Indexes != nil {
+		state.Indexes = p.Indexes
+	}
+	if p.Codec != "" {
+		state.Codec = p.Codec
+	}
+	for name, pb := range p.Buckets {
+		b := state.bucket(name, true)
+		if pb.Data != nil {
+			b.Data = pb.Data
+		}
+		if pb.Expiry != nil {
+			b.Expiry = pb.Expiry
+		}
+		if pb.Versions != nil {
+			b.Versions = pb.Versions
+		}
+		b.Floor = pb.Floor
+	}
+
+	// Keys written before versions were tracked start at version 1
+	defaultVersions(state)
+	for _, b := range state.Buckets {
+		defaultVersions(b)
+	}
+
+	return state
+}
+
+// defaultVersions assigns version 1 to keys of st that have no version.
+func defaultVersions(st *State) {
+	for k := range st.Data {
+		if st.Versions[k] == 0 {
+			st.Versions[k] = 1
+		}
+	}
+}
+
+// sortedNames returns the keys of m in ascending order.
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeChunk appends a uvarint length followed by b.
+func writeChunk(buf *bytes.Buffer, b []byte) {
+	var lenBuf [binary.MaxVarintLen64]byte
+	buf.Write(lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(b)))])
+	buf.Write(b)
+}
+
+// readChunk reads a chunk written by writeChunk.
+func readChunk(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
 
+// writeRecords appends the record count followed by every key and value
+// of data, in key order.
+func writeRecords(buf *bytes.Buffer, data map[string][]byte) {
+	var lenBuf [binary.MaxVarintLen64]byte
+	buf.Write(lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(data)))])
+	for _, key := range sortedNames(data) {
+		writeChunk(buf, []byte(key))
+		writeChunk(buf, data[key])
+	}
+}
+
+// readRecords reads the key-value records written by writeRecords.
+func readRecords(r *bytes.Reader) (map[string][]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	// Every record takes at least two bytes
+	if n > uint64(r.Len())/2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := make(map[string][]byte, n)
+	for i := uint64(0); i < n; i++ {
+		key, err := readChunk(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readChunk(r)
+		if err != nil {
+			return nil, err
+		}
+		data[string(key)] = value
+	}
 	return data, nil
 }
 
 // Persist signs, compresses, encrypts, and writes a data snapshot to disk.
+// Values are written as raw bytes after a JSON header holding everything else.
 func (s *Snapshot) Persist(req PersistRequest) error {
-	storeData, err := json.Marshal(req.Data)
+	if s.opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	signedData, sum, err := encodeSnapshot(s.opts, req, nil)
 	if err != nil {
-		return fmt.Errorf("failed to marshal data for snapshot: %w", err)
+		return err
 	}
 
-	// Sign the data to get the checksummed file format
-	signedData, err := integrity.Sign(storeData)
+	// Use atomic write to prevent corruption
+	if err := s.locks.lockData(); err != nil {
+		return err
+	}
+	defer s.locks.unlockData()
+	if err := atomic.WriteFile(s.opts.Path, signedData, 0600); err != nil {
+		return err
+	}
+	s.sum = sum
+	return nil
+}
+
+// encodeSnapshot returns the contents of a snapshot file holding the state
+// in req: the file header, then the signed, compressed, and encrypted
+// payload. It also returns the payload checksum. Checkpoints of a hybrid
+// storer also record their position in the write-ahead log.
+func encodeSnapshot(opts Options, req PersistRequest, checkpoint *checkpointInfo) ([]byte, []byte, error) {
+	payload := snapshotPayload{
+		Format:     snapshotFormat,
+		Expiry:     req.Expiry,
+		Versions:   req.Versions,
+		Floor:      req.Floor,
+		Indexes:    req.Indexes,
+		Checkpoint: checkpoint,
+	}
+	if codec := opts.codec(); codec != DefaultCodec {
+		payload.Codec = codec
+	}
+	if len(req.Buckets) > 0 {
+		payload.Buckets = make(map[string]snapshotBucket, len(req.Buckets))
+		for name, b := range req.Buckets {
+			payload.Buckets[name] = snapshotBucket{Expiry: b.Expiry, Versions: b.Versions, Floor: b.Floor}
+		}
+	}
+	header, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("failed to sign snapshot: %w", err)
+		return nil, nil, fmt.Errorf("failed to marshal data for snapshot: %w", err)
 	}
 
+	size := len(header)
+	for k, v := range req.Data {
+		size += len(k) + len(v) + 4
+	}
+	var buf bytes.Buffer
+	buf.Grow(size)
+	writeChunk(&buf, header)
+	writeRecords(&buf, req.Data)
+	for _, name := range sortedNames(req.Buckets) {
+		writeRecords(&buf, req.Buckets[name].Data)
+	}
+
+	// Sign the data to get the checksummed file format
+	signedData := integrity.SignBinary(buf.Bytes())
+	sum := integrity.Checksum(signedData)
+
 	// Compress if compression is enabled
-	if s.opts.Compression != compression.None {
-		signedData, err = compression.Compress(signedData, s.opts.Compression, s.opts.CompressionLevel)
+	if opts.Compression != compression.None {
+		signedData, err = compression.Compress(signedData, opts.Compression, opts.CompressionLevel)
 		if err != nil {
-			return fmt.Errorf("failed to compress snapshot: %w", err)
+			return nil, nil, fmt.Errorf("failed to compress snapshot: %w", err)
 		}
 	}
 
 	// Encrypt if a key is provided
-	if s.opts.EncryptionKey != nil {
-		signedData, err = encryption.Encrypt(signedData, s.opts.EncryptionKey)
+	if opts.EncryptionKey != nil {
+		signedData, err = encryption.Encrypt(signedData, opts.EncryptionKey)
 		if err != nil {
-			return fmt.Errorf("failed to encrypt snapshot: %w", err)
+			return nil, nil, fmt.Errorf("failed to encrypt snapshot: %w", err)
 		}
 	}
 
-	// Use atomic write to prevent corruption
-	return atomic.WriteFile(s.opts.Path, signedData, 0600)
+	mode := modeSnapshot
+	if checkpoint != nil {
+		mode = modeHybrid
+	}
+	return append(newFileHeader(opts, mode).encode(), signedData...), sum, nil
 }
 
 // PersistBatch persists multiple operations atomically
@@ -114,27 +394,39 @@ func (s *Snapshot) PersistBatch(reqs []PersistRequest) error {
 
 	// For snapshot mode, we expect the last request to have the complete data
 	// All batch operations should result in a final data map
-	var finalData map[string][]byte
-	for _, req := range reqs {
-		if req.Data != nil {
-			finalData = req.Data
+	var final *PersistRequest
+	for i := range reqs {
+		if reqs[i].Data != nil {
+			final = &reqs[i]
 		}
 	}
 
-	if finalData == nil {
+	if final == nil {
 		return fmt.Errorf("batch persist requires final data map")
 	}
 
 	// Use the regular Persist method with the final data
-	return s.Persist(PersistRequest{Data: finalData})
+	return s.Persist(PersistRequest{Data: final.Data, Expiry: final.Expiry, Versions: final.Versions, Floor: final.Floor, Buckets: final.Buckets, Indexes: final.Indexes})
+}
+
+// Sync does nothing: snapshots are durable once Persist returns.
+func (s *Snapshot) Sync() error {
+	return nil
+}
+
+// Stamp identifies the snapshot last loaded or written by its payload
+// checksum. It is empty for snapshots in older formats.
+func (s *Snapshot) Stamp() string {
+	if s.sum == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.sum)
 }
 
-// Close releases the file lock and closes the lock file.
+// Close releases the file locks and closes the lock files.
 func (s *Snapshot) Close() error {
-	if s.lockFile != nil {
-		// Release the lock before closing
-		filelock.Unlock(s.lockFile) // Ignore error as file is being closed anyway
-		return s.lockFile.Close()
+	if s.locks != nil {
+		return s.locks.release()
 	}
 	return nil
 }
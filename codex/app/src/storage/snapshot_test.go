@@ -1,10 +1,15 @@
 package storage
 
 import (
+	"bytes"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/evertonmj/codex/codex/app/src/integrity"
 )
 
 func TestSnapshot(t *testing.T) {
@@ -66,3 +71,178 @@ func TestSnapshot(t *testing.T) {
 	// Clean up the file for the next test run if needed, although tempDir handles it.
 	os.Remove(storePath)
 }
+
+func TestSnapshotExpiry(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+
+	s, err := NewSnapshot(Options{Path: storePath})
+	if err != nil {
+		t.Fatalf("NewSnapshot() failed: %v", err)
+	}
+	defer s.Close()
+
+	req := PersistRequest{
+		Data:   map[string][]byte{"session": []byte(`"s"`), "config": []byte(`"c"`)},
+		Expiry: map[string]int64{"session": 1000},
+	}
+	if err := s.Persist(req); err != nil {
+		t.Fatalf("Persist() failed: %v", err)
+	}
+
+	state, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() failed: %v", err)
+	}
+	if !reflect.DeepEqual(req.Data, state.Data) || !reflect.DeepEqual(req.Expiry, state.Expiry) {
+		t.Errorf("mismatch: expected %v/%v, got %v/%v", req.Data, req.Expiry, state.Data, state.Expiry)
+	}
+}
+
+func TestSnapshotLegacyFormat(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+
+	// A snapshot written before the versioned payload was introduced
+	legacy := []byte(`{"format":"ImxlZ2FjeSI=","key1":"InZhbHVlMSI="}`)
+	if err := os.WriteFile(storePath, legacy, 0600); err != nil {
+		t.Fatalf("failed to write legacy snapshot: %v", err)
+	}
+
+	s, err := NewSnapshot(Options{Path: storePath})
+	if err != nil {
+		t.Fatalf("NewSnapshot() failed: %v", err)
+	}
+	defer s.Close()
+
+	data, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if string(data["key1"]) != `"value1"` || string(data["format"]) != `"legacy"` {
+		t.Errorf("unexpected legacy data: %v", data)
+	}
+}
+
+func TestSnapshotCodec(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+
+	s, err := NewSnapshot(Options{Path: storePath, Codec: "msgpack"})
+	if err != nil {
+		t.Fatalf("NewSnapshot() failed: %v", err)
+	}
+	if err := s.Persist(PersistRequest{Data: map[string][]byte{"key1": {0xa1, 'x'}}}); err != nil {
+		t.Fatalf("Persist() failed: %v", err)
+	}
+	state, err := s.LoadState()
+	if err != nil || state.Codec != "msgpack" {
+		t.Errorf("expected codec msgpack, got %q (err=%v)", state.Codec, err)
+	}
+
+	// Snapshots written with the default codec do not record it
+	s.opts.Codec = ""
+	s.Persist(PersistRequest{Data: map[string][]byte{"key1": []byte(`"x"`)}})
+	raw, _ := os.ReadFile(storePath)
+	_, start, _ := readFileHeader(bytes.NewReader(raw))
+	if strings.Contains(string(raw[start:]), "codec") {
+		t.Error("expected default codec to be omitted")
+	}
+	if state, _ := s.LoadState(); state.Codec != DefaultCodec {
+		t.Errorf("expected default codec, got %q", state.Codec)
+	}
+	s.Close()
+}
+
+func TestSnapshotBinaryValues(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+
+	s, err := NewSnapshot(Options{Path: storePath})
+	if err != nil {
+		t.Fatalf("NewSnapshot() failed: %v", err)
+	}
+	defer s.Close()
+
+	blob := bytes.Repeat([]byte{0x00, 0xff, '"'}, 1000)
+	req := PersistRequest{
+		Data:     map[string][]byte{"blob": blob, "empty": {}},
+		Versions: map[string]uint64{"blob": 3, "empty": 1},
+		Buckets: map[string]*State{
+			"b": {Data: map[string][]byte{"k": []byte(`"v"`)}, Versions: map[string]uint64{"k": 2}},
+			"c": {Data: map[string][]byte{}},
+		},
+	}
+	if err := s.Persist(req); err != nil {
+		t.Fatalf("Persist() failed: %v", err)
+	}
+	info, _ := os.Stat(storePath)
+	if info.Size() > int64(len(blob))+300 {
+		t.Errorf("expected raw value to be stored without inflation, file is %d bytes", info.Size())
+	}
+
+	state, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() failed: %v", err)
+	}
+	if !bytes.Equal(state.Data["blob"], blob) || len(state.Data) != 2 || state.Versions["blob"] != 3 {
+		t.Errorf("unexpected default keyspace: %v", state.Versions)
+	}
+	if string(state.Buckets["b"].Data["k"]) != `"v"` || state.Buckets["b"].Versions["k"] != 2 || state.Buckets["c"] == nil {
+		t.Errorf("unexpected buckets: %v", state.Buckets)
+	}
+
+	// Snapshots written as JSON payloads still load
+	payload, _ := json.Marshal(snapshotPayload{Format: 2, Data: map[string][]byte{"k": []byte(`1`)}, Codec: "gob"})
+	signed, _ := integrity.Sign(payload)
+	os.WriteFile(storePath, signed, 0600)
+	if state, err := s.LoadState(); err != nil || string(state.Data["k"]) != "1" || state.Codec != "gob" {
+		t.Errorf("failed to load JSON snapshot: %v (err=%v)", state, err)
+	}
+
+	// Corruption of a binary snapshot is detected
+	s.Persist(req)
+	raw, _ := os.ReadFile(storePath)
+	raw[len(raw)-1] ^= 0xff
+	os.WriteFile(storePath, raw, 0600)
+	if _, err := s.LoadState(); err == nil {
+		t.Error("expected integrity error for corrupted snapshot")
+	}
+}
+
+func TestStamp(t *testing.T) {
+	open := map[string]func(Options) (Storer, error){
+		"ledger":   func(o Options) (Storer, error) { return NewLedger(o) },
+		"snapshot": func(o Options) (Storer, error) { return NewSnapshot(o) },
+		"hybrid":   func(o Options) (Storer, error) { return NewHybrid(o) },
+	}
+	for name, newStorer := range open {
+		t.Run(name, func(t *testing.T) {
+			opts := Options{Path: filepath.Join(t.TempDir(), "test.db")}
+			write := func(value string) string {
+				s, err := newStorer(opts)
+				if err != nil {
+					t.Fatalf("failed to open storer: %v", err)
+				}
+				defer s.Close()
+				s.LoadState()
+				data := map[string][]byte{"a": []byte(value)}
+				s.Persist(PersistRequest{Op: OpSet, Key: "a", Value: data["a"], Data: data})
+				return s.(Stamper).Stamp()
+			}
+			loaded := func() string {
+				s, err := newStorer(opts)
+				if err != nil {
+					t.Fatalf("failed to open storer: %v", err)
+				}
+				defer s.Close()
+				s.LoadState()
+				return s.(Stamper).Stamp()
+			}
+
+			first := write(`1`)
+			if first == "" || loaded() != first {
+				t.Errorf("expected the stamp %q to be the same when loaded, got %q", first, loaded())
+			}
+			if second := write(`2`); second == first || loaded() != second {
+				t.Errorf("expected a new stamp after a write, got %q and %q", first, second)
+			}
+		})
+	}
+}
@@ -4,17 +4,22 @@
 // Supported storage modes:
 //   - Snapshot: Full database copy on each write (fast reads, O(n) writes)
 //   - Ledger: Append-only log (O(1) writes, full replay on load)
+//   - Hybrid: Write-ahead log plus periodic checkpoints (O(1) writes,
+//     replay of only the log written since the last checkpoint)
 //
-// Both modes support optional encryption and compression before persistence.
+// All modes support optional encryption and compression before persistence.
 //
 // The Storer interface allows pluggable storage implementations while
 // maintaining consistent semantics across different persistence strategies.
 package storage
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
+	"time"
 
 	"github.com/evertonmj/codex/codex/app/src/compression"
+	"github.com/evertonmj/codex/codex/app/src/encryption"
 	"github.com/evertonmj/codex/codex/app/src/filelock"
 )
 
@@ -28,37 +33,197 @@ const (
 	OpSet PersistOp = iota
 	OpDelete
 	OpClear
+	OpExpire      // Changes the expiry deadline of an existing key
+	OpDropBucket  // Removes a bucket and all of its keys
+	OpCreateIndex // Defines a secondary index
+	OpDropIndex   // Removes a secondary index definition
+	OpMeta        // Records file-level settings such as the value codec
+	OpBatch       // Groups the operations of a batch, which replay all-or-nothing
 )
 
+// DefaultCodec is the ID of the value codec assumed for files that do not
+// record one.
+const DefaultCodec = "json"
+
+// IndexDef is the persisted definition of a secondary index.
+type IndexDef struct {
+	Name   string `json:"name"`
+	Prefix string `json:"prefix,omitempty"` // Only keys with this prefix are indexed
+	Path   string `json:"path"`             // JSON path of the indexed value field
+}
+
 // PersistRequest holds the data for a persistence operation.
 type PersistRequest struct {
-	Op    PersistOp
-	Key   string
-	Value []byte
-	Data  map[string][]byte // For snapshot
+	Op        PersistOp
+	Bucket    string // Bucket the operation applies to ("" = default keyspace)
+	Key       string
+	Value     []byte
+	ExpiresAt int64               // Expiry deadline in Unix nanoseconds (0 = never)
+	Version   uint64              // Version of the key after this operation (0 = previous + 1)
+	Data      map[string][]byte   // For snapshot
+	Expiry    map[string]int64    // For snapshot: expiry deadlines by key
+	Versions  map[string]uint64   // For snapshot: versions by key
+	Floor     uint64              // For snapshot: version floor of the default keyspace
+	Buckets   map[string]*State   // For snapshot: named buckets
+	Index     *IndexDef           // For OpCreateIndex and OpDropIndex
+	Indexes   map[string]IndexDef // For snapshot: secondary index definitions
+}
+
+// State is the complete persisted state of a store.
+// The top-level maps hold the default keyspace; named buckets live in
+// Buckets and never have nested buckets of their own.
+type State struct {
+	Data     map[string][]byte
+	Expiry   map[string]int64    // Expiry deadlines in Unix nanoseconds by key
+	Versions map[string]uint64   // Version of every key in Data
+	Floor    uint64              // Highest version of a deleted key; new keys start above it
+	Buckets  map[string]*State   // Named buckets by name
+	Indexes  map[string]IndexDef // Secondary index definitions by name
+	Codec    string              // ID of the value codec ("" if the file is empty)
+
+	checkpoint *checkpointInfo // Set for checkpoints of a hybrid storer
+	sum        []byte          // Payload checksum of a snapshot file (nil for older formats)
+}
+
+// newState returns an empty State with all maps allocated.
+func newState() *State {
+	return &State{
+		Data:     make(map[string][]byte),
+		Expiry:   make(map[string]int64),
+		Versions: make(map[string]uint64),
+		Buckets:  make(map[string]*State),
+		Indexes:  make(map[string]IndexDef),
+	}
+}
+
+// bucket returns the keyspace named name, creating it if create is set,
+// or nil if it does not exist. The empty name refers to st itself.
+func (st *State) bucket(name string, create bool) *State {
+	if name == "" {
+		return st
+	}
+	b, ok := st.Buckets[name]
+	if !ok && create {
+		b = newState()
+		b.Buckets, b.Indexes = nil, nil
+		st.Buckets[name] = b
+	}
+	return b
+}
+
+// request returns a request that persists st as a snapshot.
+func (st *State) request() PersistRequest {
+	return PersistRequest{
+		Data:     st.Data,
+		Expiry:   st.Expiry,
+		Versions: st.Versions,
+		Floor:    st.Floor,
+		Buckets:  st.Buckets,
+		Indexes:  st.Indexes,
+	}
 }
 
 // Storer defines the interface for a persistence strategy.
 type Storer interface {
-	Load() (map[string][]byte, error)
+	LoadState() (*State, error)
 	Persist(req PersistRequest) error
 	PersistBatch(reqs []PersistRequest) error
+	Sync() error // Makes writes persisted with Options.DeferSync durable
 	Close() error
 }
 
+// Stamper is implemented by storers that can identify the data on disk
+// without reading it. A stamp changes with every write and is the same
+// whenever unchanged files are loaded again, so files derived from the
+// data can record it to tell whether they are current.
+type Stamper interface {
+	Stamp() string
+}
+
 // Options holds configuration for a storage strategy.
 type Options struct {
 	Path             string
 	EncryptionKey    []byte
 	Compression      compression.Algorithm
 	CompressionLevel int
+	Codec            string        // ID of the value codec, recorded in the file ("" = DefaultCodec)
+	ReadOnly         bool          // Share the file with a writer and other readers; Persist fails
+	LockTimeout      time.Duration // How long to wait for another writer to release the file (0 = fail at once)
+	DeferSync        bool          // Ledger and hybrid only: Persist skips fsync, leaving durability to Sync
+	MerkleInterval   int           // Ledger and hybrid only: entries between Merkle roots of the hash chain (0 = none)
+
+	// Ledger and hybrid only: Signer signs every entry written, and loading
+	// requires entries signed by one of TrustedKeys or the Signer's own key
+	// (no TrustedKeys = not checked)
+	Signer      ed25519.PrivateKey
+	TrustedKeys []ed25519.PublicKey
+
+	// Passphrase, instead of EncryptionKey, encrypts with a key derived
+	// from it with KDF (see DeriveKey)
+	Passphrase []byte
+	KDF        encryption.KDFParams
+	kdf        *fileKDF // How EncryptionKey was derived from Passphrase
+}
+
+// codec returns the ID of the value codec, applying the default.
+func (o Options) codec() string {
+	if o.Codec == "" {
+		return DefaultCodec
+	}
+	return o.Codec
 }
 
 // ledgerEntry represents a single operation in the ledger.
 type ledgerEntry struct {
-	Op    PersistOp       `json:"op"`
-	Key   string          `json:"key,omitempty"`
-	Value json.RawMessage `json:"value,omitempty"`
+	Op        PersistOp       `json:"op"`
+	Bucket    string          `json:"bucket,omitempty"`
+	Key       string          `json:"key,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"` // Only in entries of the JSON layout, for JSON values
+	Raw       []byte          `json:"raw,omitempty"`   // Only in entries of the JSON layout, for other values
+	ExpiresAt int64           `json:"expires_at,omitempty"`
+	Version   uint64          `json:"version,omitempty"`
+	Index     *IndexDef       `json:"index,omitempty"`
+	Codec     string          `json:"codec,omitempty"` // For OpMeta
+	Floor     uint64          `json:"floor,omitempty"` // For OpMeta: version floor of Bucket after a compaction
+	Seq       uint64          `json:"seq,omitempty"`   // Position in the ledger's history; for OpMeta, where a compacted history starts
+	Time      int64           `json:"time,omitempty"`  // When the operation was written, in Unix nanoseconds
+
+	// For OpMeta: generation of the checkpoint a write-ahead log applies to
+	Checkpoint uint64 `json:"checkpoint,omitempty"`
+
+	Prev []byte `json:"prev,omitempty"` // Hash of the entry before this one (nil for the first)
+	Root []byte `json:"root,omitempty"` // For OpMeta: Merkle root of the entries since the previous root
+
+	Batch []ledgerEntry `json:"-"` // For OpBatch: decoded from the value
+}
+
+// snapshotFormat is the current version of the snapshot payload layout.
+// Version 1 (implicit) was a bare JSON object of key to value. Version 2
+// embedded values in a JSON payload as base64. Version 3 is binary: a JSON
+// header followed by raw key-value records.
+const snapshotFormat = 3
+
+// snapshotPayload is the versioned data stored inside a snapshot file.
+// From version 3 on it is the header, and Data is always empty.
+type snapshotPayload struct {
+	Format   int                       `json:"format"`
+	Data     map[string][]byte         `json:"data,omitempty"`
+	Expiry   map[string]int64          `json:"expiry,omitempty"`
+	Versions map[string]uint64         `json:"versions,omitempty"`
+	Floor    uint64                    `json:"floor,omitempty"`
+	Buckets  map[string]snapshotBucket `json:"buckets,omitempty"`
+	Indexes  map[string]IndexDef       `json:"indexes,omitempty"`
+	Codec    string                    `json:"codec,omitempty"` // Omitted for DefaultCodec
+
+	Checkpoint *checkpointInfo `json:"checkpoint,omitempty"` // Only in checkpoints of a hybrid storer
+}
+
+// snapshotBucket is the persisted contents of a named bucket.
+type snapshotBucket struct {
+	Data     map[string][]byte `json:"data,omitempty"`
+	Expiry   map[string]int64  `json:"expiry,omitempty"`
+	Versions map[string]uint64 `json:"versions,omitempty"`
+	Floor    uint64            `json:"floor,omitempty"`
 }
 
 // fileFormat represents the structure of the snapshot data file.
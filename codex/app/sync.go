@@ -0,0 +1,109 @@
+package app
+
+import (
+	"time"
+
+	"github.com/evertonmj/codex/codex/app/src/backup"
+	"github.com/evertonmj/codex/codex/app/src/storage"
+)
+
+// SyncPolicy controls when writes reach stable storage.
+//
+// Under SyncAlways every write is durable before it returns. The relaxed
+// policies return as soon as a write is in memory (and, in ledger and
+// hybrid mode, handed to the operating system): writes between two syncs
+// share a single fsync of the log and a single file rewrite in snapshot mode.
+// A crash loses the writes made since the last sync. Watchers are notified
+// once a write reaches the file: in ledger and hybrid mode when it returns,
+// before it is durable, and in snapshot mode only when the next sync
+// rewrites the snapshot. Until then each watcher holds at most its buffer
+// size of events, subject to its overflow policy.
+type SyncPolicy struct {
+	interval time.Duration // Background sync period (SyncInterval)
+	manual   bool          // Sync only on Flush and Close (SyncManual)
+}
+
+var (
+	// SyncAlways makes every write durable before it returns (the default).
+	SyncAlways = SyncPolicy{}
+
+	// SyncManual makes writes durable only when Flush or Close is called.
+	SyncManual = SyncPolicy{manual: true}
+)
+
+// SyncInterval makes writes durable in the background every d, and on
+// Flush and Close. A d of zero or less is SyncAlways.
+func SyncInterval(d time.Duration) SyncPolicy {
+	if d <= 0 {
+		return SyncAlways
+	}
+	return SyncPolicy{interval: d}
+}
+
+// deferred reports whether writes return before they are durable.
+func (p SyncPolicy) deferred() bool {
+	return p.manual || p.interval > 0
+}
+
+// Flush makes every write that has returned so far durable. Under
+// SyncAlways, writes are already durable and Flush does nothing.
+func (s *Store) Flush() error {
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+	return s.flushHeld()
+}
+
+// flushHeld syncs the ledger or log, or rewrites the snapshot once for every
+// write since the last flush. The caller must hold persistMu.
+func (s *Store) flushHeld() error {
+	if !s.dirty {
+		return nil
+	}
+
+	if s.options.appendOnly() {
+		if err := s.storer.Sync(); err != nil {
+			return err
+		}
+	} else {
+		var req storage.PersistRequest
+		s.mu.RLock()
+		s.snapshotLocked(&req)
+		s.mu.RUnlock()
+		if s.options.NumBackups > 0 {
+			if err := backup.Create(s.path, s.options.NumBackups); err != nil {
+				return err
+			}
+		}
+		if err := s.storer.Persist(req); err != nil {
+			return err
+		}
+	}
+
+	s.flushedHeld()
+	return nil
+}
+
+// flushedHeld records that every write so far is durable and delivers the
+// events held back until then. The caller must hold persistMu.
+func (s *Store) flushedHeld() {
+	s.dirty = false
+	s.releaseHeld()
+}
+
+// runSyncer periodically flushes pending writes until the store is closed.
+func (s *Store) runSyncer(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			// Errors are retried on the next tick; Close flushes once more
+			_ = s.Flush()
+		}
+	}
+}
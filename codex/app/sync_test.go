@@ -0,0 +1,236 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// onDisk opens a read-only view of path and reports whether key is stored.
+func onDisk(t *testing.T, path string, ledger bool, key string) bool {
+	t.Helper()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false
+	}
+	r, err := NewWithOptions(path, Options{LedgerMode: ledger, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open reader: %v", err)
+	}
+	defer r.Close()
+	return r.Has(key)
+}
+
+func TestSyncManual(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewWithOptions(path, Options{Sync: SyncManual})
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	// Concurrent writers share one snapshot rewrite
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := store.Set(fmt.Sprintf("key%d", i), i); err != nil {
+				t.Errorf("Set failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if onDisk(t, path, false, "key0") {
+		t.Fatal("expected no snapshot before Flush")
+	}
+
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if !onDisk(t, path, false, fmt.Sprintf("key%d", i)) {
+			t.Fatalf("expected key%d on disk after Flush", i)
+		}
+	}
+
+	// Close flushes writes made since the last Flush
+	store.Delete("key0")
+	if !onDisk(t, path, false, "key0") {
+		t.Fatal("expected the delete to wait for the next flush")
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if onDisk(t, path, false, "key0") {
+		t.Error("expected Close to flush the delete")
+	}
+}
+
+func TestSyncManualWatch(t *testing.T) {
+	store, err := NewWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{Sync: SyncManual})
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := store.Watch(ctx, "")
+
+	// Nothing is written before Flush, so watchers hear nothing either
+	store.Set("a", 1)
+	store.Delete("a")
+	select {
+	case ev := <-events:
+		t.Fatalf("expected no event before Flush, got %v %s", ev.Type, ev.Key)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	for _, want := range []EventType{EventSet, EventDelete} {
+		select {
+		case ev := <-events:
+			if ev.Type != want || ev.Key != "a" {
+				t.Errorf("expected %v a, got %v %s", want, ev.Type, ev.Key)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %v event after Flush", want)
+		}
+	}
+}
+
+// heldEvents returns the number of events held for the store's watchers.
+func heldEvents(s *Store) int {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	n := 0
+	for w := range s.watchers {
+		n += len(w.held)
+	}
+	return n
+}
+
+func TestSyncManualWatchHeldEvents(t *testing.T) {
+	store, err := NewWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{Sync: SyncManual, WatchBuffer: 4})
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	// Without watchers, nothing is held for the next flush
+	for i := 0; i < 1000; i++ {
+		store.Set("a", i)
+	}
+	if n := heldEvents(store); n != 0 {
+		t.Errorf("expected no held events without watchers, got %d", n)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := store.Watch(ctx, "")
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("expected no event for writes made before watching, got %v %s", ev.Type, ev.Key)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Held events are bounded by the watch buffer, dropping the oldest
+	for i := 0; i < 10; i++ {
+		store.Set("a", i)
+	}
+	if n := heldEvents(store); n != 4 {
+		t.Errorf("expected 4 held events, got %d", n)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	for i := 6; i < 10; i++ {
+		ev := <-events
+		var n int
+		json.Unmarshal(ev.NewValue, &n)
+		if n != i {
+			t.Errorf("expected value %d, got %d", i, n)
+		}
+		want := uint64(0)
+		if i == 6 {
+			want = 6
+		}
+		if ev.Dropped != want {
+			t.Errorf("expected %d dropped before value %d, got %d", want, i, ev.Dropped)
+		}
+	}
+}
+
+func TestSyncInterval(t *testing.T) {
+	for _, ledger := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ledger=%v", ledger), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			store, err := NewWithOptions(path, Options{LedgerMode: ledger, Sync: SyncInterval(20 * time.Millisecond)})
+			if err != nil {
+				t.Fatalf("failed to open store: %v", err)
+			}
+			defer store.Close()
+
+			store.Set("a", 1)
+			store.BatchSet(map[string]interface{}{"b": 2, "c": 3})
+
+			deadline := time.Now().Add(5 * time.Second)
+			for !onDisk(t, path, ledger, "c") {
+				if time.Now().After(deadline) {
+					t.Fatal("writes were not flushed in the background")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if err := store.Flush(); err != nil {
+				t.Errorf("Flush failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestSyncPolicyReload(t *testing.T) {
+	policies := map[string]SyncPolicy{
+		"always":   SyncAlways,
+		"interval": SyncInterval(time.Hour),
+		"manual":   SyncManual,
+		"zero":     SyncInterval(0),
+	}
+	for name, policy := range policies {
+		for _, ledger := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/ledger=%v", name, ledger), func(t *testing.T) {
+				path := filepath.Join(t.TempDir(), "test.db")
+				opts := Options{LedgerMode: ledger, Sync: policy, NumBackups: 2}
+				store, err := NewWithOptions(path, opts)
+				if err != nil {
+					t.Fatalf("failed to open store: %v", err)
+				}
+				store.Set("a", 1)
+				store.Bucket("b").Set("x", 2)
+				store.SetWithTTL("t", 3, time.Hour)
+				store.Delete("a")
+				if err := store.Close(); err != nil {
+					t.Fatalf("Close failed: %v", err)
+				}
+
+				store, err = NewWithOptions(path, opts)
+				if err != nil {
+					t.Fatalf("failed to reopen store: %v", err)
+				}
+				defer store.Close()
+				var x int
+				if store.Has("a") || !store.Has("t") || store.Bucket("b").Get("x", &x) != nil || x != 2 {
+					t.Error("unexpected data after reload")
+				}
+			})
+		}
+	}
+}
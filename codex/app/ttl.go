@@ -0,0 +1,191 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/evertonmj/codex/codex/app/src/storage"
+)
+
+// NoExpiry is returned by TTL for keys that never expire.
+const NoExpiry time.Duration = -1
+
+// SetWithTTL stores a value that expires after ttl.
+// Once expired, the key is no longer visible to Get, Has, Keys, or scans,
+// and the background reaper removes it from disk.
+func (s *Store) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return fmt.Errorf("invalid ttl %v: must be positive", ttl)
+	}
+
+	data, err := s.codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal value: %w", err)
+	}
+	expiresAt := time.Now().Add(ttl).UnixNano()
+
+	s.persistMu.Lock()
+	s.mu.Lock()
+	ev := s.putLocked(s.root, key, data)
+	s.root.expiry[key] = expiresAt
+	s.mu.Unlock()
+
+	return s.persistHeld(context.Background(), false, []storage.PersistRequest{{
+		Op:        storage.OpSet,
+		Key:       key,
+		Value:     data,
+		ExpiresAt: expiresAt,
+		Version:   ev.Version,
+	}}, ev)
+}
+
+// Expire sets a TTL on an existing key, replacing any previous deadline.
+// Returns ErrNotFound if the key does not exist.
+func (s *Store) Expire(key string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("invalid ttl %v: must be positive", ttl)
+	}
+	return s.setExpiry(key, time.Now().Add(ttl).UnixNano())
+}
+
+// Persist removes the TTL from a key so that it never expires.
+// Returns ErrNotFound if the key does not exist.
+func (s *Store) Persist(key string) error {
+	return s.setExpiry(key, 0)
+}
+
+// TTL returns the time remaining before key expires, or NoExpiry if the key
+// has no TTL. Returns ErrNotFound if the key does not exist.
+func (s *Store) TTL(key string) (time.Duration, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.now()
+	if _, exists := s.root.live(key, now); !exists {
+		return 0, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	expiresAt, ok := s.root.expiry[key]
+	if !ok {
+		return NoExpiry, nil
+	}
+	return time.Duration(expiresAt - now), nil
+}
+
+// setExpiry updates the expiry deadline of a live key (0 clears it).
+func (s *Store) setExpiry(key string, expiresAt int64) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	s.persistMu.Lock()
+	s.mu.Lock()
+	if _, exists := s.root.live(key, s.now()); !exists {
+		s.mu.Unlock()
+		s.persistMu.Unlock()
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if expiresAt == 0 {
+		delete(s.root.expiry, key)
+	} else {
+		s.root.expiry[key] = expiresAt
+	}
+	s.touchLocked(s.root, key)
+	s.mu.Unlock()
+
+	return s.persistHeld(context.Background(), false, []storage.PersistRequest{{
+		Op:        storage.OpExpire,
+		Key:       key,
+		ExpiresAt: expiresAt,
+	}})
+}
+
+// now returns the time against which expiry deadlines are checked: the
+// current time, or the time of a historical view.
+func (s *Store) now() int64 {
+	if s.asOf != 0 {
+		return s.asOf
+	}
+	return time.Now().UnixNano()
+}
+
+// live returns the value for key unless it is missing or expired. A nil
+// keyspace (a bucket that does not exist) has no keys.
+// The caller must hold s.mu.
+func (ks *keyspace) live(key string, now int64) ([]byte, bool) {
+	if ks == nil {
+		return nil, false
+	}
+	data, exists := ks.data[key]
+	if !exists {
+		return nil, false
+	}
+	if expiresAt, ok := ks.expiry[key]; ok && expiresAt <= now {
+		return nil, false
+	}
+	return data, true
+}
+
+// liveKeys filters keys expired at now out of keys, preserving order.
+// The caller must hold s.mu.
+func (ks *keyspace) liveKeys(keys []string, now int64) []string {
+	if len(ks.expiry) == 0 {
+		return keys
+	}
+	live := keys[:0]
+	for _, k := range keys {
+		if expiresAt, ok := ks.expiry[k]; !ok || expiresAt > now {
+			live = append(live, k)
+		}
+	}
+	return live
+}
+
+// runReaper periodically removes expired keys until the store is closed.
+func (s *Store) runReaper(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			// Errors are retried on the next tick; expired keys stay invisible meanwhile
+			_ = s.reapExpired()
+		}
+	}
+}
+
+// reapExpired deletes every expired key from memory and persists the deletions.
+func (s *Store) reapExpired() error {
+	now := time.Now().UnixNano()
+
+	s.persistMu.Lock()
+	s.mu.Lock()
+	var reqs []storage.PersistRequest
+	var events []Event
+	reap := func(ks *keyspace) {
+		for key, expiresAt := range ks.expiry {
+			if expiresAt <= now {
+				events = append(events, s.deleteLocked(ks, key, EventExpired)...)
+				reqs = append(reqs, storage.PersistRequest{Op: storage.OpDelete, Bucket: ks.name, Key: key})
+			}
+		}
+	}
+	reap(s.root)
+	for _, ks := range s.buckets {
+		reap(ks)
+	}
+	s.mu.Unlock()
+
+	if len(reqs) == 0 {
+		s.persistMu.Unlock()
+		return nil
+	}
+	return s.persistHeld(context.Background(), true, reqs, events...)
+}
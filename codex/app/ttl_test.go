@@ -0,0 +1,144 @@
+package app
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSetWithTTL(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts Options
+	}{
+		{"snapshot mode", Options{ReaperInterval: 20 * time.Millisecond}},
+		{"ledger mode", Options{LedgerMode: true, ReaperInterval: 20 * time.Millisecond}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storePath := filepath.Join(t.TempDir(), "test.db")
+
+			store, err := NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("NewWithOptions() failed: %v", err)
+			}
+
+			if err := store.SetWithTTL("session:short", "gone soon", 50*time.Millisecond); err != nil {
+				t.Fatalf("SetWithTTL() failed: %v", err)
+			}
+			if err := store.SetWithTTL("session:long", "still here", time.Hour); err != nil {
+				t.Fatalf("SetWithTTL() failed: %v", err)
+			}
+			store.Set("permanent", "forever")
+
+			if !store.Has("session:short") {
+				t.Fatal("expected key to be visible before expiry")
+			}
+
+			time.Sleep(100 * time.Millisecond)
+
+			var value string
+			if err := store.Get("session:short", &value); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound for expired key, got %v", err)
+			}
+			if store.Has("session:short") {
+				t.Error("expected Has() to be false for expired key")
+			}
+			if keys := store.Keys(); len(keys) != 2 {
+				t.Errorf("expected 2 live keys, got %v", keys)
+			}
+
+			// Give the reaper a chance to reclaim the key, then verify across a restart
+			time.Sleep(50 * time.Millisecond)
+			store.mu.RLock()
+			_, stillStored := store.root.data["session:short"]
+			store.mu.RUnlock()
+			if stillStored {
+				t.Error("expected reaper to remove expired key")
+			}
+			store.Close()
+
+			store, err = NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("reopen failed: %v", err)
+			}
+			defer store.Close()
+
+			ttl, err := store.TTL("session:long")
+			if err != nil {
+				t.Fatalf("TTL() failed: %v", err)
+			}
+			if ttl <= 0 || ttl > time.Hour {
+				t.Errorf("expected TTL to survive restart, got %v", ttl)
+			}
+
+			ttl, err = store.TTL("permanent")
+			if err != nil || ttl != NoExpiry {
+				t.Errorf("expected NoExpiry for permanent key, got %v (err=%v)", ttl, err)
+			}
+		})
+	}
+}
+
+func TestExpireAndPersist(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts Options
+	}{
+		{"snapshot mode", Options{}},
+		{"ledger mode", Options{LedgerMode: true}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storePath := filepath.Join(t.TempDir(), "test.db")
+
+			store, err := NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("NewWithOptions() failed: %v", err)
+			}
+
+			if err := store.Expire("missing", time.Minute); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound from Expire, got %v", err)
+			}
+
+			store.Set("a", 1)
+			store.Set("b", 2)
+			if err := store.Expire("a", time.Minute); err != nil {
+				t.Fatalf("Expire() failed: %v", err)
+			}
+			if err := store.SetWithTTL("b", 2, time.Minute); err != nil {
+				t.Fatalf("SetWithTTL() failed: %v", err)
+			}
+			if err := store.Persist("b"); err != nil {
+				t.Fatalf("Persist() failed: %v", err)
+			}
+			store.Close()
+
+			store, err = NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("reopen failed: %v", err)
+			}
+			defer store.Close()
+
+			if ttl, _ := store.TTL("a"); ttl <= 0 {
+				t.Errorf("expected positive TTL for a after restart, got %v", ttl)
+			}
+			if ttl, _ := store.TTL("b"); ttl != NoExpiry {
+				t.Errorf("expected NoExpiry for b after Persist, got %v", ttl)
+			}
+
+			// A plain Set clears any existing TTL
+			store.Set("a", 10)
+			if ttl, _ := store.TTL("a"); ttl != NoExpiry {
+				t.Errorf("expected Set to clear TTL, got %v", ttl)
+			}
+
+			if err := store.SetWithTTL("c", 3, 0); err == nil {
+				t.Error("expected error for non-positive ttl")
+			}
+		})
+	}
+}
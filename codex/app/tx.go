@@ -0,0 +1,212 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/evertonmj/codex/codex/app/src/batch"
+)
+
+// Tx is a transaction started by Store.Update or Store.View.
+//
+// Transactions use optimistic concurrency control: reads go straight to the
+// store and record the revision of every key observed, while writes are
+// buffered inside the transaction. At commit time the store verifies that no
+// key read by the transaction has changed since it was read; if one has,
+// the commit is rejected with ErrConflict and nothing is applied.
+//
+// A Tx is not safe for concurrent use and must not be used after the
+// function passed to Update or View returns.
+type Tx struct {
+	store    *Store
+	writable bool
+	closed   bool
+	reads    map[string]uint64  // Revision of each key when first read
+	writes   map[string]txWrite // Buffered writes by key
+	order    []string           // Keys in first-write order, for deterministic commits
+}
+
+// txWrite is a buffered set of value, or a delete.
+type txWrite struct {
+	value   []byte
+	deleted bool
+}
+
+// Update runs fn inside a read-write transaction.
+// If fn returns nil, the buffered writes are committed atomically through a
+// single batch persist. If fn returns an error, the writes are discarded and
+// the error is returned. Update returns ErrConflict when a key read by fn was
+// modified by another writer before commit; callers may simply retry. On a
+// read-only store, Update returns ErrReadOnly without calling fn.
+//
+//	err := store.Update(func(tx *codex.Tx) error {
+//	    var balance int
+//	    if err := tx.Get("balance", &balance); err != nil {
+//	        return err
+//	    }
+//	    return tx.Set("balance", balance+10)
+//	})
+func (s *Store) Update(fn func(tx *Tx) error) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	tx := s.newTx(true)
+	err := fn(tx)
+	tx.closed = true
+	if err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+// View runs fn inside a read-only transaction.
+// Writes inside fn return ErrTxReadOnly. View returns ErrConflict when a key
+// read by fn changed while fn was running, so a nil result guarantees that
+// fn observed a consistent view of every key it read.
+func (s *Store) View(fn func(tx *Tx) error) error {
+	tx := s.newTx(false)
+	err := fn(tx)
+	tx.closed = true
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return tx.validateLocked()
+}
+
+func (s *Store) newTx(writable bool) *Tx {
+	return &Tx{
+		store:    s,
+		writable: writable,
+		reads:    make(map[string]uint64),
+		writes:   make(map[string]txWrite),
+	}
+}
+
+// Get retrieves a value, seeing the transaction's own uncommitted writes.
+// Returns ErrNotFound if the key does not exist.
+func (tx *Tx) Get(key string, value interface{}) error {
+	data, exists, err := tx.lookup(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return tx.store.codec.Unmarshal(data, value)
+}
+
+// Has checks if a key exists, seeing the transaction's own uncommitted writes.
+func (tx *Tx) Has(key string) (bool, error) {
+	_, exists, err := tx.lookup(key)
+	return exists, err
+}
+
+// Set buffers a write of value under key.
+func (tx *Tx) Set(key string, value interface{}) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	data, err := tx.store.codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal value: %w", err)
+	}
+	tx.buffer(key, txWrite{value: data})
+	return nil
+}
+
+// Delete buffers the deletion of key.
+func (tx *Tx) Delete(key string) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	tx.buffer(key, txWrite{deleted: true})
+	return nil
+}
+
+func (tx *Tx) checkWritable() error {
+	if tx.closed {
+		return ErrTxClosed
+	}
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+	return nil
+}
+
+func (tx *Tx) buffer(key string, w txWrite) {
+	if _, seen := tx.writes[key]; !seen {
+		tx.order = append(tx.order, key)
+	}
+	tx.writes[key] = w
+}
+
+// lookup resolves key against buffered writes first, then the store,
+// recording the revision observed for conflict detection.
+func (tx *Tx) lookup(key string) ([]byte, bool, error) {
+	if tx.closed {
+		return nil, false, ErrTxClosed
+	}
+	if w, ok := tx.writes[key]; ok {
+		return w.value, !w.deleted, nil
+	}
+
+	s := tx.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, seen := tx.reads[key]; !seen {
+		tx.reads[key] = s.root.modRevOf(key)
+	}
+	data, exists := s.root.live(key, s.now())
+	return data, exists, nil
+}
+
+// validateLocked checks that no key read by the transaction has changed.
+// The caller must hold s.mu.
+func (tx *Tx) validateLocked() error {
+	for key, rev := range tx.reads {
+		if tx.store.root.modRevOf(key) != rev {
+			return fmt.Errorf("%w: %s", ErrConflict, key)
+		}
+	}
+	return nil
+}
+
+// commit validates the read set, applies the buffered writes, and persists
+// them as a single batch. Without writes, it only validates the reads.
+func (tx *Tx) commit() error {
+	s := tx.store
+	if len(tx.writes) == 0 {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return tx.validateLocked()
+	}
+
+	b := batch.New()
+	var events []Event
+
+	s.persistMu.Lock()
+	s.mu.Lock()
+	if err := tx.validateLocked(); err != nil {
+		s.mu.Unlock()
+		s.persistMu.Unlock()
+		return err
+	}
+	for _, key := range tx.order {
+		w := tx.writes[key]
+		if w.deleted {
+			events = append(events, s.deleteLocked(s.root, key, EventDelete)...)
+			b.Delete(key)
+		} else {
+			events = append(events, s.putLocked(s.root, key, w.value))
+			b.Set(key, w.value)
+		}
+	}
+	reqs := batchRequestsLocked(s.root, b)
+	s.mu.Unlock()
+
+	return s.persistHeld(context.Background(), true, reqs, events...)
+}
@@ -0,0 +1,281 @@
+package app
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts Options
+	}{
+		{"snapshot mode", Options{}},
+		{"ledger mode", Options{LedgerMode: true}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storePath := filepath.Join(t.TempDir(), "test.db")
+
+			store, err := NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("NewWithOptions() failed: %v", err)
+			}
+
+			store.Set("from", 100)
+			store.Set("obsolete", true)
+
+			err = store.Update(func(tx *Tx) error {
+				var from int
+				if err := tx.Get("from", &from); err != nil {
+					return err
+				}
+				if err := tx.Set("from", from-30); err != nil {
+					return err
+				}
+				if err := tx.Set("to", 30); err != nil {
+					return err
+				}
+				if err := tx.Delete("obsolete"); err != nil {
+					return err
+				}
+
+				// Reads see the transaction's own writes
+				var updated int
+				if err := tx.Get("from", &updated); err != nil || updated != 70 {
+					t.Errorf("expected own write 70, got %d (err=%v)", updated, err)
+				}
+				if ok, _ := tx.Has("obsolete"); ok {
+					t.Error("expected own delete to be visible")
+				}
+
+				// Nothing is visible outside the transaction before commit
+				if store.Has("to") {
+					t.Error("uncommitted write leaked outside the transaction")
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Update() failed: %v", err)
+			}
+			store.Close()
+
+			store, err = NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("reopen failed: %v", err)
+			}
+			defer store.Close()
+
+			var from, to int
+			store.Get("from", &from)
+			store.Get("to", &to)
+			if from != 70 || to != 30 || store.Has("obsolete") {
+				t.Errorf("unexpected state after commit: from=%d to=%d obsolete=%v", from, to, store.Has("obsolete"))
+			}
+		})
+	}
+}
+
+func TestUpdateRollback(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer store.Close()
+
+	abort := errors.New("abort")
+	err = store.Update(func(tx *Tx) error {
+		tx.Set("key", "value")
+		return abort
+	})
+	if !errors.Is(err, abort) {
+		t.Errorf("expected fn error to be returned, got %v", err)
+	}
+	if store.Has("key") {
+		t.Error("expected writes to be discarded when fn fails")
+	}
+}
+
+func TestUpdateConflict(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer store.Close()
+
+	store.Set("counter", 1)
+
+	err = store.Update(func(tx *Tx) error {
+		var n int
+		tx.Get("counter", &n)
+		// Another writer changes the key after it was read
+		store.Set("counter", 50)
+		return tx.Set("counter", n+1)
+	})
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+
+	var n int
+	store.Get("counter", &n)
+	if n != 50 {
+		t.Errorf("expected conflicting commit to be rejected, got counter=%d", n)
+	}
+
+	// Reading a missing key also detects a concurrent insert
+	err = store.Update(func(tx *Tx) error {
+		if ok, _ := tx.Has("lock"); ok {
+			return nil
+		}
+		store.Set("lock", "other")
+		return tx.Set("lock", "mine")
+	})
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for concurrent insert, got %v", err)
+	}
+
+	// A transaction that only reads is validated as well
+	err = store.Update(func(tx *Tx) error {
+		var n int
+		tx.Get("counter", &n)
+		store.Set("counter", n+1)
+		return nil
+	})
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for a read-only commit, got %v", err)
+	}
+}
+
+// emptyValue encodes to no bytes at all under RawCodec.
+type emptyValue struct{}
+
+func (emptyValue) MarshalBinary() ([]byte, error) { return nil, nil }
+
+func TestUpdateEmptyValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewWithOptions(path, Options{Codec: RawCodec, LedgerMode: true})
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	store.Set("a", "value")
+
+	// Setting a value with no bytes is not a delete
+	err = store.Update(func(tx *Tx) error {
+		if err := tx.Set("a", emptyValue{}); err != nil {
+			return err
+		}
+		if ok, _ := tx.Has("a"); !ok {
+			t.Error("expected the key to exist inside the transaction")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if data, err := store.GetBytes("a"); err != nil || len(data) != 0 {
+		t.Errorf("expected an empty value, got %q (err=%v)", data, err)
+	}
+	store.Close()
+
+	store, err = NewWithOptions(path, Options{Codec: RawCodec, LedgerMode: true})
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if !store.Has("a") {
+		t.Error("expected the empty value to be persisted")
+	}
+}
+
+func TestUpdateConcurrentIncrements(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer store.Close()
+
+	store.Set("counter", 0)
+
+	const workers = 8
+	const increments = 10
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				for {
+					err := store.Update(func(tx *Tx) error {
+						var n int
+						if err := tx.Get("counter", &n); err != nil {
+							return err
+						}
+						return tx.Set("counter", n+1)
+					})
+					if err == nil {
+						break
+					}
+					if !errors.Is(err, ErrConflict) {
+						t.Errorf("Update() failed: %v", err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var n int
+	store.Get("counter", &n)
+	if n != workers*increments {
+		t.Errorf("expected counter=%d, got %d", workers*increments, n)
+	}
+}
+
+func TestView(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer store.Close()
+
+	store.Set("a", 1)
+
+	err = store.View(func(tx *Tx) error {
+		var a int
+		if err := tx.Get("a", &a); err != nil {
+			return err
+		}
+		if err := tx.Set("a", 2); !errors.Is(err, ErrTxReadOnly) {
+			t.Errorf("expected ErrTxReadOnly, got %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View() failed: %v", err)
+	}
+
+	err = store.View(func(tx *Tx) error {
+		var a int
+		tx.Get("a", &a)
+		store.Set("a", 3)
+		return nil
+	})
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for inconsistent view, got %v", err)
+	}
+
+	var leaked *Tx
+	store.View(func(tx *Tx) error {
+		leaked = tx
+		return nil
+	})
+	var a int
+	if err := leaked.Get("a", &a); !errors.Is(err, ErrTxClosed) {
+		t.Errorf("expected ErrTxClosed, got %v", err)
+	}
+}
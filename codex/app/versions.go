@@ -0,0 +1,125 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/evertonmj/codex/codex/app/src/storage"
+)
+
+// Every key carries a version that increases by one on each write, and
+// is persisted in both storage modes, so it survives restarts. Deleting a
+// key raises its keyspace's version floor to the key's last version, and
+// new keys start one above the floor (at 1 if nothing was deleted), so a
+// version read before a delete never matches again while deleted keys
+// leave nothing behind. A version of 0 in a conditional write means "the
+// key does not exist".
+
+// Version returns the current version of key.
+// Returns ErrNotFound if the key does not exist.
+func (s *Store) Version(key string) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.root.live(key, s.now()); !exists {
+		return 0, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return s.root.versions[key], nil
+}
+
+// GetWithVersion retrieves a value together with its version, read atomically.
+// The version can be passed to SetIfVersion or DeleteIfVersion for a safe
+// read-modify-write. Returns ErrNotFound if the key does not exist.
+func (s *Store) GetWithVersion(key string, value interface{}) (uint64, error) {
+	s.mu.RLock()
+	data, exists := s.root.live(key, s.now())
+	version := s.root.versions[key]
+	s.mu.RUnlock()
+
+	if !exists {
+		return 0, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err := s.codec.Unmarshal(data, value); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// SetIfVersion stores value only if the key's current version equals
+// expectedVersion (0 requires the key to be absent). It returns the new
+// version, or ErrVersionMismatch if the key changed in the meantime.
+func (s *Store) SetIfVersion(key string, value interface{}, expectedVersion uint64) (uint64, error) {
+	if err := s.checkWritable(); err != nil {
+		return 0, err
+	}
+	data, err := s.codec.Marshal(value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal value: %w", err)
+	}
+
+	s.persistMu.Lock()
+	s.mu.Lock()
+	if current := s.currentVersionLocked(key); current != expectedVersion {
+		s.mu.Unlock()
+		s.persistMu.Unlock()
+		return 0, fmt.Errorf("%w: %s is at version %d, expected %d", ErrVersionMismatch, key, current, expectedVersion)
+	}
+	ev := s.putLocked(s.root, key, data)
+	s.mu.Unlock()
+
+	err = s.persistHeld(context.Background(), false, []storage.PersistRequest{{
+		Op:      storage.OpSet,
+		Key:     key,
+		Value:   data,
+		Version: ev.Version,
+	}}, ev)
+	if err != nil {
+		return 0, err
+	}
+	return ev.Version, nil
+}
+
+// SetIfAbsent stores value only if key does not exist.
+// Returns ErrVersionMismatch if the key is already present.
+func (s *Store) SetIfAbsent(key string, value interface{}) error {
+	_, err := s.SetIfVersion(key, value, 0)
+	return err
+}
+
+// DeleteIfVersion deletes key only if its current version equals
+// expectedVersion. Returns ErrNotFound if the key does not exist, and
+// ErrVersionMismatch if its version differs.
+func (s *Store) DeleteIfVersion(key string, expectedVersion uint64) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	s.persistMu.Lock()
+	s.mu.Lock()
+	current := s.currentVersionLocked(key)
+	if current == 0 {
+		s.mu.Unlock()
+		s.persistMu.Unlock()
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if current != expectedVersion {
+		s.mu.Unlock()
+		s.persistMu.Unlock()
+		return fmt.Errorf("%w: %s is at version %d, expected %d", ErrVersionMismatch, key, current, expectedVersion)
+	}
+	events := s.deleteLocked(s.root, key, EventDelete)
+	s.mu.Unlock()
+
+	return s.persistHeld(context.Background(), false, []storage.PersistRequest{{
+		Op:  storage.OpDelete,
+		Key: key,
+	}}, events...)
+}
+
+// currentVersionLocked returns the version of a live key, or 0 if it is
+// missing or expired, whatever version it last had. The caller must hold s.mu.
+func (s *Store) currentVersionLocked(key string) uint64 {
+	if _, exists := s.root.live(key, s.now()); !exists {
+		return 0
+	}
+	return s.root.versions[key]
+}
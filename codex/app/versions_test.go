@@ -0,0 +1,247 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/evertonmj/codex/codex/app/src/storage"
+)
+
+func TestVersions(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts Options
+	}{
+		{"snapshot mode", Options{}},
+		{"ledger mode", Options{LedgerMode: true}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storePath := filepath.Join(t.TempDir(), "test.db")
+
+			store, err := NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("NewWithOptions() failed: %v", err)
+			}
+
+			store.Set("config", "v1")
+			store.Set("config", "v2")
+			store.BatchSet(map[string]interface{}{"config": "v3", "other": 1})
+
+			if v, err := store.Version("config"); err != nil || v != 3 {
+				t.Errorf("expected version 3, got %d (err=%v)", v, err)
+			}
+			if _, err := store.Version("missing"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound, got %v", err)
+			}
+			store.Close()
+
+			store, err = NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("reopen failed: %v", err)
+			}
+			defer store.Close()
+
+			var value string
+			v, err := store.GetWithVersion("config", &value)
+			if err != nil || v != 3 || value != "v3" {
+				t.Fatalf("expected v3 at version 3 after restart, got %q at %d (err=%v)", value, v, err)
+			}
+
+			if _, err := store.SetIfVersion("config", "stale", 2); !errors.Is(err, ErrVersionMismatch) {
+				t.Errorf("expected ErrVersionMismatch, got %v", err)
+			}
+			next, err := store.SetIfVersion("config", "v4", 3)
+			if err != nil || next != 4 {
+				t.Errorf("expected version 4 after CAS, got %d (err=%v)", next, err)
+			}
+
+			if err := store.SetIfAbsent("config", "dup"); !errors.Is(err, ErrVersionMismatch) {
+				t.Errorf("expected ErrVersionMismatch for existing key, got %v", err)
+			}
+			if err := store.SetIfAbsent("fresh", "new"); err != nil {
+				t.Errorf("SetIfAbsent() failed: %v", err)
+			}
+			if v, _ := store.Version("fresh"); v != 1 {
+				t.Errorf("expected new key at version 1, got %d", v)
+			}
+
+			if err := store.DeleteIfVersion("config", 3); !errors.Is(err, ErrVersionMismatch) {
+				t.Errorf("expected ErrVersionMismatch for stale delete, got %v", err)
+			}
+			if err := store.DeleteIfVersion("config", 4); err != nil {
+				t.Errorf("DeleteIfVersion() failed: %v", err)
+			}
+			if store.Has("config") {
+				t.Error("expected key to be deleted")
+			}
+		})
+	}
+}
+
+func TestVersionsAfterDelete(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts Options
+	}{
+		{"snapshot mode", Options{}},
+		{"ledger mode", Options{LedgerMode: true}},
+		{"hybrid mode", Options{HybridMode: true}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storePath := filepath.Join(t.TempDir(), "test.db")
+			store, err := NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("NewWithOptions() failed: %v", err)
+			}
+
+			store.Set("config", "v1")
+			store.Set("config", "v2")
+			stale, _ := store.Version("config")
+			store.Delete("config")
+			store.Set("config", "v3")
+
+			// A version read before the delete must not match the new key
+			if _, err := store.SetIfVersion("config", "stale", stale); !errors.Is(err, ErrVersionMismatch) {
+				t.Errorf("expected ErrVersionMismatch for a pre-delete version, got %v", err)
+			}
+			if v, _ := store.Version("config"); v != 3 {
+				t.Errorf("expected the re-created key at version 3, got %d", v)
+			}
+			store.Delete("config")
+			store.Clear()
+			if err := store.Compact(); err != nil {
+				t.Fatalf("Compact() failed: %v", err)
+			}
+			store.Close()
+
+			store, err = NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("reopen failed: %v", err)
+			}
+			defer store.Close()
+			if v, err := store.SetIfVersion("config", "v4", 0); err != nil || v != 4 {
+				t.Errorf("expected version 4 after restart, got %d (err=%v)", v, err)
+			}
+		})
+	}
+}
+
+func TestDeletedKeysLeaveNoVersions(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts Options
+	}{
+		{"snapshot mode", Options{}},
+		{"ledger mode", Options{LedgerMode: true}},
+		{"hybrid mode", Options{HybridMode: true}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storePath := filepath.Join(t.TempDir(), "test.db")
+			store, err := NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("NewWithOptions() failed: %v", err)
+			}
+			store.Set("keep", 1)
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("temp%d", i)
+				store.Set(key, i)
+				store.Set(key, i)
+				store.Delete(key)
+			}
+			if err := store.Compact(); err != nil {
+				t.Fatalf("Compact() failed: %v", err)
+			}
+			store.Close()
+
+			store, err = NewWithOptions(storePath, tc.opts)
+			if err != nil {
+				t.Fatalf("reopen failed: %v", err)
+			}
+			defer store.Close()
+			// Each new key starts above the keys deleted before it
+			if n, floor := len(store.root.versions), store.root.floor; n != 1 || floor != 1000 {
+				t.Errorf("expected only the live key's version above floor 1000, got %d versions above %d", n, floor)
+			}
+			if ledger, ok := store.storer.(*storage.Ledger); ok && ledger.Stats().Entries > 3 {
+				// The history start, the live key, and the floor
+				t.Errorf("expected at most 3 entries after compaction, got %d", ledger.Stats().Entries)
+			}
+			if v, err := store.SetIfVersion("temp0", "again", 0); err != nil || v != 1001 {
+				t.Errorf("expected a re-created key at version 1001, got %d (err=%v)", v, err)
+			}
+		})
+	}
+}
+
+func TestDeleteIfVersionMissing(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewWithOptions(storePath, Options{LedgerMode: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions() failed: %v", err)
+	}
+	defer store.Close()
+
+	store.Set("other", 1)
+	size := fileSize(t, storePath)
+	for _, version := range []uint64{0, 1} {
+		if err := store.DeleteIfVersion("missing", version); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound at version %d, got %v", version, err)
+		}
+	}
+	if fileSize(t, storePath) != size {
+		t.Error("expected nothing to be written for a missing key")
+	}
+}
+
+func TestSetIfVersionConcurrent(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer store.Close()
+
+	store.Set("counter", 0)
+
+	const workers = 8
+	const increments = 10
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				for {
+					var n int
+					version, err := store.GetWithVersion("counter", &n)
+					if err != nil {
+						t.Errorf("GetWithVersion() failed: %v", err)
+						return
+					}
+					if _, err := store.SetIfVersion("counter", n+1, version); err == nil {
+						break
+					} else if !errors.Is(err, ErrVersionMismatch) {
+						t.Errorf("SetIfVersion() failed: %v", err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var n int
+	store.Get("counter", &n)
+	if n != workers*increments {
+		t.Errorf("expected counter=%d, got %d", workers*increments, n)
+	}
+}
@@ -0,0 +1,262 @@
+package app
+
+import (
+	"context"
+	"strings"
+)
+
+// EventType identifies the kind of change carried by an Event.
+type EventType int
+
+const (
+	// EventSet is emitted when a key is created or overwritten.
+	EventSet EventType = iota
+	// EventDelete is emitted when a key is deleted.
+	EventDelete
+	// EventExpired is emitted when the reaper removes a key whose TTL elapsed.
+	EventExpired
+	// EventClear is emitted when the whole store is cleared. Key is empty.
+	EventClear
+)
+
+// String returns the string representation of the event type.
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventExpired:
+		return "expired"
+	case EventClear:
+		return "clear"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a change that has been persisted to disk.
+// Values are encoded with the store's codec (JSON unless Options.Codec is set).
+type Event struct {
+	Type     EventType
+	Bucket   string // Bucket of the key ("" for the default keyspace)
+	Key      string
+	OldValue []byte // Previous value (nil if the key did not exist)
+	NewValue []byte // New value (nil for deletes)
+	Version  uint64 // Key version after the change (0 if the key no longer exists)
+	Dropped  uint64 // Events discarded for this watcher since the previous delivered event
+}
+
+// OverflowPolicy decides what happens when a watcher's buffer is full.
+// Writers never block on watchers, whatever the policy.
+type OverflowPolicy int
+
+const (
+	// OverflowDropOldest discards the oldest buffered event to make room (default).
+	OverflowDropOldest OverflowPolicy = iota
+	// OverflowDropNewest discards the incoming event.
+	OverflowDropNewest
+	// OverflowClose closes the watcher's channel; the consumer must watch again.
+	OverflowClose
+)
+
+// defaultWatchBuffer is the per-watcher buffer size when Options.WatchBuffer is unset.
+const defaultWatchBuffer = 256
+
+// watcher is a single subscription created by Watch.
+type watcher struct {
+	bucket  string
+	prefix  string
+	ch      chan Event
+	dropped uint64
+	done    chan struct{} // Closed when the subscription ends
+
+	// Events of writes not yet in the file, delivered on the next flush.
+	// Like ch, held is bounded by the buffer size and the overflow policy.
+	held        []Event
+	heldDropped uint64 // Events discarded after the last held one
+}
+
+// matches reports whether ev concerns a key w is watching.
+func (w *watcher) matches(ev Event) bool {
+	return ev.Bucket == w.bucket && (ev.Type == EventClear || strings.HasPrefix(ev.Key, w.prefix))
+}
+
+// Watch subscribes to changes of keys in the default keyspace starting with
+// prefix ("" watches every key); use Bucket.Watch for buckets. Events are
+// delivered on the returned channel after the change has been persisted, in
+// the order the changes reached disk; under a relaxed SyncPolicy, snapshot
+// mode holds them until the next sync. The channel is closed when ctx is
+// done, when the store is closed, or on buffer overflow under OverflowClose.
+//
+//	events := store.Watch(ctx, "config:")
+//	for ev := range events {
+//	    log.Printf("%s %s (v%d)", ev.Type, ev.Key, ev.Version)
+//	}
+func (s *Store) Watch(ctx context.Context, prefix string) <-chan Event {
+	return s.watch(ctx, "", prefix)
+}
+
+// watch subscribes to changes of keys in bucket starting with prefix.
+func (s *Store) watch(ctx context.Context, bucket, prefix string) <-chan Event {
+	size := s.options.WatchBuffer
+	if size <= 0 {
+		size = defaultWatchBuffer
+	}
+	w := &watcher{
+		bucket: bucket,
+		prefix: prefix,
+		ch:     make(chan Event, size),
+		done:   make(chan struct{}),
+	}
+
+	s.watchMu.Lock()
+	select {
+	case <-s.stop:
+		// Store already closed
+		s.watchMu.Unlock()
+		close(w.ch)
+		return w.ch
+	default:
+	}
+	s.watchers[w] = struct{}{}
+	s.watchMu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		select {
+		case <-ctx.Done():
+		case <-s.stop:
+		case <-w.done:
+			return // Already removed by the overflow policy
+		}
+		s.watchMu.Lock()
+		s.removeWatcherLocked(w)
+		s.watchMu.Unlock()
+	}()
+
+	return w.ch
+}
+
+// publish delivers persisted changes to matching watchers without blocking.
+func (s *Store) publish(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	for w := range s.watchers {
+		for _, ev := range events {
+			if w.matches(ev) && !s.deliverLocked(w, ev) {
+				break
+			}
+		}
+	}
+}
+
+// hold queues changes that are not yet in the file for the matching
+// watchers, to be delivered by releaseHeld. Without watchers, nothing is
+// kept. A full queue is handled like a full buffer under the overflow
+// policy, with its Dropped counts carried by the held events.
+func (s *Store) hold(events []Event) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+watchers:
+	for w := range s.watchers {
+		for _, ev := range events {
+			if !w.matches(ev) {
+				continue
+			}
+			ev.Dropped, w.heldDropped = w.heldDropped, 0
+			if len(w.held) < cap(w.ch) {
+				w.held = append(w.held, ev)
+				continue
+			}
+			switch s.options.WatchOverflow {
+			case OverflowDropNewest:
+				w.heldDropped += ev.Dropped + 1
+			case OverflowClose:
+				w.held = nil
+				s.removeWatcherLocked(w)
+				continue watchers
+			default:
+				// The next held event reports the discarded one
+				dropped := w.held[0].Dropped + 1
+				w.held = append(w.held[1:], ev)
+				w.held[0].Dropped += dropped
+			}
+		}
+	}
+}
+
+// releaseHeld delivers the events queued by hold, now that they are in
+// the file.
+func (s *Store) releaseHeld() {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	for w := range s.watchers {
+		held := w.held
+		w.held = nil
+		for _, ev := range held {
+			w.dropped += ev.Dropped
+			if !s.deliverLocked(w, ev) {
+				break
+			}
+		}
+		w.dropped += w.heldDropped
+		w.heldDropped = 0
+	}
+}
+
+// deliverLocked sends ev to w according to the overflow policy.
+// It returns false if the watcher was closed. The caller must hold s.watchMu.
+func (s *Store) deliverLocked(w *watcher, ev Event) bool {
+	ev.Dropped = w.dropped
+	select {
+	case w.ch <- ev:
+		w.dropped = 0
+		return true
+	default:
+	}
+
+	switch s.options.WatchOverflow {
+	case OverflowDropNewest:
+		w.dropped++
+	case OverflowClose:
+		s.removeWatcherLocked(w)
+		return false
+	default:
+		// Make room by discarding the oldest event; the consumer may have
+		// drained the buffer concurrently, in which case nothing is lost
+		select {
+		case old := <-w.ch:
+			// Carry over the drops the discarded event was reporting
+			w.dropped += old.Dropped + 1
+		default:
+		}
+		ev.Dropped = w.dropped
+		select {
+		case w.ch <- ev:
+			w.dropped = 0
+		default:
+			w.dropped++
+		}
+	}
+	return true
+}
+
+// removeWatcherLocked unsubscribes w and closes its channel once.
+// The caller must hold s.watchMu.
+func (s *Store) removeWatcherLocked(w *watcher) {
+	if _, ok := s.watchers[w]; !ok {
+		return
+	}
+	delete(s.watchers, w)
+	close(w.done)
+	close(w.ch)
+}
@@ -0,0 +1,213 @@
+package app
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// nextEvent reads one event or fails the test after a timeout.
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("watch channel closed unexpectedly")
+		}
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return Event{}
+}
+
+func TestWatch(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts Options
+	}{
+		{"snapshot mode", Options{}},
+		{"ledger mode", Options{LedgerMode: true}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store, err := NewWithOptions(filepath.Join(t.TempDir(), "test.db"), tc.opts)
+			if err != nil {
+				t.Fatalf("NewWithOptions() failed: %v", err)
+			}
+			defer store.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			events := store.Watch(ctx, "user:")
+
+			store.Set("user:1", "alice")
+			store.Set("order:1", "ignored")
+			store.Set("user:1", "bob")
+			store.Delete("user:1")
+			store.BatchSet(map[string]interface{}{"user:2": 2})
+			store.Clear()
+
+			ev := nextEvent(t, events)
+			if ev.Type != EventSet || ev.Key != "user:1" || string(ev.NewValue) != `"alice"` || ev.OldValue != nil || ev.Version != 1 {
+				t.Errorf("unexpected first event: %+v", ev)
+			}
+			ev = nextEvent(t, events)
+			if ev.Type != EventSet || string(ev.OldValue) != `"alice"` || string(ev.NewValue) != `"bob"` || ev.Version != 2 {
+				t.Errorf("unexpected overwrite event: %+v", ev)
+			}
+			ev = nextEvent(t, events)
+			if ev.Type != EventDelete || ev.Key != "user:1" || string(ev.OldValue) != `"bob"` || ev.NewValue != nil {
+				t.Errorf("unexpected delete event: %+v", ev)
+			}
+			ev = nextEvent(t, events)
+			if ev.Type != EventSet || ev.Key != "user:2" {
+				t.Errorf("unexpected batch event: %+v", ev)
+			}
+			ev = nextEvent(t, events)
+			if ev.Type != EventClear {
+				t.Errorf("expected clear event, got %+v", ev)
+			}
+
+			cancel()
+			select {
+			case _, ok := <-events:
+				if ok {
+					t.Error("expected no further events after cancel")
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("channel not closed after context cancel")
+			}
+		})
+	}
+}
+
+func TestWatchTransactionsAndExpiry(t *testing.T) {
+	store, err := NewWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{
+		ReaperInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewWithOptions() failed: %v", err)
+	}
+	defer store.Close()
+
+	events := store.Watch(context.Background(), "")
+
+	err = store.Update(func(tx *Tx) error {
+		tx.Set("a", 1)
+		return tx.Set("b", 2)
+	})
+	if err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if ev := nextEvent(t, events); ev.Key != "a" || ev.Type != EventSet {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+	if ev := nextEvent(t, events); ev.Key != "b" || ev.Type != EventSet {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+
+	// Failed writes are not published
+	if _, err := store.SetIfVersion("a", 10, 5); err == nil {
+		t.Fatal("expected version mismatch")
+	}
+
+	store.SetWithTTL("session", "x", 20*time.Millisecond)
+	if ev := nextEvent(t, events); ev.Key != "session" || ev.Type != EventSet {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+	ev := nextEvent(t, events)
+	if ev.Type != EventExpired || ev.Key != "session" || string(ev.OldValue) != `"x"` {
+		t.Errorf("expected expired event, got %+v", ev)
+	}
+}
+
+func TestWatchOverflow(t *testing.T) {
+	t.Run("drop oldest", func(t *testing.T) {
+		store, err := NewWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{WatchBuffer: 2})
+		if err != nil {
+			t.Fatalf("NewWithOptions() failed: %v", err)
+		}
+		defer store.Close()
+
+		events := store.Watch(context.Background(), "")
+		for i := 1; i <= 5; i++ {
+			store.Set("k", i)
+		}
+
+		first := nextEvent(t, events)
+		second := nextEvent(t, events)
+		if string(first.NewValue) != "4" || string(second.NewValue) != "5" {
+			t.Errorf("expected newest events 4 and 5, got %s and %s", first.NewValue, second.NewValue)
+		}
+		if first.Dropped+second.Dropped != 3 {
+			t.Errorf("expected 3 dropped events reported, got %d", first.Dropped+second.Dropped)
+		}
+	})
+
+	t.Run("drop newest", func(t *testing.T) {
+		store, err := NewWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{
+			WatchBuffer:   2,
+			WatchOverflow: OverflowDropNewest,
+		})
+		if err != nil {
+			t.Fatalf("NewWithOptions() failed: %v", err)
+		}
+		defer store.Close()
+
+		events := store.Watch(context.Background(), "")
+		for i := 1; i <= 5; i++ {
+			store.Set("k", i)
+		}
+
+		if ev := nextEvent(t, events); string(ev.NewValue) != "1" {
+			t.Errorf("expected oldest event kept, got %s", ev.NewValue)
+		}
+		nextEvent(t, events)
+		store.Set("k", 6)
+		ev := nextEvent(t, events)
+		if string(ev.NewValue) != "6" || ev.Dropped != 3 {
+			t.Errorf("expected event 6 reporting 3 drops, got %s with %d", ev.NewValue, ev.Dropped)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		store, err := NewWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{
+			WatchBuffer:   1,
+			WatchOverflow: OverflowClose,
+		})
+		if err != nil {
+			t.Fatalf("NewWithOptions() failed: %v", err)
+		}
+		defer store.Close()
+
+		events := store.Watch(context.Background(), "")
+		store.Set("k", 1)
+		store.Set("k", 2)
+
+		nextEvent(t, events)
+		if _, ok := <-events; ok {
+			t.Error("expected channel to be closed after overflow")
+		}
+	})
+}
+
+func TestWatchStoreClose(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	events := store.Watch(context.Background(), "")
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if _, ok := <-events; ok {
+		t.Error("expected channel to be closed by store Close")
+	}
+	if _, ok := <-store.Watch(context.Background(), ""); ok {
+		t.Error("expected Watch on a closed store to return a closed channel")
+	}
+}
@@ -7,9 +7,12 @@ require (
 	github.com/golang/snappy v1.0.0
 	github.com/klauspost/compress v1.18.1
 	github.com/redis/go-redis/v9 v9.16.0
+	golang.org/x/crypto v0.46.0
+	golang.org/x/term v0.38.0
 )
 
 require (
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	golang.org/x/sys v0.39.0 // indirect
 )
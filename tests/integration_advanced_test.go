@@ -259,6 +259,32 @@ func TestIntegration_EncryptionKeyRotation(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("rotate key in place", func(t *testing.T) {
+		storePath := filepath.Join(tmpDir, "rotated.db")
+		store, err := codex.NewWithOptions(storePath, codex.Options{EncryptionKey: key1, LedgerMode: true})
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+		store.Set("key1", "value1")
+		if err := store.RotateKey(key2); err != nil {
+			t.Fatalf("failed to rotate key: %v", err)
+		}
+		store.Set("key2", "value2")
+		store.Close()
+
+		store, err = codex.NewWithOptions(storePath, codex.Options{EncryptionKey: key2, LedgerMode: true})
+		if err != nil {
+			t.Fatalf("failed to reopen store with the new key: %v", err)
+		}
+		defer store.Close()
+		for k, expectedValue := range map[string]string{"key1": "value1", "key2": "value2"} {
+			var actualValue string
+			if err := store.Get(k, &actualValue); err != nil || actualValue != expectedValue {
+				t.Errorf("key %s: expected %s, got %s (err=%v)", k, expectedValue, actualValue, err)
+			}
+		}
+	})
 }
 
 // TestIntegration_LedgerReplay tests ledger mode operation replay